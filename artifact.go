@@ -0,0 +1,135 @@
+package bloc_client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+	"github.com/fBloc/bloc-client-go/internal/object_storage"
+	"github.com/pkg/errors"
+)
+
+// Artifact is the opt value of a FileValueType opt.
+// the file itself is kept in object storage, downstream function nodes
+// and the frontend get this downloadable reference instead of the content.
+type Artifact struct {
+	ObjectStorageKey string `json:"object_storage_key"`
+	Filename         string `json:"filename"`
+	ContentType      string `json:"content_type"`
+	Size             int64  `json:"size"`
+}
+
+func (a Artifact) String() string {
+	return fmt.Sprintf("%s (%s, %s)", a.Filename, a.ContentType, humanize.Bytes(uint64(a.Size)))
+}
+
+type artifactWriterCtxKey struct{}
+
+var ErrArtifactWriterNotAvailable = errors.New("artifact writer not available, object storage is not configured")
+
+// ArtifactWriter streams files produced by a function run into object storage
+// and registers them as the function run's opt fields.
+// get it in Run by ArtifactWriterFromContext.
+type ArtifactWriter struct {
+	functionRunRecordID string
+	objectStorage       object_storage.ObjectStorage
+	artifacts           map[string]*Artifact
+	sync.Mutex
+}
+
+func newArtifactWriter(
+	functionRunRecordID string, objectStorage object_storage.ObjectStorage,
+) *ArtifactWriter {
+	return &ArtifactWriter{
+		functionRunRecordID: functionRunRecordID,
+		objectStorage:       objectStorage,
+		artifacts:           make(map[string]*Artifact)}
+}
+
+// ArtifactWriterFromContext returns the ArtifactWriter of the current function run.
+// ok is false if the client has no object storage configured.
+func ArtifactWriterFromContext(ctx context.Context) (writer *ArtifactWriter, ok bool) {
+	writer, ok = ctx.Value(artifactWriterCtxKey{}).(*ArtifactWriter)
+	return writer, ok && writer != nil
+}
+
+func setArtifactWriterToContext(ctx context.Context, writer *ArtifactWriter) context.Context {
+	return context.WithValue(ctx, artifactWriterCtxKey{}, writer)
+}
+
+func (aW *ArtifactWriter) objectStorageKey(optKey string) string {
+	return "artifact/" + aW.functionRunRecordID + "/" + optKey
+}
+
+// Write streams the file from reader into object storage and registers it as
+// the value of the opt field optKey, which should be defined as FileValueType.
+// writing to the same optKey again replaces the former file.
+func (aW *ArtifactWriter) Write(
+	optKey, filename, contentType string, reader io.Reader,
+) (*Artifact, error) {
+	if aW == nil || aW.objectStorage == nil {
+		return nil, ErrArtifactWriterNotAvailable
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	key := aW.objectStorageKey(optKey)
+	size, err := aW.objectStorage.SetFromReader(
+		key, reader,
+		object_storage.Meta{ContentType: contentType, Filename: filename})
+	if err != nil {
+		return nil, errors.Wrapf(err, "write artifact of opt %s failed", optKey)
+	}
+
+	artifact := &Artifact{
+		ObjectStorageKey: key,
+		Filename:         filename,
+		ContentType:      contentType,
+		Size:             size}
+	aW.Lock()
+	defer aW.Unlock()
+	aW.artifacts[optKey] = artifact
+	return artifact, nil
+}
+
+// WriteBytes is Write for data already in memory
+func (aW *ArtifactWriter) WriteBytes(
+	optKey, filename, contentType string, data []byte,
+) (*Artifact, error) {
+	return aW.Write(optKey, filename, contentType, bytes.NewReader(data))
+}
+
+// Artifacts returns the written artifacts by their opt key
+func (aW *ArtifactWriter) Artifacts() map[string]Artifact {
+	aW.Lock()
+	defer aW.Unlock()
+	resp := make(map[string]Artifact, len(aW.artifacts))
+	for optKey, artifact := range aW.artifacts {
+		resp[optKey] = *artifact
+	}
+	return resp
+}
+
+// registerArtifacts sets written artifacts as the opt's detail values.
+// values the function already set by itself are kept.
+func (opt *FunctionRunOpt) registerArtifacts(writer *ArtifactWriter) {
+	if writer == nil {
+		return
+	}
+	artifacts := writer.Artifacts()
+	if len(artifacts) == 0 {
+		return
+	}
+	if opt.Detail == nil {
+		opt.Detail = make(map[string]interface{}, len(artifacts))
+	}
+	for optKey, artifact := range artifacts {
+		if _, ok := opt.Detail[optKey]; !ok {
+			opt.Detail[optKey] = artifact
+		}
+	}
+}
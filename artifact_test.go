@@ -0,0 +1,97 @@
+package bloc_client
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type csvExportFunction struct{}
+
+func (*csvExportFunction) AllProgressMilestones() []string {
+	return []string{}
+}
+
+func (*csvExportFunction) IptConfig() Ipts {
+	return Ipts{}
+}
+
+func (*csvExportFunction) OptConfig() Opts {
+	return Opts{
+		{
+			Key:       "report",
+			ValueType: FileValueType,
+		},
+	}
+}
+
+func (*csvExportFunction) Run(
+	ctx context.Context,
+	ipts Ipts,
+	progressReportChan chan HighReadableFunctionRunProgress,
+	blocOptChan chan *FunctionRunOpt,
+	logger *Logger,
+) {
+	writer, ok := ArtifactWriterFromContext(ctx)
+	if !ok {
+		blocOptChan <- NewFailedFunctionRunOpt("no artifact writer")
+		return
+	}
+	_, err := writer.Write(
+		"report", "report.csv", "text/csv",
+		strings.NewReader("a,b\n1,2\n"))
+	if err != nil {
+		blocOptChan <- NewFailedFunctionRunOpt("write report failed: %v", err)
+		return
+	}
+	blocOptChan <- &FunctionRunOpt{Suc: true}
+}
+
+func TestArtifactWriterTestRun(t *testing.T) {
+	funcRunOpt := NewTestClient().TestRunFunction(&csvExportFunction{}, nil)
+	if !funcRunOpt.Suc {
+		t.Fatalf("test run should suc: %s", funcRunOpt.ErrorMsg)
+	}
+	artifact, ok := funcRunOpt.Detail["report"].(Artifact)
+	if !ok || artifact.Filename != "report.csv" || artifact.Size != 8 {
+		t.Fatalf("report should be registered as artifact: %+v", funcRunOpt.Detail)
+	}
+}
+
+func TestArtifactWriterConsumer(t *testing.T) {
+	objectStorage := NewMemoryObjectStorage()
+	client, server, eventMQ := newMockClient(t, func(cb *ConfigBuilder) {
+		cb.SetObjectStorage(objectStorage)
+	})
+	client.RegisterFunctionGroup("export").AddFunction("csv", "", &csvExportFunction{})
+	if err := client.RegisterFunctionsToServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	server.addFunctionRunRecord(&FunctionRunRecord{
+		ID: "record_1", FunctionID: "export-csv"})
+	publishClientRunFunction(t, eventMQ, "record_1")
+
+	go client.FunctionRunConsumer()
+
+	finished := waitFinished(t, server)
+	if !finished.Suc {
+		t.Fatalf("function run should suc: %+v", finished)
+	}
+	if brief := finished.OptKeyMapBriefData["report"]; brief != "report.csv (text/csv, 8 B)" {
+		t.Errorf("unexpected artifact brief: %s", brief)
+	}
+
+	data, meta, err := objectStorage.GetWithMeta("artifact/record_1/report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "a,b\n1,2\n" || meta.Filename != "report.csv" || meta.ContentType != "text/csv" {
+		t.Errorf("unexpected stored artifact: %s, %+v", data, meta)
+	}
+	persisted, _ := server.getPersistedOpt("record_1-report").(map[string]interface{})
+	if persisted["object_storage_key"] != "artifact/record_1/report" {
+		t.Errorf("persisted opt should be the artifact reference: %v", persisted)
+	}
+	waitAllAcked(t, eventMQ)
+}
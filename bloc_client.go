@@ -4,15 +4,25 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fBloc/bloc-client-go/internal/conns/minio"
+	"github.com/fBloc/bloc-client-go/internal/http_util"
 	"github.com/fBloc/bloc-client-go/internal/mq"
+	"github.com/fBloc/bloc-client-go/internal/mq/jetstream"
 	"github.com/fBloc/bloc-client-go/internal/mq/rabbit"
+	"github.com/fBloc/bloc-client-go/internal/mq/redis_stream"
 	"github.com/fBloc/bloc-client-go/internal/object_storage"
+	memoryOS "github.com/fBloc/bloc-client-go/internal/object_storage/memory"
 	minioInf "github.com/fBloc/bloc-client-go/internal/object_storage/minio"
+	"github.com/fBloc/bloc-client-go/internal/outbox"
 )
 
 const serverBasicPathPrefix = "/api/v1/client/"
@@ -20,33 +30,270 @@ const serverBasicPathPrefix = "/api/v1/client/"
 type BlocServerConfig struct {
 	IP   string
 	Port int
+	// URLs are the base urls of the server replicas, used instead of IP &
+	// Port if set. like https://bloc.example.com/bloc behind an ingress
+	URLs []string
+	// LoadBalance is how URLs are picked, ServerFailover by default
+	LoadBalance ServerLoadBalance
+	// Streaming sends progress & logs through a streaming request if set
+	Streaming *ServerStreamConfig
+	// TLS makes the server called by https://
+	TLS *ServerTLSConfig
+	// Auth authenticates every request to the server
+	Auth ServerAuthenticator
+	// Timeout is of each attempt of a request, defaults to 30s
+	Timeout time.Duration
+	// Retry is for the requests safe to be handled more than once,
+	// like run reports. NonIdempotentRetry is for the others, like log
+	// uploads, which are only retried if they surely not reach the server
+	Retry              *ServerRetryPolicy
+	NonIdempotentRetry *ServerRetryPolicy
+	// CircuitBreaker makes requests fail fast after the server keeps failing
+	CircuitBreaker *ServerCircuitBreakerConfig
+}
+
+// ServerRetryPolicy is the exponential backoff with jitter of retrying requests
+type ServerRetryPolicy = http_util.RetryPolicy
+
+// ServerCircuitBreakerConfig is when the circuit breaker opens & probes again
+type ServerCircuitBreakerConfig = http_util.CircuitBreakerConfig
+
+func (bSC *BlocServerConfig) httpConfig(endpoints []*url.URL) http_util.Config {
+	return http_util.Config{
+		Auth:               bSC.Auth,
+		Timeout:            bSC.Timeout,
+		Retry:              bSC.Retry,
+		NonIdempotentRetry: bSC.NonIdempotentRetry,
+		CircuitBreaker:     bSC.CircuitBreaker,
+		Endpoints:          endpoints,
+		LoadBalance:        bSC.LoadBalance}
+}
+
+// ServerLoadBalance is how the server replicas are picked for a request.
+// replicas failing in a row are skipped until their circuit breaker probes
+// again, a retry goes to another replica if there is a healthy one
+type ServerLoadBalance = http_util.LoadBalance
+
+const (
+	// ServerFailover sends to the first healthy replica in order
+	ServerFailover = http_util.Failover
+	// ServerRoundRobin rotates among the healthy replicas
+	ServerRoundRobin = http_util.RoundRobin
+)
+
+// ServerTLSConfig is the CA bundle, client certificate & verify options
+// of calling bloc-server by https://, all files are PEM encoded
+type ServerTLSConfig = http_util.TLSConfig
+
+// ServerAuthenticator sets the credentials to every request to bloc-server
+type ServerAuthenticator = http_util.Authenticator
+
+// BearerTokenAuth authenticates by `Authorization: Bearer <token>`
+func BearerTokenAuth(token string) ServerAuthenticator {
+	return http_util.BearerToken(token)
+}
+
+// HMACAuth signs every request by the secret shared with bloc-server.
+// the signature is the hex HMAC-SHA256 of the method, request uri, unix
+// timestamp, client name & hex SHA256 of the body joined by "\n"
+func HMACAuth(clientName string, secret []byte) ServerAuthenticator {
+	return http_util.HMACSigner{ClientName: clientName, Secret: secret}
+}
+
+// ServerOption sets the optional fields of BlocServerConfig
+type ServerOption func(*BlocServerConfig)
+
+// WithServerAuth authenticates every request to bloc-server by auth
+func WithServerAuth(auth ServerAuthenticator) ServerOption {
+	return func(bSC *BlocServerConfig) {
+		bSC.Auth = auth
+	}
+}
+
+// WithServerTimeout sets the timeout of each attempt of a request
+func WithServerTimeout(timeout time.Duration) ServerOption {
+	return func(bSC *BlocServerConfig) {
+		bSC.Timeout = timeout
+	}
+}
+
+// WithServerRetry sets the retry policies of idempotent & non-idempotent requests
+func WithServerRetry(idempotent, nonIdempotent ServerRetryPolicy) ServerOption {
+	return func(bSC *BlocServerConfig) {
+		bSC.Retry = &idempotent
+		bSC.NonIdempotentRetry = &nonIdempotent
+	}
+}
+
+// WithServerCircuitBreaker sets when the circuit breaker opens & probes again
+func WithServerCircuitBreaker(conf ServerCircuitBreakerConfig) ServerOption {
+	return func(bSC *BlocServerConfig) {
+		bSC.CircuitBreaker = &conf
+	}
+}
+
+// WithServerLoadBalance sets how the server replicas of SetServerURL are picked
+func WithServerLoadBalance(loadBalance ServerLoadBalance) ServerOption {
+	return func(bSC *BlocServerConfig) {
+		bSC.LoadBalance = loadBalance
+	}
+}
+
+// WithServerTLS makes bloc-server called by https://,
+// with the client certificate of tlsConf it's mutual TLS
+func WithServerTLS(tlsConf ServerTLSConfig) ServerOption {
+	return func(bSC *BlocServerConfig) {
+		bSC.TLS = &tlsConf
+	}
 }
 
 func (bSC *BlocServerConfig) IsNil() bool {
 	if bSC == nil {
 		return true
 	}
-	return bSC.IP == "" || bSC.Port == 0
+	return len(bSC.URLs) == 0 && (bSC.IP == "" || bSC.Port == 0)
 }
 
 func (bSC *BlocServerConfig) String() string {
+	if len(bSC.URLs) > 0 {
+		return strings.Join(bSC.URLs, ",")
+	}
 	return fmt.Sprintf("%s:%d", bSC.IP, bSC.Port)
 }
 
+// endpoints returns the base urls of the client api of the server replicas
+func (bSC *BlocServerConfig) endpoints() ([]*url.URL, error) {
+	rawUrls := bSC.URLs
+	if len(rawUrls) == 0 {
+		rawUrls = []string{net.JoinHostPort(bSC.IP, strconv.Itoa(bSC.Port))}
+	}
+	endpoints := make([]*url.URL, 0, len(rawUrls))
+	for _, rawUrl := range rawUrls {
+		endpoint, err := http_util.ParseURL(rawUrl, bSC.scheme())
+		if err != nil {
+			return nil, err
+		}
+		endpoint.Path = path.Join("/", endpoint.Path, serverBasicPathPrefix)
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+func (bSC *BlocServerConfig) scheme() string {
+	if bSC.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
 type RabbitConfig struct {
 	User     string
 	Password string
 	Host     []string
 	Vhost    string
+	// TLS makes hosts dialed by amqps://
+	TLS *RabbitTLSConfig
+	// ExternalAuth authenticates by the TLS client certificate through
+	// SASL EXTERNAL, user & password are not needed then
+	ExternalAuth bool
+	// ConfirmTimeout is how long publishing waits for the broker's confirm
+	ConfirmTimeout time.Duration
+	// Prefetch is the max unacked runs, defaults to twice the concurrency
+	Prefetch int
+	// MaxPriority declares queues with `x-max-priority`, 0 means no priority
+	MaxPriority uint8
 }
 
+// RabbitTLSConfig is the CA bundle, client certificate & verify options
+// of an amqps:// connection, all files are PEM encoded
+type RabbitTLSConfig = rabbit.TLSConfig
+
 func (rC *RabbitConfig) IsNil() bool {
+	return (*rabbit.RabbitConfig)(rC).IsNil()
+}
+
+// RabbitOption sets the optional fields of RabbitConfig
+type RabbitOption func(*RabbitConfig)
+
+// WithRabbitTLS makes rabbitMQ connected by amqps://
+func WithRabbitTLS(tlsConf RabbitTLSConfig) RabbitOption {
+	return func(rC *RabbitConfig) {
+		rC.TLS = &tlsConf
+	}
+}
+
+// WithRabbitExternalAuth authenticates by the client certificate of
+// WithRabbitTLS through SASL EXTERNAL instead of user & password
+func WithRabbitExternalAuth() RabbitOption {
+	return func(rC *RabbitConfig) {
+		rC.ExternalAuth = true
+	}
+}
+
+// WithRabbitConfirmTimeout sets how long publishing waits for the broker's confirm
+func WithRabbitConfirmTimeout(timeout time.Duration) RabbitOption {
+	return func(rC *RabbitConfig) {
+		rC.ConfirmTimeout = timeout
+	}
+}
+
+// WithRabbitPrefetch sets the max unacked runs pulled from rabbitMQ.
+// the more runs prefetched, the more runs the scheduler picks by priority from
+func WithRabbitPrefetch(prefetch int) RabbitOption {
+	return func(rC *RabbitConfig) {
+		rC.Prefetch = prefetch
+	}
+}
+
+// WithRabbitMaxPriority declares queues with `x-max-priority`, so runs
+// published with higher priority are delivered first.
+// an existing queue declared without it must be deleted first
+func WithRabbitMaxPriority(maxPriority uint8) RabbitOption {
+	return func(rC *RabbitConfig) {
+		rC.MaxPriority = maxPriority
+	}
+}
+
+// JetStreamConfig selects NATS JetStream as the event mq instead of rabbitMQ.
+// zero values of the optional fields fall back to jetstream's defaults
+type JetStreamConfig struct {
+	URLs          []string
+	User          string
+	Password      string
+	Token         string
+	StreamName    string
+	SubjectPrefix string
+	MaxDeliver    int
+	AckWait       time.Duration
+}
+
+func (jC *JetStreamConfig) IsNil() bool {
+	if jC == nil {
+		return true
+	}
+	return len(jC.URLs) <= 0
+}
+
+// RedisStreamConfig selects redis streams(redis >= 6.2) as the event mq
+// instead of rabbitMQ. zero values of the optional fields fall back to
+// redis_stream's defaults
+type RedisStreamConfig struct {
+	Addr         string
+	Username     string
+	Password     string
+	DB           int
+	StreamPrefix string
+	ClaimMinIdle time.Duration
+	MaxDeliver   int
+	MaxLen       int64
+	MaxUnacked   int
+}
+
+func (rC *RedisStreamConfig) IsNil() bool {
 	if rC == nil {
 		return true
 	}
-	return len(rC.Host) <= 0 ||
-		rC.User == "" ||
-		rC.Password == ""
+	return rC.Addr == ""
 }
 
 type MinioConfig struct {
@@ -66,23 +313,106 @@ func (mF *MinioConfig) IsNil() bool {
 
 type ConfigBuilder struct {
 	ServerConf *BlocServerConfig
+	// ServerClient replaces the http client of the server api if set
+	ServerClient ServerClient
+	// HTTPClient is used to call the server api, a default one if nil
+	HTTPClient *http.Client
 	RabbitConf *RabbitConfig
-	MinioConf  *MinioConfig
+	// RabbitStateCallbacks are called on every rabbitMQ connection state change
+	RabbitStateCallbacks []func(RabbitStateChange)
+	// ConfigChangedCallbacks are called with the new config after the
+	// client's config is changed from bloc-server
+	ConfigChangedCallbacks []func(map[string]string)
+	JetStreamConf          *JetStreamConfig
+	RedisConf              *RedisStreamConfig
+	MinioConf              *MinioConfig
+	CompressConf           *CompressConfig
+	EventMQ                MsgQueue
+	ObjectStorage          ObjectStorage
+	// ContentAddressed makes opt values persisted to object storage directly
+	// by the key derived from their content's hash
+	ContentAddressed bool
+	// Subscriptions limits the functions this replica runs, all if empty
+	Subscriptions []FunctionSubscription
+	// Concurrency is the max function runs at the same time, <= 0 means 1
+	Concurrency int
+	// OutboxConf keeps the run reports on disk while the server is unavailable
+	OutboxConf *OutboxConfig
+	outbox     *outbox.Outbox
+	// LogShipperConf is how run logs are uploaded, DefaultLogShipperConfig if nil
+	LogShipperConf *LogShipperConfig
 }
 
-func (confbder *ConfigBuilder) SetServer(ip string, port int) *ConfigBuilder {
+func (confbder *ConfigBuilder) SetServer(
+	ip string, port int,
+	options ...ServerOption,
+) *ConfigBuilder {
 	confbder.ServerConf = &BlocServerConfig{IP: ip, Port: port}
+	for _, option := range options {
+		option(confbder.ServerConf)
+	}
+	return confbder
+}
+
+// SetServerURL sets the base urls of the server replicas, with scheme &
+// the path prefix if it's behind an ingress, like https://bloc.example.com/bloc.
+// replicas are picked by WithServerLoadBalance, failover by default
+func (confbder *ConfigBuilder) SetServerURL(
+	serverURLs []string,
+	options ...ServerOption,
+) *ConfigBuilder {
+	confbder.ServerConf = &BlocServerConfig{URLs: serverURLs}
+	for _, option := range options {
+		option(confbder.ServerConf)
+	}
 	return confbder
 }
 
+// SetRabbitConfig user & password can be empty with WithRabbitExternalAuth
 func (confbder *ConfigBuilder) SetRabbitConfig(
 	user, password string, host []string, vHost string,
+	options ...RabbitOption,
 ) *ConfigBuilder {
 	confbder.RabbitConf = &RabbitConfig{
 		User:     user,
 		Password: password,
 		Host:     host,
 		Vhost:    vHost}
+	for _, option := range options {
+		option(confbder.RabbitConf)
+	}
+	return confbder
+}
+
+// OnRabbitStateChange registers a callback called on every rabbitMQ connection
+// state change, e.g. to alert on disconnection. it should not block
+func (confbder *ConfigBuilder) OnRabbitStateChange(
+	callback func(RabbitStateChange),
+) *ConfigBuilder {
+	confbder.RabbitStateCallbacks = append(confbder.RabbitStateCallbacks, callback)
+	return confbder
+}
+
+// OnConfigChanged registers a callback called with the new config after the
+// client's config is changed from bloc-server, needs broadcast support of the event mq
+func (confbder *ConfigBuilder) OnConfigChanged(
+	callback func(config map[string]string),
+) *ConfigBuilder {
+	confbder.ConfigChangedCallbacks = append(confbder.ConfigChangedCallbacks, callback)
+	return confbder
+}
+
+// SetJetStreamConfig makes function run events pulled from NATS JetStream
+// instead of rabbitMQ, rabbit config is not needed then
+func (confbder *ConfigBuilder) SetJetStreamConfig(conf JetStreamConfig) *ConfigBuilder {
+	confbder.JetStreamConf = &conf
+	return confbder
+}
+
+// SetRedisStreamConfig makes function run events pulled from redis streams
+// instead of rabbitMQ, rabbit config is not needed then
+func (confbder *ConfigBuilder) SetRedisStreamConfig(conf RedisStreamConfig) *ConfigBuilder {
+	confbder.RedisConf = &conf
 	return confbder
 }
 
@@ -98,20 +428,186 @@ func (confbder *ConfigBuilder) SetMinioConfig(
 	return confbder
 }
 
+// SetCompression makes opt values & object storage data whose size reaches
+// threshold(in bytes) compressed before persisted. a threshold <= 0 means
+// using DefaultCompressThreshold. reads are always decompressed automatically.
+// compressed opts are persisted through bloc-server with an `encoding` field,
+// they are persisted uncompressed to a bloc-server not knowing it
+func (confbder *ConfigBuilder) SetCompression(
+	encoding CompressEncoding, threshold int,
+) *ConfigBuilder {
+	if threshold <= 0 {
+		threshold = DefaultCompressThreshold
+	}
+	confbder.CompressConf = &CompressConfig{
+		Encoding:  encoding,
+		Threshold: threshold}
+	return confbder
+}
+
+// EnableContentAddressedStorage makes opt values persisted to object storage
+// under the key derived from their content's hash instead of by bloc-server.
+// the upload is skipped when the same content already exists, so that runs
+// producing the same large outputs share one blob. object storage must be set.
+func (confbder *ConfigBuilder) EnableContentAddressedStorage() *ConfigBuilder {
+	confbder.ContentAddressed = true
+	return confbder
+}
+
+// SubscribeFunctionGroups makes this replica only run functions of the groups.
+// runs of them are routed to `function_client_run_consumer.<client>.<group>`,
+// the routing key of each function is reported to bloc-server on registering.
+// replicas of the same client should not subscribe to overlapping functions.
+// names with '.', '*' or '#' panic, as they are segments of the routing key
+func (confbder *ConfigBuilder) SubscribeFunctionGroups(groupNames ...string) *ConfigBuilder {
+	for _, groupName := range groupNames {
+		checkRoutingName("group", groupName)
+		confbder.Subscriptions = append(
+			confbder.Subscriptions, FunctionSubscription{GroupName: groupName})
+	}
+	return confbder
+}
+
+// SubscribeFunction makes this replica run the function, routed by
+// `function_client_run_consumer.<client>.<group>.<function>`.
+// see SubscribeFunctionGroups
+func (confbder *ConfigBuilder) SubscribeFunction(groupName, functionName string) *ConfigBuilder {
+	checkRoutingName("group", groupName)
+	checkRoutingName("function", functionName)
+	confbder.Subscriptions = append(
+		confbder.Subscriptions,
+		FunctionSubscription{GroupName: groupName, FunctionName: functionName})
+	return confbder
+}
+
+// SetConcurrency sets the max function runs at the same time.
+// when saturated, waiting runs are picked by priority
+func (confbder *ConfigBuilder) SetConcurrency(concurrency int) *ConfigBuilder {
+	confbder.Concurrency = concurrency
+	return confbder
+}
+
+// SetOutboxConfig makes the run reports(start, progress, finished & opt
+// persist) failed as the server is unavailable kept in conf.Dir, they are
+// replayed in order once the server is back instead of lost
+func (confbder *ConfigBuilder) SetOutboxConfig(conf OutboxConfig) *ConfigBuilder {
+	confbder.OutboxConf = &conf
+	return confbder
+}
+
+// SetLogShipperConfig sets the buffer & batches of uploading run logs
+func (confbder *ConfigBuilder) SetLogShipperConfig(conf LogShipperConfig) *ConfigBuilder {
+	confbder.LogShipperConf = &conf
+	return confbder
+}
+
+// SetServerClient inject a ServerClient instead of the http one talking to
+// the server set by SetServer, to mock, proxy or wrap the server calls
+func (confbder *ConfigBuilder) SetServerClient(serverClient ServerClient) *ConfigBuilder {
+	confbder.ServerClient = serverClient
+	return confbder
+}
+
+// SetHTTPClient makes the server api called by httpClient,
+// for proxies, custom transports or timeouts
+func (confbder *ConfigBuilder) SetHTTPClient(httpClient *http.Client) *ConfigBuilder {
+	confbder.HTTPClient = httpClient
+	return confbder
+}
+
+// SetEventMQ inject a ready to use MsgQueue instead of connecting to rabbitMQ.
+// mostly used to test with the in-memory implementation from NewMemoryMsgQueue
+func (confbder *ConfigBuilder) SetEventMQ(eventMQ MsgQueue) *ConfigBuilder {
+	confbder.EventMQ = eventMQ
+	return confbder
+}
+
+// SetObjectStorage inject a ready to use ObjectStorage instead of connecting to minio.
+// mostly used to test with the in-memory implementation from NewMemoryObjectStorage
+func (confbder *ConfigBuilder) SetObjectStorage(objectStorage ObjectStorage) *ConfigBuilder {
+	confbder.ObjectStorage = objectStorage
+	return confbder
+}
+
 func (congbder *ConfigBuilder) BuildUp() {
 	// ServerConf http server 地址配置。
-	if congbder.ServerConf.IsNil() {
+	if congbder.ServerConf.IsNil() && congbder.ServerClient == nil {
 		panic("must set bloc-server address")
 	}
+	if !congbder.ServerConf.IsNil() {
+		if _, err := congbder.ServerConf.endpoints(); err != nil {
+			panic(fmt.Sprintf("invalid bloc-server address: %v", err))
+		}
+		if !congbder.ServerConf.LoadBalance.IsValid() {
+			panic("invalid bloc-server load balance: " + string(congbder.ServerConf.LoadBalance))
+		}
+		if congbder.ServerConf.Streaming != nil &&
+			!http_util.CanAuthenticateStream(congbder.ServerConf.Auth) {
+			panic(errStreamingNotAuthenticated.Error())
+		}
+	}
+	if !congbder.ServerConf.IsNil() && congbder.ServerConf.TLS != nil {
+		httpClient, err := http_util.WithTLS(congbder.HTTPClient, congbder.ServerConf.TLS)
+		if err != nil {
+			panic(fmt.Sprintf("set up bloc-server TLS failed: %v", err))
+		}
+		congbder.HTTPClient = httpClient
+	}
 
 	// RabbitConf。需要检查输入的配置能够建立有效的链接
-	if congbder.RabbitConf.IsNil() {
-		panic("must set rabbit config")
+	// 已注入了EventMQ或配置了jetstream/redis的无需rabbit
+	if !congbder.JetStreamConf.IsNil() && !congbder.RedisConf.IsNil() {
+		panic("should only set one of jetstream & redis stream config")
+	}
+	if congbder.EventMQ == nil && !congbder.JetStreamConf.IsNil() {
+		jsMQ, err := jetstream.Connect((*jetstream.JetStreamConfig)(congbder.JetStreamConf))
+		if err != nil {
+			panic(fmt.Sprintf("connect to jetstream failed: %v", err))
+		}
+		congbder.EventMQ = jsMQ
+	}
+	if congbder.EventMQ == nil && !congbder.RedisConf.IsNil() {
+		redisMQ, err := redis_stream.Connect((*redis_stream.RedisStreamConfig)(congbder.RedisConf))
+		if err != nil {
+			panic(fmt.Sprintf("connect to redis failed: %v", err))
+		}
+		congbder.EventMQ = redisMQ
+	}
+	if congbder.EventMQ == nil {
+		if congbder.RabbitConf.IsNil() {
+			panic("must set rabbit config")
+		}
+		if congbder.RabbitConf.Prefetch <= 0 && congbder.Concurrency > 1 {
+			congbder.RabbitConf.Prefetch = 2 * congbder.Concurrency
+		}
+		rabbitMQ, err := rabbit.Connect(
+			(*rabbit.RabbitConfig)(congbder.RabbitConf), congbder.RabbitStateCallbacks...)
+		if err != nil {
+			panic(fmt.Sprintf("connect to rabbit failed: %v", err))
+		}
+		congbder.EventMQ = rabbitMQ
+	}
+
+	if congbder.ContentAddressed && congbder.ObjectStorage == nil && congbder.MinioConf.IsNil() {
+		panic("content addressed storage must set object storage")
+	}
+
+	if !congbder.CompressConf.IsNil() && !congbder.CompressConf.Encoding.IsValid() {
+		panic("invalid compress encoding: " + string(congbder.CompressConf.Encoding))
+	}
+
+	if !congbder.OutboxConf.IsNil() {
+		ob, err := outbox.Open(
+			congbder.OutboxConf.Dir,
+			congbder.OutboxConf.InitialBackoff, congbder.OutboxConf.MaxBackoff)
+		if err != nil {
+			panic(fmt.Sprintf("open outbox failed: %v", err))
+		}
+		congbder.outbox = ob
 	}
-	rabbit.InitChannel((*rabbit.RabbitConfig)(congbder.RabbitConf))
 
 	// MinioConf 如果输入了，需要查看minIO是否能够有效工作
-	if !congbder.MinioConf.IsNil() {
+	if congbder.ObjectStorage == nil && !congbder.MinioConf.IsNil() {
 		minio.Init((*minio.MinioConfig)(congbder.MinioConf))
 	}
 }
@@ -184,6 +680,9 @@ type blocClient struct {
 	configBuilder  *ConfigBuilder
 	eventMQ        mq.MsgQueue
 	objectStorage  object_storage.ObjectStorage
+	serverClient   ServerClient
+	logs           *logShipper
+	runningRuns    runningRuns
 	sync.Mutex
 }
 
@@ -191,7 +690,10 @@ func NewTestClient() *blocClient {
 	return NewClient("local_test")
 }
 
+// NewClient panics on a name with '.', '*' or '#', as it's a segment of
+// the routing key
 func NewClient(clientName string) *blocClient {
+	checkRoutingName("client", clientName)
 	return &blocClient{
 		Name: clientName,
 	}
@@ -200,9 +702,9 @@ func NewClient(clientName string) *blocClient {
 func (bC *blocClient) CreateFunctionRunLogger(
 	funcRunRecordID string,
 ) *Logger {
-	return NewLogger(
+	return newLogger(
 		"func-run-record",
-		bC.GenReqServerPath(), funcRunRecordID)
+		bC.logShipper(), funcRunRecordID)
 }
 
 // GetConfigBuilder
@@ -211,9 +713,11 @@ func (bloc *blocClient) GetConfigBuilder() *ConfigBuilder {
 	return bloc.configBuilder
 }
 
+// RegisterFunctionGroup panics on a registered name, or a name with '.', '*' or '#'
 func (bloc *blocClient) RegisterFunctionGroup(
 	name string,
 ) *FunctionGroup {
+	checkRoutingName("group", name)
 	for _, i := range bloc.FunctionGroups {
 		if i.Name == name {
 			panic("should not register same name group")
@@ -232,14 +736,32 @@ func (bC *blocClient) GetOrCreateEventMQ() mq.MsgQueue {
 	if bC.eventMQ != nil {
 		return bC.eventMQ
 	}
+	if bC.configBuilder.EventMQ != nil {
+		bC.eventMQ = bC.configBuilder.EventMQ
+		return bC.eventMQ
+	}
 
-	rabbitMQ := rabbit.InitChannel(
-		(*rabbit.RabbitConfig)(bC.configBuilder.RabbitConf))
+	rabbitMQ, err := rabbit.Connect(
+		(*rabbit.RabbitConfig)(bC.configBuilder.RabbitConf),
+		bC.configBuilder.RabbitStateCallbacks...)
+	if err != nil {
+		panic(fmt.Sprintf("connect to rabbit failed: %v", err))
+	}
 	bC.eventMQ = rabbitMQ
 
 	return bC.eventMQ
 }
 
+// RabbitReconnectHistory returns the latest reconnects of the rabbitMQ connection,
+// nil if rabbitMQ is not used
+func (bC *blocClient) RabbitReconnectHistory() []RabbitReconnectRecord {
+	rabbitMQ, ok := bC.GetOrCreateEventMQ().(*rabbit.RabbitMQ)
+	if !ok {
+		return nil
+	}
+	return rabbitMQ.ReconnectHistory()
+}
+
 func (bC *blocClient) GetFunctionByID(functionID string) Function {
 	for _, fGroup := range bC.FunctionGroups {
 		for _, f := range fGroup.Functions {
@@ -251,32 +773,53 @@ func (bC *blocClient) GetFunctionByID(functionID string) Function {
 	return Function{}
 }
 
+func (bC *blocClient) concurrency() int {
+	if bC.configBuilder == nil || bC.configBuilder.Concurrency <= 0 {
+		return 1
+	}
+	return bC.configBuilder.Concurrency
+}
+
+// HasObjectStorage reports whether object storage is configured
+func (bC *blocClient) HasObjectStorage() bool {
+	if bC.configBuilder == nil {
+		return false
+	}
+	return bC.configBuilder.ObjectStorage != nil || !bC.configBuilder.MinioConf.IsNil()
+}
+
 func (bC *blocClient) GetOrCreateObjectStorage() object_storage.ObjectStorage {
 	if bC.objectStorage != nil {
 		return bC.objectStorage
 	}
 	bC.Lock()
 	defer bC.Unlock()
-
-	minioOS := minioInf.New(
-		bC.configBuilder.MinioConf.Addresses,
-		bC.configBuilder.MinioConf.AccessKey,
-		bC.configBuilder.MinioConf.AccessPassword,
-		bC.configBuilder.MinioConf.BucketName,
-	)
-	bC.objectStorage = minioOS
+	if bC.configBuilder.ObjectStorage != nil {
+		bC.objectStorage = bC.configBuilder.ObjectStorage
+	} else {
+		bC.objectStorage = minioInf.New(
+			bC.configBuilder.MinioConf.Addresses,
+			bC.configBuilder.MinioConf.AccessKey,
+			bC.configBuilder.MinioConf.AccessPassword,
+			bC.configBuilder.MinioConf.BucketName,
+		)
+	}
+	if compressConf := bC.configBuilder.CompressConf; !compressConf.IsNil() {
+		bC.objectStorage = object_storage.NewCompressed(
+			bC.objectStorage, compressConf.Encoding, compressConf.Threshold)
+	}
 
 	return bC.objectStorage
 }
 
+// GenReqServerPath returns the url of subPaths under the client api of
+// the first server replica
 func (bC *blocClient) GenReqServerPath(subPaths ...string) string {
-	resp := path.Join(
-		bC.configBuilder.ServerConf.String(),
-		serverBasicPathPrefix)
-	for _, subPath := range subPaths {
-		resp = path.Join(resp, subPath)
+	endpoints, err := bC.configBuilder.ServerConf.endpoints()
+	if err != nil {
+		return ""
 	}
-	return resp
+	return http_util.JoinURL(endpoints[0], path.Join(subPaths...))
 }
 
 func (bC *blocClient) TestRunFunction(
@@ -311,9 +854,17 @@ func (bC *blocClient) TestRunFunction(
 		}
 	}
 
+	// artifacts are kept in memory during test run
+	artifactWriter := newArtifactWriter("test_run", memoryOS.New())
+	ctx := setArtifactWriterToContext(context.TODO(), artifactWriter)
+	// events are logged instead of published during test run
+	ctx = setEventPublisherToContext(ctx, &EventPublisher{
+		clientName:          bC.Name,
+		functionRunRecordID: "test_run",
+		publish:             logEvent})
 	go func() {
 		userFunction.Run(
-			context.TODO(),
+			ctx,
 			userFunctionIpts,
 			progressReportChan,
 			functionRunOptChan,
@@ -327,6 +878,9 @@ func (bC *blocClient) TestRunFunction(
 			log.Printf("reporting progress: %v", runningStatus)
 		// 运行成功完成
 		case funcRunOpt := <-functionRunOptChan:
+			if funcRunOpt.Suc {
+				funcRunOpt.registerArtifacts(artifactWriter)
+			}
 			log.Printf("run finished with resp: %+v", funcRunOpt)
 			return *funcRunOpt
 		}
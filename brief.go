@@ -0,0 +1,175 @@
+package bloc_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cast"
+)
+
+const (
+	briefMaxRuneAmount  = 51
+	briefArrayItemLimit = 3
+	briefJsonKeyLimit   = 5
+	briefEllipsis       = "…"
+)
+
+// BriefProvider can be optionally implemented by a function node
+// to build the brief of it's opt values shown in the frontend.
+type BriefProvider interface {
+	// OptBrief returns the brief of the opt value.
+	// return ok false to fall back to the default brief
+	OptBrief(optKey string, value interface{}) (brief string, ok bool)
+}
+
+// OptBrief build the brief of the function's opt value.
+// user implemented BriefProvider is preferred
+func (f *Function) OptBrief(optKey string, value interface{}) string {
+	if provider, ok := f.ExeFunc.(BriefProvider); ok {
+		if brief, ok := provider.OptBrief(optKey, value); ok {
+			return brief
+		}
+	}
+
+	for _, opt := range f.Opts {
+		if opt.Key == optKey {
+			return defaultOptBrief(opt.ValueType, value)
+		}
+	}
+	return defaultOptBrief("", value)
+}
+
+func truncateRunes(s string, maxAmount int) (string, bool) {
+	runes := []rune(s)
+	if len(runes) <= maxAmount {
+		return s, false
+	}
+	return string(runes[:maxAmount]), true
+}
+
+// defaultOptBrief:
+// - array: it's length & the first few items. e.g. `[1, 2, 3, …] (10 items)`
+// - json object: it's keys. e.g. `{age, name} (2 keys, 35 B)`
+// - long string/json: truncated with it's size. e.g. `lorem ipsum…(12 kB)`
+// - artifact: it's filename, content type & size
+// - other scalars: the value itself
+func defaultOptBrief(valueType ValueType, value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case Artifact:
+		return v.String()
+	case *Artifact:
+		return v.String()
+	}
+
+	rv := reflect.ValueOf(value)
+	if _, isByte := value.([]byte); !isByte &&
+		(rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) {
+		return arrayBrief(valueType, rv)
+	}
+
+	if valueType == JsonValueType || rv.Kind() == reflect.Map || rv.Kind() == reflect.Struct {
+		return jsonBrief(value)
+	}
+	return scalarBrief(value, briefMaxRuneAmount)
+}
+
+// toString converts the value to string, values cannot be cast are json marshaled
+func toString(value interface{}) string {
+	str, err := cast.ToStringE(value)
+	if err == nil {
+		return str
+	}
+	byteData, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(byteData)
+}
+
+func scalarBrief(value interface{}, maxRuneAmount int) string {
+	str := toString(value)
+	truncated, isTruncated := truncateRunes(str, maxRuneAmount)
+	if !isTruncated {
+		return str
+	}
+	return fmt.Sprintf("%s%s(%s)", truncated, briefEllipsis, humanize.Bytes(uint64(len(str))))
+}
+
+func arrayBrief(valueType ValueType, rv reflect.Value) string {
+	amount := rv.Len()
+	if amount == 0 {
+		return "[] (0 items)"
+	}
+
+	itemBriefs := make([]string, 0, briefArrayItemLimit+1)
+	itemMaxRuneAmount := briefMaxRuneAmount / briefArrayItemLimit
+	for i := 0; i < amount && i < briefArrayItemLimit; i++ {
+		item := rv.Index(i).Interface()
+		if valueType == JsonValueType {
+			byteData, err := json.Marshal(item)
+			if err == nil {
+				item = string(byteData)
+			}
+		}
+		itemBrief, isTruncated := truncateRunes(toString(item), itemMaxRuneAmount)
+		if isTruncated {
+			itemBrief += briefEllipsis
+		}
+		itemBriefs = append(itemBriefs, itemBrief)
+	}
+	if amount > briefArrayItemLimit {
+		itemBriefs = append(itemBriefs, briefEllipsis)
+	}
+
+	unit := "items"
+	if amount == 1 {
+		unit = "item"
+	}
+	return fmt.Sprintf("[%s] (%d %s)", strings.Join(itemBriefs, ", "), amount, unit)
+}
+
+func jsonBrief(value interface{}) string {
+	var byteData []byte
+	if str, ok := value.(string); ok {
+		byteData = []byte(str)
+	} else {
+		var err error
+		byteData, err = json.Marshal(value)
+		if err != nil {
+			return scalarBrief(value, briefMaxRuneAmount)
+		}
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(byteData, &obj); err != nil || obj == nil {
+		return scalarBrief(string(byteData), briefMaxRuneAmount)
+	}
+
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	shownKeys := keys
+	if len(keys) > briefJsonKeyLimit {
+		shownKeys = append(keys[:briefJsonKeyLimit:briefJsonKeyLimit], briefEllipsis)
+	}
+	keysBrief, isTruncated := truncateRunes(strings.Join(shownKeys, ", "), briefMaxRuneAmount)
+	if isTruncated {
+		keysBrief += briefEllipsis
+	}
+
+	unit := "keys"
+	if len(keys) == 1 {
+		unit = "key"
+	}
+	return fmt.Sprintf(
+		"{%s} (%d %s, %s)",
+		keysBrief, len(keys), unit, humanize.Bytes(uint64(len(byteData))))
+}
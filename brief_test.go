@@ -0,0 +1,60 @@
+package bloc_client
+
+import (
+	"strings"
+	"testing"
+)
+
+type briefProviderFunction struct {
+	sumFunction
+}
+
+func (*briefProviderFunction) OptBrief(optKey string, value interface{}) (string, bool) {
+	if optKey != "sum" {
+		return "", false
+	}
+	return "sum is " + toString(value), true
+}
+
+func TestDefaultOptBrief(t *testing.T) {
+	longString := strings.Repeat("a", 2000)
+	cases := []struct {
+		name      string
+		valueType ValueType
+		value     interface{}
+		brief     string
+	}{
+		{"int", IntValueType, 3, "3"},
+		{"bool", BoolValueType, true, "true"},
+		{"short string", StringValueType, "hello", "hello"},
+		{"long string", StringValueType, longString, strings.Repeat("a", briefMaxRuneAmount) + "…(2.0 kB)"},
+		{"empty array", IntValueType, []int{}, "[] (0 items)"},
+		{"single item array", IntValueType, []int{1}, "[1] (1 item)"},
+		{"array", IntValueType, []int{1, 2, 3, 4, 5}, "[1, 2, 3, …] (5 items)"},
+		{"json object", JsonValueType, map[string]interface{}{"name": "bloc", "age": 1}, "{age, name} (2 keys, 23 B)"},
+		{"json string", JsonValueType, `{"b": 1, "a": 2}`, "{a, b} (2 keys, 16 B)"},
+		{"json array", JsonValueType, []map[string]int{{"a": 1}}, `[{"a":1}] (1 item)`},
+		{"nil", StringValueType, nil, ""},
+	}
+	for _, c := range cases {
+		if brief := defaultOptBrief(c.valueType, c.value); brief != c.brief {
+			t.Errorf("%s: brief should be %q, get %q", c.name, c.brief, brief)
+		}
+	}
+}
+
+func TestFunctionOptBrief(t *testing.T) {
+	group := &FunctionGroup{Name: "math"}
+	group.AddFunction("sum", "", &briefProviderFunction{})
+	group.AddFunction("plain_sum", "", &sumFunction{})
+
+	if brief := group.Functions[0].OptBrief("sum", 6); brief != "sum is 6" {
+		t.Errorf("BriefProvider should be used, get %q", brief)
+	}
+	if brief := group.Functions[0].OptBrief("other", []int{1}); brief != "[1] (1 item)" {
+		t.Errorf("should fall back to default brief, get %q", brief)
+	}
+	if brief := group.Functions[1].OptBrief("sum", 6); brief != "6" {
+		t.Errorf("default brief should be used, get %q", brief)
+	}
+}
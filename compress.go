@@ -0,0 +1,40 @@
+package bloc_client
+
+import (
+	"github.com/fBloc/bloc-client-go/internal/compress"
+)
+
+// CompressEncoding is the algorithm persisted data is compressed by
+type CompressEncoding = compress.Encoding
+
+const (
+	GzipCompress CompressEncoding = compress.Gzip
+	ZstdCompress CompressEncoding = compress.Zstd
+)
+
+// DefaultCompressThreshold is used when compress is set without a threshold
+const DefaultCompressThreshold = 1024
+
+// CompressConfig makes data whose size reaches Threshold(in bytes) compressed by
+// Encoding before persisted. smaller data is persisted as is.
+type CompressConfig struct {
+	Encoding  CompressEncoding
+	Threshold int
+}
+
+func (cC *CompressConfig) IsNil() bool {
+	if cC == nil {
+		return true
+	}
+	return cC.Encoding == compress.Identity
+}
+
+// compressIfLarge compresses data by the configured compress config.
+// the returned encoding is blank if data is not compressed
+func (bC *blocClient) compressIfLarge(data []byte) ([]byte, CompressEncoding, error) {
+	conf := bC.configBuilder.CompressConf
+	if conf.IsNil() {
+		return data, compress.Identity, nil
+	}
+	return compress.EncodeIfLarge(conf.Encoding, conf.Threshold, data)
+}
@@ -0,0 +1,122 @@
+package bloc_client
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/event"
+	"github.com/pkg/errors"
+)
+
+// CustomEvent is the event published by EventPublisher
+type CustomEvent = event.CustomEvent
+
+// CustomEventTopic is the topic custom events of the name are published to
+// by the client's function nodes
+func CustomEventTopic(clientName, name string) string {
+	return event.CustomEventTopic(clientName, name)
+}
+
+type eventPublisherCtxKey struct{}
+
+var (
+	ErrEventPublisherNotAvailable = errors.New("event publisher not available")
+	ErrInvalidCustomEventName     = errors.New("custom event name should be non-empty and without '.', '*' or '#'")
+)
+
+// EventPublisher publishes custom events in the middle of a function run,
+// tagged with the run's trace id & function run record id.
+// get it in Run by EventPublisherFromContext.
+type EventPublisher struct {
+	clientName          string
+	functionID          string
+	functionRunRecordID string
+	traceID             string
+	spanID              string
+	publish             func(event.DomainEvent) error
+}
+
+// EventPublisherFromContext returns the EventPublisher of the current function run
+func EventPublisherFromContext(ctx context.Context) (publisher *EventPublisher, ok bool) {
+	publisher, ok = ctx.Value(eventPublisherCtxKey{}).(*EventPublisher)
+	return publisher, ok && publisher != nil
+}
+
+func setEventPublisherToContext(ctx context.Context, publisher *EventPublisher) context.Context {
+	return context.WithValue(ctx, eventPublisherCtxKey{}, publisher)
+}
+
+// Publish marshals data to json and publishes it as the custom event name,
+// to the topic CustomEventTopic(clientName, name)
+func (eP *EventPublisher) Publish(name string, data interface{}) error {
+	if eP == nil || eP.publish == nil {
+		return ErrEventPublisherNotAvailable
+	}
+	if name == "" || strings.ContainsAny(name, ".*#") {
+		return errors.Wrap(ErrInvalidCustomEventName, name)
+	}
+
+	e := &CustomEvent{
+		Name:                name,
+		ClientName:          eP.clientName,
+		FunctionID:          eP.functionID,
+		FunctionRunRecordID: eP.functionRunRecordID,
+		TraceID:             eP.traceID,
+		SpanID:              eP.spanID,
+		PublishedAt:         time.Now()}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return errors.Wrapf(err, "marshal data of custom event %s failed", name)
+		}
+		e.Data = raw
+	}
+	return errors.Wrapf(eP.publish(e), "publish custom event %s failed", name)
+}
+
+// logEvent is the publish of test run, which has no event mq
+func logEvent(e event.DomainEvent) error {
+	data, err := e.Marshal()
+	if err != nil {
+		return err
+	}
+	log.Printf("publishing event to %s: %s", e.Topic(), data)
+	return nil
+}
+
+// ListenCustomEvent calls handler with the custom events of the name published
+// by sourceClient's function nodes. listeners of the same listenerTag share the events.
+// an event is acked after handled, or requeued if handler returns error
+func (bC *blocClient) ListenCustomEvent(
+	sourceClient, name, listenerTag string,
+	handler func(*CustomEvent) error,
+) error {
+	event.InjectMq(bC.GetOrCreateEventMQ())
+	customEventChan := make(chan event.DomainEvent)
+	err := event.ListenEvent(
+		&CustomEvent{ClientName: sourceClient, Name: name},
+		listenerTag, customEventChan)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for e := range customEventChan {
+			customEvent, ok := e.(*CustomEvent)
+			if !ok {
+				e.Delivery().Nack(false)
+				continue
+			}
+			if err := handler(customEvent); err != nil {
+				log.Printf("handle custom event %s failed: %v", customEvent.Name, err)
+				e.Delivery().Nack(true)
+				continue
+			}
+			event.AckEvent(e)
+		}
+	}()
+	return nil
+}
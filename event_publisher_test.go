@@ -0,0 +1,99 @@
+package bloc_client
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type notifyFunction struct{}
+
+func (*notifyFunction) AllProgressMilestones() []string {
+	return []string{}
+}
+
+func (*notifyFunction) IptConfig() Ipts {
+	return Ipts{}
+}
+
+func (*notifyFunction) OptConfig() Opts {
+	return Opts{}
+}
+
+func (*notifyFunction) Run(
+	ctx context.Context,
+	ipts Ipts,
+	progressReportChan chan HighReadableFunctionRunProgress,
+	blocOptChan chan *FunctionRunOpt,
+	logger *Logger,
+) {
+	publisher, ok := EventPublisherFromContext(ctx)
+	if !ok {
+		blocOptChan <- NewFailedFunctionRunOpt("no event publisher")
+		return
+	}
+	if err := publisher.Publish("user.created", nil); !errors.Is(err, ErrInvalidCustomEventName) {
+		blocOptChan <- NewFailedFunctionRunOpt("invalid name should fail: %v", err)
+		return
+	}
+	err := publisher.Publish("user_created", map[string]string{"user": "tom"})
+	if err != nil {
+		blocOptChan <- NewFailedFunctionRunOpt("publish failed: %v", err)
+		return
+	}
+	blocOptChan <- &FunctionRunOpt{Suc: true}
+}
+
+func TestEventPublisherTestRun(t *testing.T) {
+	funcRunOpt := NewTestClient().TestRunFunction(&notifyFunction{}, nil)
+	if !funcRunOpt.Suc {
+		t.Fatalf("test run should suc: %s", funcRunOpt.ErrorMsg)
+	}
+}
+
+func TestEventPublisherConsumer(t *testing.T) {
+	client, server, eventMQ := newMockClient(t)
+	client.RegisterFunctionGroup("notify").AddFunction("user", "", &notifyFunction{})
+	if err := client.RegisterFunctionsToServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	customEventChan := make(chan *CustomEvent, 1)
+	err := client.ListenCustomEvent(
+		mockClientName, "user_created", "audit",
+		func(e *CustomEvent) error {
+			customEventChan <- e
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.addFunctionRunRecord(&FunctionRunRecord{
+		ID: "record_1", FunctionID: "notify-user", TraceID: "trace_1"})
+	publishClientRunFunction(t, eventMQ, "record_1")
+
+	go client.FunctionRunConsumer()
+
+	if finished := waitFinished(t, server); !finished.Suc {
+		t.Fatalf("function run should suc: %+v", finished)
+	}
+	select {
+	case e := <-customEventChan:
+		if e.FunctionRunRecordID != "record_1" || e.TraceID != "trace_1" ||
+			e.FunctionID != "notify-user" || e.Topic() != "custom_event.mock_client.user_created" {
+			t.Fatalf("unexpected custom event: %+v", e)
+		}
+		var data map[string]string
+		json.Unmarshal(e.Data, &data)
+		if data["user"] != "tom" {
+			t.Errorf("unexpected custom event data: %s", e.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait custom event timeout")
+	}
+	waitAllAcked(t, eventMQ)
+}
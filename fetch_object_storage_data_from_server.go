@@ -1,6 +1,9 @@
 package bloc_client
 
 import (
+	"context"
+
+	"github.com/fBloc/bloc-client-go/internal/compress"
 	"github.com/fBloc/bloc-client-go/internal/http_util"
 )
 
@@ -8,15 +11,45 @@ const fetchObjectStorageDataByKeyFromServerPath = "get_byte_value_by_key"
 
 type ServerObjectStorageHttpResp struct {
 	StatusCode int    `json:"status_code"`
+	StatusMsg  string `json:"status_msg"`
 	Data       []byte `json:"data"`
+	// Encoding is the encoding marker kept in the object's metadata.
+	// a bloc-server not knowing it responds none, the encoding of the data
+	// is told by it's magic number then
+	Encoding CompressEncoding `json:"encoding"`
+}
+
+func (resp *ServerObjectStorageHttpResp) serverStatus() (int, string) {
+	return resp.StatusCode, resp.StatusMsg
+}
+
+func (hSC *httpServerClient) FetchObjectStorageData(
+	ctx context.Context, key string,
+) ([]byte, CompressEncoding, error) {
+	var resp ServerObjectStorageHttpResp
+	err := hSC.client.Get(
+		ctx, hSC.apiPath(fetchObjectStorageDataByKeyFromServerPath, key),
+		http_util.BlankHeader, &resp)
+	err = checkResp(fetchObjectStorageDataByKeyFromServerPath, err, &resp)
+	return resp.Data, resp.Encoding, err
 }
 
 func (bC *blocClient) FetchObjectStorageDataByKeyFromServer(
 	key string,
 ) ([]byte, error) {
-	var resp ServerObjectStorageHttpResp
-	err := http_util.Get(
-		bC.GenReqServerPath(fetchObjectStorageDataByKeyFromServerPath, key),
-		http_util.BlankHeader, &resp)
-	return resp.Data, err
+	return bC.FetchObjectStorageDataByKeyFromServerCtx(context.Background(), key)
+}
+
+// FetchObjectStorageDataByKeyFromServerCtx is FetchObjectStorageDataByKeyFromServer bounded by ctx
+func (bC *blocClient) FetchObjectStorageDataByKeyFromServerCtx(
+	ctx context.Context, key string,
+) ([]byte, error) {
+	data, encoding, err := bC.ServerClient().FetchObjectStorageData(ctx, key)
+	if err != nil {
+		return data, err
+	}
+	if encoding == compress.Identity {
+		encoding = compress.Sniff(data)
+	}
+	return compress.Decode(encoding, data)
 }
@@ -1,27 +1,50 @@
 package bloc_client
 
-import "github.com/fBloc/bloc-client-go/internal/http_util"
+import (
+	"context"
+	"strings"
+
+	"github.com/fBloc/bloc-client-go/internal/http_util"
+)
 
 const FlowRunIsCanceledPath = "/check_flowRun_is_canceled_by_flowRunID/"
 
 type FlowRunIsCanceledHttpResp struct {
-	StatusCode int `json:"status_code"`
+	StatusCode int    `json:"status_code"`
+	StatusMsg  string `json:"status_msg"`
 	Data       struct {
 		Canceled bool `json:"canceled"`
 	} `json:"data"`
 	Canceled bool `json:"canceled"`
 }
 
-func (bC *blocClient) FlowRunIsCanceled(
-	flowRunRecordID string,
+func (resp *FlowRunIsCanceledHttpResp) serverStatus() (int, string) {
+	return resp.StatusCode, resp.StatusMsg
+}
+
+func (hSC *httpServerClient) FlowRunIsCanceled(
+	ctx context.Context, flowRunRecordID string,
 ) (bool, error) {
 	var resp FlowRunIsCanceledHttpResp
-	err := http_util.Get(
-		bC.GenReqServerPath(FlowRunIsCanceledPath, flowRunRecordID),
+	err := hSC.client.Get(
+		ctx, hSC.apiPath(FlowRunIsCanceledPath, flowRunRecordID),
 		http_util.BlankHeader,
 		&resp)
-	if err != nil {
+	if err := checkResp(strings.Trim(FlowRunIsCanceledPath, "/"), err, &resp); err != nil {
 		return false, err
 	}
 	return resp.Data.Canceled, nil
 }
+
+func (bC *blocClient) FlowRunIsCanceled(
+	flowRunRecordID string,
+) (bool, error) {
+	return bC.FlowRunIsCanceledCtx(context.Background(), flowRunRecordID)
+}
+
+// FlowRunIsCanceledCtx is FlowRunIsCanceled bounded by ctx
+func (bC *blocClient) FlowRunIsCanceledCtx(
+	ctx context.Context, flowRunRecordID string,
+) (bool, error) {
+	return bC.ServerClient().FlowRunIsCanceled(ctx, flowRunRecordID)
+}
@@ -4,231 +4,290 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"sync"
+	"log"
 	"time"
 
 	"github.com/fBloc/bloc-client-go/internal/event"
-	"github.com/spf13/cast"
 )
 
 func (bC *blocClient) FunctionRunConsumer() {
 	event.InjectMq(bC.GetOrCreateEventMQ())
 	funcToRunEventChan := make(chan event.DomainEvent)
-	err := event.ListenEvent(
-		&event.ClientRunFunction{ClientName: bC.Name},
-		bC.Name, funcToRunEventChan)
-	if err != nil {
-		panic(err)
+	subscriptions := bC.subscriptions()
+	if len(subscriptions) == 0 {
+		err := event.ListenEvent(
+			&event.ClientRunFunction{ClientName: bC.Name},
+			bC.Name, funcToRunEventChan)
+		if err != nil {
+			panic(err)
+		}
+	}
+	// each subscription has it's own queue shared by the replicas subscribed to it
+	for _, subscription := range subscriptions {
+		err := event.ListenEvent(
+			&event.ClientRunFunction{
+				ClientName:   bC.Name,
+				GroupName:    subscription.GroupName,
+				FunctionName: subscription.FunctionName},
+			subscription.queueName(bC.Name), funcToRunEventChan)
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	var wg sync.WaitGroup
+	bC.listenBroadcastEvents()
+
+	// runs are acked after finished. submit blocks while the scheduler is full,
+	// so that the rest are left in the event mq for other replicas
+	// instead of held unacked here
+	scheduler := newRunScheduler(bC.concurrency(), 0, bC.runFunction)
 	for functionToRunEvent := range funcToRunEventChan {
-		wg.Add(1)
-		go func(e event.DomainEvent, wg *sync.WaitGroup) {
-			defer wg.Done()
-			defer event.AckEvent(e)
+		scheduler.submit(functionToRunEvent, runPriority(functionToRunEvent))
+	}
+}
+
+// serverUnavailableRequeueDelay is how long a run is delayed to be delivered
+// again when it cannot be started as the server is unavailable
+var serverUnavailableRequeueDelay = 5 * time.Second
 
-			functionRunRecordIDStr := e.Identity()
-			logger := bC.CreateFunctionRunLogger(functionRunRecordIDStr)
+// runLogFlushTimeout is the max wait for the logs of a finished run uploaded
+var runLogFlushTimeout = 10 * time.Second
 
-			funcRunRecordIns, err := bC.GetFunctionRunRecordByID(functionRunRecordIDStr)
+func (bC *blocClient) runFunction(e event.DomainEvent) {
+	requeue := false
+	defer func() {
+		if requeue {
+			// the worker is not held for the delay
+			e.Delivery().NackWithDelay(serverUnavailableRequeueDelay)
+			return
+		}
+		event.AckEvent(e)
+	}()
+
+	functionRunRecordIDStr := e.Identity()
+	logger := bC.CreateFunctionRunLogger(functionRunRecordIDStr)
+	defer func() {
+		// logs of the run are uploaded before it's acked
+		ctx, cancel := context.WithTimeout(context.Background(), runLogFlushTimeout)
+		defer cancel()
+		logger.Flush(ctx)
+	}()
+	cancelChan := bC.runningRuns.add(functionRunRecordIDStr)
+	defer bC.runningRuns.remove(functionRunRecordIDStr)
+
+	funcRunRecordIns, err := bC.GetFunctionRunRecordByIDCtx(context.TODO(), functionRunRecordIDStr)
+	if IsServerUnavailable(err) {
+		// the run is kept for the server to be back,
+		// logged locally as the server is down
+		log.Printf(
+			"get function_run_record_ins by id-%s failed as server is unavailable, requeue it. error: %v",
+			functionRunRecordIDStr, err)
+		requeue = true
+		return
+	}
+	if err != nil {
+		msg := fmt.Sprintf(
+			"get function_run_record_ins by id-%s failed. error: %v",
+			functionRunRecordIDStr, err)
+		logger.Errorf(msg)
+		funcRunOpt := NewFailedFunctionRunOpt(msg)
+		bC.ReportFuncRunFinished(context.TODO(), functionRunRecordIDStr, *funcRunOpt)
+		return
+	}
+
+	spanID := NewSpanID()
+	logger.SetTraceIDAndSpanID(funcRunRecordIns.TraceID, spanID)
+	logger.Infof("set trace_id: %s, spanID: %s", funcRunRecordIns.TraceID, spanID)
+
+	traceCtx := SetTraceIDAndSpanIDToContext(funcRunRecordIns.TraceID, spanID)
+	// make sure you copied functionIns! donnot disrupt the oringin functionIns
+	functionIns := bC.GetFunctionByID(funcRunRecordIns.FunctionID)
+	if functionIns.IsNil() {
+		msg := fmt.Sprintf(
+			"get function_ins by id-%s failed", funcRunRecordIns.FunctionID)
+		logger.Errorf(msg)
+		funcRunOpt := NewFailedFunctionRunOpt(msg)
+		bC.ReportFuncRunFinished(traceCtx, functionRunRecordIDStr, *funcRunOpt)
+		return
+	}
+	// runs of the same function may run at the same time, each sets it's own ipt values
+	functionIns.Ipts = functionIns.Ipts.copy()
+
+	// report function_run start
+	err = bC.ReportFuncRunStart(traceCtx, functionRunRecordIDStr)
+	if err != nil {
+		logger.Errorf("report function run start to server failed: %v", err)
+	}
+
+	// 从brief中恢复出完整的ipt以供运行
+	completeIptSuc := true
+	for iptIndex, ipt := range funcRunRecordIns.IptBriefAndObjectStoragekey {
+		for componentIndex, componentBrief := range ipt {
+			dataByte, err := bC.FetchObjectStorageDataByKeyFromServerCtx(traceCtx, componentBrief.ObjectStorageKey)
 			if err != nil {
 				msg := fmt.Sprintf(
-					"get function_run_record_ins by id-%s failed. error: %v",
-					functionRunRecordIDStr, err)
+					"get ipt value from objectStorage failed. iptIndex-%d, componentIndex-%d. componentBrief-%s. error: %v",
+					iptIndex, componentIndex, componentBrief, err)
 				logger.Errorf(msg)
 				funcRunOpt := NewFailedFunctionRunOpt(msg)
-				bC.ReportFuncRunFinished(context.TODO(), functionRunRecordIDStr, *funcRunOpt)
-				return
+				bC.ReportFuncRunFinished(traceCtx, functionRunRecordIDStr, *funcRunOpt)
+				completeIptSuc = false
+				break
 			}
 
-			spanID := NewSpanID()
-			logger.SetTraceIDAndSpanID(funcRunRecordIns.TraceID, spanID)
-			logger.Infof("set trace_id: %s, spanID: %s", funcRunRecordIns.TraceID, spanID)
-
-			traceCtx := SetTraceIDAndSpanIDToContext(funcRunRecordIns.TraceID, spanID)
-			// make sure you copied functionIns! donnot disrupt the oringin functionIns
-			functionIns := bC.GetFunctionByID(funcRunRecordIns.FunctionID)
-			if functionIns.IsNil() {
+			var data interface{}
+			err = json.Unmarshal(dataByte, &data)
+			if err != nil {
 				msg := fmt.Sprintf(
-					"get function_ins by id-%s failed", funcRunRecordIns.FunctionID)
+					"get ipt value from objectStorage suc, but json unmarshal it failed. iptIndex-%d, componentIndex-%d. componentBrief-%s. resp-string: %s. error: %v",
+					iptIndex, componentIndex, componentBrief, string(dataByte), err)
 				logger.Errorf(msg)
 				funcRunOpt := NewFailedFunctionRunOpt(msg)
 				bC.ReportFuncRunFinished(traceCtx, functionRunRecordIDStr, *funcRunOpt)
-				return
+				completeIptSuc = false
+				break
 			}
 
-			// report function_run start
-			err = bC.ReportFuncRunStart(traceCtx, functionRunRecordIDStr)
-			if err != nil {
-				logger.Errorf("report function run start to server failed: %v", err)
-			}
+			functionIns.Ipts[iptIndex].Components[componentIndex].Value = data
+		}
+	}
+	if !completeIptSuc {
+		return
+	}
 
-			// 从brief中恢复出完整的ipt以供运行
-			completeIptSuc := true
-			for iptIndex, ipt := range funcRunRecordIns.IptBriefAndObjectStoragekey {
-				for componentIndex, componentBrief := range ipt {
-					dataByte, err := bC.FetchObjectStorageDataByKeyFromServer(componentBrief.ObjectStorageKey)
-					if err != nil {
-						msg := fmt.Sprintf(
-							"get ipt value from objectStorage failed. iptIndex-%d, componentIndex-%d. componentBrief-%s. error: %v",
-							iptIndex, componentIndex, componentBrief, err)
-						logger.Errorf(msg)
-						funcRunOpt := NewFailedFunctionRunOpt(msg)
-						bC.ReportFuncRunFinished(traceCtx, functionRunRecordIDStr, *funcRunOpt)
-						completeIptSuc = false
-						break
-					}
-
-					var data interface{}
-					err = json.Unmarshal(dataByte, &data)
-					if err != nil {
-						msg := fmt.Sprintf(
-							"get ipt value from objectStorage suc, but json unmarshal it failed. iptIndex-%d, componentIndex-%d. componentBrief-%s. resp-string: %s. error: %v",
-							iptIndex, componentIndex, componentBrief, string(dataByte), err)
-						logger.Errorf(msg)
-						funcRunOpt := NewFailedFunctionRunOpt(msg)
-						bC.ReportFuncRunFinished(traceCtx, functionRunRecordIDStr, *funcRunOpt)
-						completeIptSuc = false
-						break
-					}
-
-					functionIns.Ipts[iptIndex].Components[componentIndex].Value = data
+	// 超时检测
+	timeOutChan := make(chan struct{})
+	if !funcRunRecordIns.ShouldBeCanceledAt.IsZero() { // 设置了整体运行的超时时长
+		if funcRunRecordIns.ShouldBeCanceledAt.Before(time.Now()) { // 已超时
+			msg := fmt.Sprintf(
+				"already timeout. timeout time is: %s, now is: %s",
+				funcRunRecordIns.ShouldBeCanceledAt.Format(time.RFC3339),
+				time.Now().Format(time.RFC3339))
+			logger.Errorf(msg)
+			funcRunOpt := NewTimeoutCanceldFunctionRunOpt()
+			bC.ReportFuncRunFinished(traceCtx, functionRunRecordIDStr, *funcRunOpt)
+			return
+		} else { // 未超时
+			timer := time.After(time.Until(funcRunRecordIns.ShouldBeCanceledAt))
+			go func() {
+				for range timer {
+					timeOutChan <- struct{}{}
 				}
-			}
-			if !completeIptSuc {
-				return
-			}
+			}()
+		}
+	}
 
-			// 超时检测
-			timeOutChan := make(chan struct{})
-			if !funcRunRecordIns.ShouldBeCanceledAt.IsZero() { // 设置了整体运行的超时时长
-				if funcRunRecordIns.ShouldBeCanceledAt.Before(time.Now()) { // 已超时
-					msg := fmt.Sprintf(
-						"already timeout. timeout time is: %s, now is: %s",
-						funcRunRecordIns.ShouldBeCanceledAt.Format(time.RFC3339),
-						time.Now().Format(time.RFC3339))
-					logger.Errorf(msg)
-					funcRunOpt := NewTimeoutCanceldFunctionRunOpt()
-					bC.ReportFuncRunFinished(traceCtx, functionRunRecordIDStr, *funcRunOpt)
-					return
-				} else { // 未超时
-					timer := time.After(time.Until(funcRunRecordIns.ShouldBeCanceledAt))
-					go func() {
-						for range timer {
-							timeOutChan <- struct{}{}
-						}
-					}()
-				}
-			}
+	cancelCheckTimer := time.NewTicker(6 * time.Second)
+	progressReportChan := make(chan HighReadableFunctionRunProgress)
+	functionRunOptChan := make(chan *FunctionRunOpt)
+	var funcRunOpt *FunctionRunOpt
+	ctx := context.Background()
+	var artifactWriter *ArtifactWriter
+	if bC.HasObjectStorage() {
+		artifactWriter = newArtifactWriter(
+			functionRunRecordIDStr, bC.GetOrCreateObjectStorage())
+		ctx = setArtifactWriterToContext(ctx, artifactWriter)
+	}
+	ctx = setEventPublisherToContext(ctx, &EventPublisher{
+		clientName:          bC.Name,
+		functionID:          funcRunRecordIns.FunctionID,
+		functionRunRecordID: functionRunRecordIDStr,
+		traceID:             funcRunRecordIns.TraceID,
+		spanID:              spanID,
+		publish:             event.Publish})
+	ctx, cancelFunctionExecute := context.WithCancel(ctx)
 
-			cancelCheckTimer := time.NewTicker(6 * time.Second)
-			progressReportChan := make(chan HighReadableFunctionRunProgress)
-			functionRunOptChan := make(chan *FunctionRunOpt)
-			var funcRunOpt *FunctionRunOpt
-			ctx := context.Background()
-			ctx, cancelFunctionExecute := context.WithCancel(ctx)
+	// run the function
+	go func() {
+		functionIns.ExeFunc.Run(
+			ctx, functionIns.Ipts,
+			progressReportChan, functionRunOptChan,
+			logger)
+	}()
 
-			// run the function
-			go func() {
-				functionIns.ExeFunc.Run(
-					ctx, functionIns.Ipts,
-					progressReportChan, functionRunOptChan,
-					logger)
-			}()
+	// read the real-time msg & forward 2 server
+	for {
+		select {
+		// 1. timeout
+		case <-timeOutChan:
+			logger.Infof("function run timeout canceled. function_run_record_id: %s", functionRunRecordIDStr)
+			funcRunOpt = &FunctionRunOpt{
+				Suc:             true,
+				TimeoutCanceled: true}
+			goto FunctionNodeRunFinished
+		// 2. flow is canceled
+		case <-cancelCheckTimer.C:
+			isCanceled, err := bC.FlowRunIsCanceledCtx(traceCtx, funcRunRecordIns.FlowRunRecordID)
+			if err == nil && isCanceled {
+				logger.Infof("function run is canceled from flow")
+				funcRunOpt = &FunctionRunOpt{
+					Suc:      true,
+					Canceled: true}
+				goto FunctionNodeRunFinished
+			}
+		// 3. canceled by event
+		case reason := <-cancelChan:
+			logger.Infof("function run is canceled by event. reason: %s", reason)
+			funcRunOpt = &FunctionRunOpt{
+				Suc:      true,
+				Canceled: true}
+			goto FunctionNodeRunFinished
+		// 4. report run progress
+		case runningStatus := <-progressReportChan:
+			bC.ReportFuncRunProgress(
+				traceCtx,
+				functionRunRecordIDStr, runningStatus.Progress,
+				runningStatus.Msg, runningStatus.ProgressMilestoneIndex)
+		// 5. finished!
+		case funcRunOpt = <-functionRunOptChan:
+			logger.Infof("function run suc")
+			goto FunctionNodeRunFinished
+		}
+	}
+FunctionNodeRunFinished:
+	cancelFunctionExecute()
+	close(progressReportChan)
+	cancelCheckTimer.Stop()
 
-			// read the real-time msg & forward 2 server
-			for {
-				select {
-				// 1. timeout
-				case <-timeOutChan:
-					logger.Infof("function run timeout canceled. function_run_record_id: %s", functionRunRecordIDStr)
-					funcRunOpt = &FunctionRunOpt{
-						Suc:             true,
-						TimeoutCanceled: true}
-					goto FunctionNodeRunFinished
-				// 2. flow is canceled
-				case <-cancelCheckTimer.C:
-					isCanceled, err := bC.FlowRunIsCanceled(funcRunRecordIns.FlowRunRecordID)
-					if err == nil && isCanceled {
-						logger.Infof("function run is canceled from flow")
-						funcRunOpt = &FunctionRunOpt{
-							Suc:      true,
-							Canceled: true}
-						goto FunctionNodeRunFinished
-					}
-				// 3. report run progress
-				case runningStatus := <-progressReportChan:
-					bC.ReportFuncRunProgress(
-						traceCtx,
-						functionRunRecordIDStr, runningStatus.Progress,
-						runningStatus.Msg, runningStatus.ProgressMilestoneIndex)
-				// 4. finished!
-				case funcRunOpt = <-functionRunOptChan:
-					logger.Infof("function run suc")
-					goto FunctionNodeRunFinished
-				}
+	// save opt
+	if funcRunOpt.Suc {
+		funcRunOpt.registerArtifacts(artifactWriter)
+		funcRunOpt.Brief = make(map[string]string, len(funcRunOpt.Detail))
+		funcRunOpt.KeyMapObjectStorageKey = make(map[string]string, len(funcRunOpt.Detail))
+		for optKey, optVal := range funcRunOpt.Detail {
+			briefValue := functionIns.OptBrief(optKey, optVal)
+			if briefValue != "" {
+				funcRunOpt.Brief[optKey] = briefValue
 			}
-		FunctionNodeRunFinished:
-			cancelFunctionExecute()
-			close(progressReportChan)
-			cancelCheckTimer.Stop()
-
-			// save opt
-			if funcRunOpt.Suc {
-				funcRunOpt.Brief = make(map[string]string, len(funcRunOpt.Detail))
-				funcRunOpt.KeyMapObjectStorageKey = make(map[string]string, len(funcRunOpt.Detail))
-				funcOptKeyMapValueType, funcOptKeyMapValueIsArray := functionIns.OptKeyMapValueTypeAndIsArray()
-				for optKey, optVal := range funcRunOpt.Detail {
-					minLength := 51
-					valueType := funcOptKeyMapValueType[optKey]
-					isArray := funcOptKeyMapValueIsArray[optKey]
-					briefValue := ""
-					if !isArray {
-						switch valueType {
-						case StringValueType, JsonValueType: // only truncate long string
-							tmp, err := cast.ToStringE(optVal)
-							if err == nil {
-								tmpRune := []rune(tmp)
-								rightIndex := minLength
-								if len(tmpRune) < minLength {
-									rightIndex = len(tmpRune)
-								}
-								briefValue = string(tmpRune[:rightIndex])
-							}
-						default:
-							tmp, err := cast.ToStringE(optVal)
-							if err == nil {
-								briefValue = tmp
-							}
-						}
-					}
-					if briefValue != "" {
-						funcRunOpt.Brief[optKey] = briefValue
-					}
-
-					serverPersisResp, err := bC.PersistFunctionRunOptFieldToServer(
-						functionRunRecordIDStr, optKey, optVal)
-					if err != nil {
-						funcRunOpt.Brief[optKey] = "persist opt data to server failed: " + err.Error()
-					} else {
-						if _, ok := funcRunOpt.Brief[optKey]; !ok {
-							funcRunOpt.Brief[optKey] = serverPersisResp.Brief
-						}
-						funcRunOpt.KeyMapObjectStorageKey[optKey] = serverPersisResp.ObjectStorageKey
-					}
+
+			if bC.configBuilder.ContentAddressed {
+				objectStorageKey, err := bC.PersistFunctionRunOptFieldContentAddressed(optVal)
+				if err != nil {
+					funcRunOpt.Brief[optKey] = "persist opt data to object storage failed: " + err.Error()
+				} else {
+					funcRunOpt.KeyMapObjectStorageKey[optKey] = objectStorageKey
 				}
+				continue
 			}
 
-			// report finished
-			err = bC.ReportFuncRunFinished(traceCtx, functionRunRecordIDStr, *funcRunOpt)
+			serverPersisResp, err := bC.PersistFunctionRunOptFieldToServerCtx(
+				traceCtx, functionRunRecordIDStr, optKey, optVal)
 			if err != nil {
-				logger.Errorf("report function run finished failed: %+v", err)
+				funcRunOpt.Brief[optKey] = "persist opt data to server failed: " + err.Error()
 			} else {
-				logger.Infof("report function run finished suc")
+				if _, ok := funcRunOpt.Brief[optKey]; !ok {
+					funcRunOpt.Brief[optKey] = serverPersisResp.Brief
+				}
+				funcRunOpt.KeyMapObjectStorageKey[optKey] = serverPersisResp.ObjectStorageKey
 			}
-		}(functionToRunEvent, &wg)
+		}
+	}
 
-		wg.Wait()
+	// report finished
+	err = bC.ReportFuncRunFinished(traceCtx, functionRunRecordIDStr, *funcRunOpt)
+	if err != nil {
+		logger.Errorf("report function run finished failed: %+v", err)
+	} else {
+		logger.Infof("report function run finished suc")
 	}
 }
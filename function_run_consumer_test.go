@@ -0,0 +1,500 @@
+package bloc_client
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/event"
+)
+
+const mockClientName = "mock_client"
+
+type sumFunction struct{}
+
+func (*sumFunction) AllProgressMilestones() []string {
+	return []string{"summing"}
+}
+
+func (*sumFunction) IptConfig() Ipts {
+	return Ipts{
+		{
+			Key:  "numbers",
+			Must: true,
+			Components: []*IptComponent{
+				{
+					ValueType:       IntValueType,
+					FormControlType: InputFormControl,
+					AllowMulti:      true,
+				},
+			},
+		},
+	}
+}
+
+func (*sumFunction) OptConfig() Opts {
+	return Opts{
+		{
+			Key:       "sum",
+			ValueType: IntValueType,
+		},
+	}
+}
+
+func (*sumFunction) Run(
+	ctx context.Context,
+	ipts Ipts,
+	progressReportChan chan HighReadableFunctionRunProgress,
+	blocOptChan chan *FunctionRunOpt,
+	logger *Logger,
+) {
+	numbers, err := ipts.GetIntSliceValue(0, 0)
+	if err != nil {
+		blocOptChan <- NewFailedFunctionRunOpt("parse numbers failed: %v", err)
+		return
+	}
+	progressReportChan <- HighReadableFunctionRunProgress{Progress: 50}
+	sum := 0
+	for _, i := range numbers {
+		sum += i
+	}
+	blocOptChan <- &FunctionRunOpt{
+		Suc:    true,
+		Detail: map[string]interface{}{"sum": sum}}
+}
+
+// newMockClient returns a client talks to the mock server & consumes from the in-memory mq
+func newMockClient(
+	t *testing.T, configs ...func(*ConfigBuilder),
+) (*blocClient, *mockServer, *MemoryMsgQueue) {
+	return newMockClientOf(t, newMockServer(t), configs...)
+}
+
+// newMockClientOf talks to server, which may be set before the client starts
+func newMockClientOf(
+	t *testing.T, server *mockServer, configs ...func(*ConfigBuilder),
+) (*blocClient, *mockServer, *MemoryMsgQueue) {
+	eventMQ := NewMemoryMsgQueue()
+	t.Cleanup(eventMQ.Close)
+
+	client := NewClient(mockClientName)
+	ip, port := server.ipAndPort()
+	configBuilder := client.GetConfigBuilder().SetServer(ip, port).SetEventMQ(eventMQ)
+	for _, config := range configs {
+		config(configBuilder)
+	}
+	configBuilder.BuildUp()
+	client.RegisterFunctionGroup("math").AddFunction("sum", "sum numbers", &sumFunction{})
+	client.RegisterFunctionGroup("heavy_math").AddFunction("sum", "sum numbers", &sumFunction{})
+	if err := client.RegisterFunctionsToServer(); err != nil {
+		t.Fatal(err)
+	}
+	return client, server, eventMQ
+}
+
+func publishClientRunFunction(t *testing.T, eventMQ *MemoryMsgQueue, functionRunRecordID string) {
+	publishEvent(t, eventMQ, &event.ClientRunFunction{
+		FunctionRunRecordID: functionRunRecordID,
+		ClientName:          mockClientName}, mockClientName)
+}
+
+// publishEvent binds the queue before publishing, so the event is kept even not pulled yet
+func publishEvent(t *testing.T, eventMQ *MemoryMsgQueue, e event.DomainEvent, queueName string) {
+	data, err := e.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventMQ.Bind(e.Topic(), queueName)
+	if err := eventMQ.Pub(e.Topic(), data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFinished(t *testing.T, server *mockServer) *FuncRunFinishedHttpReq {
+	t.Helper()
+	select {
+	case finished := <-server.finished:
+		return finished
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait function run finished report timeout")
+	}
+	return nil
+}
+
+func waitAllAcked(t *testing.T, eventMQ *MemoryMsgQueue) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for eventMQ.Unacked() > 0 || eventMQ.Ready(mockClientName) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("wait event acked timeout, unacked: %d, ready: %d",
+				eventMQ.Unacked(), eventMQ.Ready(mockClientName))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFunctionRunConsumer(t *testing.T) {
+	client, server, eventMQ := newMockClient(t)
+
+	server.setObjectStorageValue("numbers_key", []int{1, 2, 3})
+	server.addFunctionRunRecord(&FunctionRunRecord{
+		ID:         "record_1",
+		FunctionID: "math-sum",
+		TraceID:    "trace_1",
+		IptBriefAndObjectStoragekey: [][]briefAndKey{
+			{{ObjectStorageKey: "numbers_key"}}},
+	})
+	publishClientRunFunction(t, eventMQ, "record_1")
+
+	go client.FunctionRunConsumer()
+
+	finished := waitFinished(t, server)
+	if finished.FunctionRunRecordID != "record_1" || !finished.Suc {
+		t.Fatalf("function run should suc: %+v", finished)
+	}
+	if finished.OptKeyMapBriefData["sum"] != "6" {
+		t.Errorf("brief of sum should be 6, get: %s", finished.OptKeyMapBriefData["sum"])
+	}
+	if finished.OptKeyMapObjectStorageKey["sum"] != "record_1-sum" {
+		t.Errorf("unexpected object storage key: %v", finished.OptKeyMapObjectStorageKey)
+	}
+	waitAllAcked(t, eventMQ)
+}
+
+func TestFunctionRunConsumerRecordNotFound(t *testing.T) {
+	client, server, eventMQ := newMockClient(t)
+	publishClientRunFunction(t, eventMQ, "not_exist_record")
+
+	go client.FunctionRunConsumer()
+
+	finished := waitFinished(t, server)
+	if finished.Suc || finished.ErrorMsg == "" {
+		t.Fatalf("function run should fail with error msg: %+v", finished)
+	}
+	waitAllAcked(t, eventMQ)
+}
+
+func TestFunctionRunConsumerCompressed(t *testing.T) {
+	client, server, eventMQ := newMockClient(t, func(cb *ConfigBuilder) {
+		cb.SetCompression(ZstdCompress, 1)
+	})
+
+	server.setEncodedObjectStorageValue("numbers_key", []int{1, 2, 3}, GzipCompress)
+	server.addFunctionRunRecord(&FunctionRunRecord{
+		ID:         "record_1",
+		FunctionID: "math-sum",
+		IptBriefAndObjectStoragekey: [][]briefAndKey{
+			{{ObjectStorageKey: "numbers_key"}}},
+	})
+	publishClientRunFunction(t, eventMQ, "record_1")
+
+	go client.FunctionRunConsumer()
+
+	finished := waitFinished(t, server)
+	if !finished.Suc {
+		t.Fatalf("function run should suc: %+v", finished)
+	}
+	if persisted := server.getPersistedOpt("record_1-sum"); persisted != float64(6) {
+		t.Errorf("persisted opt should be decompressed to 6, get: %v", persisted)
+	}
+	waitAllAcked(t, eventMQ)
+}
+
+func TestFunctionRunConsumerCompressedToOldServer(t *testing.T) {
+	server := newMockServer(t)
+	server.encodingUnknown = true
+	client, _, eventMQ := newMockClientOf(t, server, func(cb *ConfigBuilder) {
+		cb.SetCompression(ZstdCompress, 1)
+	})
+
+	server.setEncodedObjectStorageValue("numbers_key", []int{1, 2, 3}, GzipCompress)
+	server.addFunctionRunRecord(&FunctionRunRecord{
+		ID:         "record_1",
+		FunctionID: "math-sum",
+		IptBriefAndObjectStoragekey: [][]briefAndKey{
+			{{ObjectStorageKey: "numbers_key"}}},
+	})
+	publishClientRunFunction(t, eventMQ, "record_1")
+
+	go client.FunctionRunConsumer()
+
+	// the ipt is decompressed by it's magic number
+	if finished := waitFinished(t, server); !finished.Suc {
+		t.Fatalf("function run should suc: %+v", finished)
+	}
+	if persisted := server.getPersistedOpt("record_1-sum"); persisted != float64(6) {
+		t.Errorf("opt should be persisted uncompressed to the old server, get: %v", persisted)
+	}
+	waitAllAcked(t, eventMQ)
+}
+
+func TestFunctionRunConsumerContentAddressed(t *testing.T) {
+	objectStorage := NewMemoryObjectStorage()
+	client, server, eventMQ := newMockClient(t, func(cb *ConfigBuilder) {
+		cb.SetObjectStorage(objectStorage).EnableContentAddressedStorage()
+	})
+
+	server.setObjectStorageValue("numbers_key", []int{1, 2, 3})
+	server.setObjectStorageValue("other_numbers_key", []int{3, 3})
+	for id, iptKey := range map[string]string{"record_1": "numbers_key", "record_2": "other_numbers_key"} {
+		server.addFunctionRunRecord(&FunctionRunRecord{
+			ID:         id,
+			FunctionID: "math-sum",
+			IptBriefAndObjectStoragekey: [][]briefAndKey{
+				{{ObjectStorageKey: iptKey}}},
+		})
+	}
+
+	go client.FunctionRunConsumer()
+
+	publishClientRunFunction(t, eventMQ, "record_1")
+	first := waitFinished(t, server)
+	waitAllAcked(t, eventMQ)
+	publishClientRunFunction(t, eventMQ, "record_2")
+	second := waitFinished(t, server)
+	if !first.Suc || !second.Suc {
+		t.Fatalf("function runs should suc: %+v, %+v", first, second)
+	}
+	key := first.OptKeyMapObjectStorageKey["sum"]
+	if key == "" || key != second.OptKeyMapObjectStorageKey["sum"] {
+		t.Fatalf("same output should share the key: %s, %s",
+			key, second.OptKeyMapObjectStorageKey["sum"])
+	}
+	if len(objectStorage.Keys()) != 1 {
+		t.Errorf("should only keep 1 blob, get %v", objectStorage.Keys())
+	}
+	if server.getPersistedOpt("record_1-sum") != nil {
+		t.Errorf("should not persist opt by server")
+	}
+	waitAllAcked(t, eventMQ)
+}
+
+func TestFunctionRunConsumerSubscribedGroup(t *testing.T) {
+	client, server, eventMQ := newMockClient(t, func(cb *ConfigBuilder) {
+		cb.SubscribeFunctionGroups("math")
+	})
+
+	registered := server.getRegistered()
+	if _, ok := registered.GroupNameMapFunctions["heavy_math"]; ok {
+		t.Fatalf("unsubscribed group should not be registered: %+v", registered)
+	}
+	mathFunctions := registered.GroupNameMapFunctions["math"]
+	if len(mathFunctions) != 1 ||
+		mathFunctions[0].RoutingKey != "function_client_run_consumer.mock_client.math" {
+		t.Fatalf("unexpected registered functions: %+v", mathFunctions)
+	}
+
+	server.setObjectStorageValue("numbers_key", []int{1, 2})
+	for _, id := range []string{"record_1", "record_2"} {
+		server.addFunctionRunRecord(&FunctionRunRecord{
+			ID:         id,
+			FunctionID: "math-sum",
+			IptBriefAndObjectStoragekey: [][]briefAndKey{
+				{{ObjectStorageKey: "numbers_key"}}},
+		})
+	}
+	// the client's topic is not subscribed any more
+	publishClientRunFunction(t, eventMQ, "record_1")
+	publishEvent(t, eventMQ, &event.ClientRunFunction{
+		FunctionRunRecordID: "record_2",
+		ClientName:          mockClientName,
+		GroupName:           "math"}, "mock_client.math")
+
+	go client.FunctionRunConsumer()
+
+	finished := waitFinished(t, server)
+	if finished.FunctionRunRecordID != "record_2" || !finished.Suc {
+		t.Fatalf("run routed to the group should suc: %+v", finished)
+	}
+	select {
+	case finished := <-server.finished:
+		t.Fatalf("run of the client's topic should not be consumed: %+v", finished)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if eventMQ.Ready(mockClientName) != 1 {
+		t.Fatalf("run of the client's topic should be kept in it's queue")
+	}
+}
+
+func TestSubscribeNotRegisteredFunction(t *testing.T) {
+	server := newMockServer(t)
+	client := NewClient(mockClientName)
+	ip, port := server.ipAndPort()
+	client.GetConfigBuilder().SetServer(ip, port).SetEventMQ(NewMemoryMsgQueue()).
+		SubscribeFunction("math", "multiply").BuildUp()
+	client.RegisterFunctionGroup("math").AddFunction("sum", "sum numbers", &sumFunction{})
+	if err := client.RegisterFunctionsToServer(); err == nil {
+		t.Fatal("subscribe not registered function should fail")
+	}
+}
+
+func TestInvalidRoutingNames(t *testing.T) {
+	for name, register := range map[string]func(){
+		"subscribed group":    func() { NewClient(mockClientName).GetConfigBuilder().SubscribeFunctionGroups("math.*") },
+		"subscribed function": func() { NewClient(mockClientName).GetConfigBuilder().SubscribeFunction("math", "sum#") },
+		"registered group":    func() { NewClient(mockClientName).RegisterFunctionGroup("ma.th") },
+		"client":              func() { NewClient("bloc.*") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s with '.', '*' or '#' should panic", name)
+				}
+			}()
+			register()
+		}()
+	}
+}
+
+// blockFunction runs until canceled
+type blockFunction struct {
+	sumFunction
+	started chan struct{}
+}
+
+func (bF *blockFunction) Run(
+	ctx context.Context,
+	ipts Ipts,
+	progressReportChan chan HighReadableFunctionRunProgress,
+	blocOptChan chan *FunctionRunOpt,
+	logger *Logger,
+) {
+	close(bF.started)
+	<-ctx.Done()
+}
+
+// publishBroadcastEvent publishes the event the way bloc-server does
+func publishBroadcastEvent(t *testing.T, eventMQ *MemoryMsgQueue, e event.DomainEvent) {
+	data, err := event.Encode(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := eventMQ.Pub(e.Topic(), data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFunctionRunCanceledByEvent(t *testing.T) {
+	server := newMockServer(t)
+	eventMQ := NewMemoryMsgQueue()
+	t.Cleanup(eventMQ.Close)
+	client := NewClient(mockClientName)
+	ip, port := server.ipAndPort()
+	client.GetConfigBuilder().SetServer(ip, port).SetEventMQ(eventMQ).BuildUp()
+	block := &blockFunction{started: make(chan struct{})}
+	client.RegisterFunctionGroup("slow").AddFunction("block", "block until canceled", block)
+	if err := client.RegisterFunctionsToServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	server.addFunctionRunRecord(&FunctionRunRecord{
+		ID:         "record_1",
+		FunctionID: "slow-block"})
+	publishClientRunFunction(t, eventMQ, "record_1")
+
+	go client.FunctionRunConsumer()
+
+	select {
+	case <-block.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait function run started timeout")
+	}
+	// not running in this replica, should be ignored
+	publishBroadcastEvent(t, eventMQ, &event.FunctionRunCancel{
+		FunctionRunRecordID: "record_2",
+		ClientName:          mockClientName})
+	publishBroadcastEvent(t, eventMQ, &event.FunctionRunCancel{
+		FunctionRunRecordID: "record_1",
+		ClientName:          mockClientName,
+		Reason:              "canceled by user"})
+
+	finished := waitFinished(t, server)
+	if finished.FunctionRunRecordID != "record_1" || !finished.Canceled {
+		t.Fatalf("function run should be canceled: %+v", finished)
+	}
+	waitAllAcked(t, eventMQ)
+}
+
+func TestConfigChangedEvent(t *testing.T) {
+	configChan := make(chan map[string]string, 1)
+	client, server, eventMQ := newMockClient(t, func(cb *ConfigBuilder) {
+		cb.OnConfigChanged(func(config map[string]string) {
+			configChan <- config
+		})
+	})
+
+	// a finished run makes sure the consumer is listening
+	server.addFunctionRunRecord(&FunctionRunRecord{
+		ID:         "not_run",
+		FunctionID: "not_exist"})
+	publishClientRunFunction(t, eventMQ, "not_run")
+	go client.FunctionRunConsumer()
+	waitFinished(t, server)
+
+	publishBroadcastEvent(t, eventMQ, &event.ClientConfigChanged{
+		ClientName: mockClientName,
+		Config:     map[string]string{"log_level": "debug"}})
+	select {
+	case config := <-configChan:
+		if config["log_level"] != "debug" {
+			t.Fatalf("unexpected config: %v", config)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait config changed callback timeout")
+	}
+	waitAllAcked(t, eventMQ)
+}
+
+// slowSumFunction sums after a while, so that runs of it overlap
+type slowSumFunction struct {
+	sumFunction
+}
+
+func (sF *slowSumFunction) Run(
+	ctx context.Context,
+	ipts Ipts,
+	progressReportChan chan HighReadableFunctionRunProgress,
+	blocOptChan chan *FunctionRunOpt,
+	logger *Logger,
+) {
+	time.Sleep(50 * time.Millisecond)
+	sF.sumFunction.Run(ctx, ipts, progressReportChan, blocOptChan, logger)
+}
+
+func TestFunctionRunsOfSameFunctionConcurrently(t *testing.T) {
+	server := newMockServer(t)
+	eventMQ := NewMemoryMsgQueue()
+	t.Cleanup(eventMQ.Close)
+	client := NewClient(mockClientName)
+	ip, port := server.ipAndPort()
+	client.GetConfigBuilder().SetServer(ip, port).SetEventMQ(eventMQ).SetConcurrency(4).BuildUp()
+	client.RegisterFunctionGroup("math").AddFunction("slow_sum", "sum numbers slowly", &slowSumFunction{})
+	if err := client.RegisterFunctionsToServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := make(map[string]string)
+	for i := 1; i <= 4; i++ {
+		id := "record_" + strconv.Itoa(i)
+		server.setObjectStorageValue(id+"_numbers", []int{i, i})
+		server.addFunctionRunRecord(&FunctionRunRecord{
+			ID:         id,
+			FunctionID: "math-slow_sum",
+			IptBriefAndObjectStoragekey: [][]briefAndKey{
+				{{ObjectStorageKey: id + "_numbers"}}},
+		})
+		publishClientRunFunction(t, eventMQ, id)
+		expected[id] = strconv.Itoa(2 * i)
+	}
+	go client.FunctionRunConsumer()
+
+	for range expected {
+		finished := waitFinished(t, server)
+		if !finished.Suc || finished.OptKeyMapBriefData["sum"] != expected[finished.FunctionRunRecordID] {
+			t.Errorf("run should sum it's own ipt, expected %s, get: %+v",
+				expected[finished.FunctionRunRecordID], finished)
+		}
+	}
+	waitAllAcked(t, eventMQ)
+}
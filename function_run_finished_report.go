@@ -3,8 +3,6 @@ package bloc_client
 import (
 	"context"
 	"encoding/json"
-
-	"github.com/fBloc/bloc-client-go/internal/http_util"
 )
 
 const FuncRunFinishedHttpPath = "function_run_finished"
@@ -37,23 +35,25 @@ func newFuncRunFinishedHttpReqFromFuncOpt(
 	}
 }
 
-func (bC *blocClient) ReportFuncRunFinished(
-	ctx context.Context,
-	functionRunRecordID string, opt FunctionRunOpt,
+func (hSC *httpServerClient) ReportFuncRunFinished(
+	ctx context.Context, req FuncRunFinishedHttpReq,
 ) error {
-	funcRunFinishedReq := newFuncRunFinishedHttpReqFromFuncOpt(
-		functionRunRecordID, opt)
-	body, err := json.Marshal(*funcRunFinishedReq)
+	body, err := json.Marshal(req)
 	if err != nil {
 		return err
 	}
 
-	var resp interface{}
-	header := map[string]string{
-		string(TraceID): GetTraceIDFromContext(ctx),
-		string(SpanID):  GetSpanIDFromContext(ctx)}
-	err = http_util.PostJson(
-		bC.GenReqServerPath(FuncRunFinishedHttpPath),
-		header, body, &resp)
-	return err
+	var resp HttpResp
+	err = hSC.client.PostJsonIdempotent(
+		ctx, hSC.apiPath(FuncRunFinishedHttpPath),
+		traceHeader(ctx), body, &resp)
+	return checkResp(FuncRunFinishedHttpPath, err, &resp)
+}
+
+func (bC *blocClient) ReportFuncRunFinished(
+	ctx context.Context,
+	functionRunRecordID string, opt FunctionRunOpt,
+) error {
+	return bC.ServerClient().ReportFuncRunFinished(
+		ctx, *newFuncRunFinishedHttpReqFromFuncOpt(functionRunRecordID, opt))
 }
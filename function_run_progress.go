@@ -3,8 +3,7 @@ package bloc_client
 import (
 	"context"
 	"encoding/json"
-
-	"github.com/fBloc/bloc-client-go/internal/http_util"
+	"strings"
 )
 
 const FuncRunProgressReportPath = "/report_progress"
@@ -15,11 +14,26 @@ type HighReadableFunctionRunProgress struct {
 	ProgressMilestoneIndex *int    `json:"progress_milestone_index"`
 }
 
-type progressReportHttpReq struct {
+type FuncRunProgressHttpReq struct {
 	FunctionRunRecordID string                          `json:"function_run_record_id"`
 	FuncRunProgress     HighReadableFunctionRunProgress `json:"high_readable_run_progress"`
 }
 
+func (hSC *httpServerClient) ReportFuncRunProgress(
+	ctx context.Context, req FuncRunProgressHttpReq,
+) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	var resp HttpResp
+	err = hSC.client.PostJsonIdempotent(
+		ctx, hSC.apiPath(FuncRunProgressReportPath),
+		traceHeader(ctx), body, &resp)
+	return checkResp(strings.Trim(FuncRunProgressReportPath, "/"), err, &resp)
+}
+
 func (bC *blocClient) ReportFuncRunProgress(
 	ctx context.Context,
 	funcRunRecordID string,
@@ -45,19 +59,7 @@ func (bC *blocClient) ReportFuncRunProgress(
 		return nil
 	}
 
-	body, err := json.Marshal(progressReportHttpReq{
+	return bC.ServerClient().ReportFuncRunProgress(ctx, FuncRunProgressHttpReq{
 		FunctionRunRecordID: funcRunRecordID,
 		FuncRunProgress:     p})
-	if err != nil {
-		return err
-	}
-
-	var resp interface{}
-	header := map[string]string{
-		string(TraceID): GetTraceIDFromContext(ctx),
-		string(SpanID):  GetSpanIDFromContext(ctx)}
-	err = http_util.PostJson(
-		bC.GenReqServerPath(FuncRunProgressReportPath),
-		header, body, &resp)
-	return err
 }
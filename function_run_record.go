@@ -1,6 +1,7 @@
 package bloc_client
 
 import (
+	"context"
 	"time"
 
 	"github.com/fBloc/bloc-client-go/internal/http_util"
@@ -22,21 +23,39 @@ type FunctionRunRecord struct {
 
 type FuncRecordHttpResp struct {
 	StatusCode        int               `json:"status_code"`
+	StatusMsg         string            `json:"status_msg"`
 	FunctionRunRecord FunctionRunRecord `json:"data"`
 }
 
+func (resp *FuncRecordHttpResp) serverStatus() (int, string) {
+	return resp.StatusCode, resp.StatusMsg
+}
+
 const functionRecordPath = "get_function_run_record_by_id"
 
-func (bC *blocClient) GetFunctionRunRecordByID(
-	funcRunRecordID string,
+func (hSC *httpServerClient) GetFunctionRunRecord(
+	ctx context.Context, funcRunRecordID string,
 ) (*FunctionRunRecord, error) {
 	var resp FuncRecordHttpResp
-	err := http_util.Get(
-		bC.GenReqServerPath(functionRecordPath, funcRunRecordID),
+	err := hSC.client.Get(
+		ctx, hSC.apiPath(functionRecordPath, funcRunRecordID),
 		http_util.BlankHeader,
 		&resp)
-	if err != nil {
+	if err := checkResp(functionRecordPath, err, &resp); err != nil {
 		return nil, err
 	}
 	return &resp.FunctionRunRecord, nil
 }
+
+func (bC *blocClient) GetFunctionRunRecordByID(
+	funcRunRecordID string,
+) (*FunctionRunRecord, error) {
+	return bC.GetFunctionRunRecordByIDCtx(context.Background(), funcRunRecordID)
+}
+
+// GetFunctionRunRecordByIDCtx is GetFunctionRunRecordByID bounded by ctx
+func (bC *blocClient) GetFunctionRunRecordByIDCtx(
+	ctx context.Context, funcRunRecordID string,
+) (*FunctionRunRecord, error) {
+	return bC.ServerClient().GetFunctionRunRecord(ctx, funcRunRecordID)
+}
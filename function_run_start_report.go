@@ -3,8 +3,6 @@ package bloc_client
 import (
 	"context"
 	"encoding/json"
-
-	"github.com/fBloc/bloc-client-go/internal/http_util"
 )
 
 const FuncRunStartHttpPath = "function_run_start"
@@ -21,22 +19,25 @@ func newFuncRunStartHttpReq(
 	}
 }
 
-func (bC *blocClient) ReportFuncRunStart(
-	ctx context.Context,
-	functionRunRecordID string,
+func (hSC *httpServerClient) ReportFuncRunStart(
+	ctx context.Context, req FuncRunStartHttpReq,
 ) error {
-	funcRunStartReq := newFuncRunStartHttpReq(functionRunRecordID)
-	body, err := json.Marshal(*funcRunStartReq)
+	body, err := json.Marshal(req)
 	if err != nil {
 		return err
 	}
 
-	var resp interface{}
-	header := map[string]string{
-		string(TraceID): GetTraceIDFromContext(ctx),
-		string(SpanID):  GetSpanIDFromContext(ctx)}
-	err = http_util.PostJson(
-		bC.GenReqServerPath(FuncRunStartHttpPath),
-		header, body, &resp)
-	return err
+	var resp HttpResp
+	err = hSC.client.PostJsonIdempotent(
+		ctx, hSC.apiPath(FuncRunStartHttpPath),
+		traceHeader(ctx), body, &resp)
+	return checkResp(FuncRunStartHttpPath, err, &resp)
+}
+
+func (bC *blocClient) ReportFuncRunStart(
+	ctx context.Context,
+	functionRunRecordID string,
+) error {
+	return bC.ServerClient().ReportFuncRunStart(
+		ctx, *newFuncRunStartHttpReq(functionRunRecordID))
 }
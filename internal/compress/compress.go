@@ -0,0 +1,120 @@
+package compress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Encoding is the compress algorithm data is encoded by.
+// It's value is used as the encoding marker kept along with the data.
+type Encoding string
+
+const (
+	Identity Encoding = ""
+	Gzip     Encoding = "gzip"
+	Zstd     Encoding = "zstd"
+)
+
+var (
+	zstdEncoder     *zstd.Encoder
+	zstdDecoder     *zstd.Decoder
+	zstdInitOnce    sync.Once
+	errZstdInitial  error
+	ErrUnknownCodec = errors.New("unknown compress encoding")
+)
+
+func initZstd() error {
+	zstdInitOnce.Do(func() {
+		zstdEncoder, errZstdInitial = zstd.NewWriter(nil)
+		if errZstdInitial != nil {
+			return
+		}
+		zstdDecoder, errZstdInitial = zstd.NewReader(nil)
+	})
+	return errZstdInitial
+}
+
+func (e Encoding) IsValid() bool {
+	switch e {
+	case Identity, Gzip, Zstd:
+		return true
+	}
+	return false
+}
+
+// Sniff returns the encoding data is compressed by, told by it's magic
+// number. json never starts with them, so it's Identity for json
+func Sniff(data []byte) Encoding {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return Gzip
+	case bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return Zstd
+	}
+	return Identity
+}
+
+// Encode compresses data by the encoding
+func Encode(encoding Encoding, data []byte) ([]byte, error) {
+	switch encoding {
+	case Identity:
+		return data, nil
+	case Gzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/4)), nil
+	}
+	return nil, errors.Wrap(ErrUnknownCodec, string(encoding))
+}
+
+// Decode decompresses data encoded by the encoding
+func Decode(encoding Encoding, data []byte) ([]byte, error) {
+	switch encoding {
+	case Identity:
+		return data, nil
+	case Gzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	case Zstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdDecoder.DecodeAll(data, nil)
+	}
+	return nil, errors.Wrap(ErrUnknownCodec, string(encoding))
+}
+
+// EncodeIfLarge only compresses data whose size reaches threshold,
+// smaller data is returned as is with Identity encoding
+func EncodeIfLarge(
+	encoding Encoding, threshold int, data []byte,
+) ([]byte, Encoding, error) {
+	if encoding == Identity || len(data) < threshold {
+		return data, Identity, nil
+	}
+	encoded, err := Encode(encoding, data)
+	if err != nil {
+		return nil, Identity, err
+	}
+	return encoded, encoding, nil
+}
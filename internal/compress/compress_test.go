@@ -0,0 +1,47 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"name":"bloc","value":[1,2,3]}`), 100)
+	for _, encoding := range []Encoding{Identity, Gzip, Zstd} {
+		encoded, err := Encode(encoding, data)
+		if err != nil {
+			t.Fatalf("encode by %s failed: %v", encoding, err)
+		}
+		if encoding != Identity && len(encoded) >= len(data) {
+			t.Errorf("%s encoded data should be smaller", encoding)
+		}
+		decoded, err := Decode(encoding, encoded)
+		if err != nil {
+			t.Fatalf("decode by %s failed: %v", encoding, err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Errorf("%s decoded data not match origin", encoding)
+		}
+		if sniffed := Sniff(encoded); sniffed != encoding {
+			t.Errorf("%s encoded data is sniffed as %q", encoding, sniffed)
+		}
+	}
+
+	if _, err := Encode("lz4", data); err == nil {
+		t.Error("unknown encoding should fail")
+	}
+}
+
+func TestEncodeIfLarge(t *testing.T) {
+	small := []byte("small")
+	encoded, encoding, err := EncodeIfLarge(Zstd, 1024, small)
+	if err != nil || encoding != Identity || !bytes.Equal(encoded, small) {
+		t.Errorf("data under threshold should be kept as is")
+	}
+
+	large := bytes.Repeat([]byte("large"), 1024)
+	encoded, encoding, err = EncodeIfLarge(Zstd, 1024, large)
+	if err != nil || encoding != Zstd || len(encoded) >= len(large) {
+		t.Errorf("data over threshold should be compressed")
+	}
+}
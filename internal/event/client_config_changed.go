@@ -0,0 +1,48 @@
+package event
+
+import (
+	"encoding/json"
+)
+
+func init() {
+	var _ DomainEvent = &ClientConfigChanged{}
+	Register(ClientConfigChangedType, 1, func() DomainEvent {
+		return &ClientConfigChanged{}
+	})
+}
+
+const (
+	ClientConfigChangedType        = "client_config_changed"
+	clientConfigChangedTopicPrefix = "client_config_changed."
+)
+
+// ClientConfigChanged is broadcast by bloc-server to every replica of the
+// client after the client's config is changed
+type ClientConfigChanged struct {
+	ClientName string
+	Config     map[string]string
+	Delivered
+}
+
+func (event *ClientConfigChanged) Type() string {
+	return ClientConfigChangedType
+}
+
+func (event *ClientConfigChanged) Topic() string {
+	return clientConfigChangedTopicPrefix + event.ClientName
+}
+
+// Marshal .
+func (event *ClientConfigChanged) Marshal() ([]byte, error) {
+	return json.Marshal(event)
+}
+
+// Unmarshal .
+func (event *ClientConfigChanged) Unmarshal(payload []byte) (err error) {
+	return json.Unmarshal(payload, event)
+}
+
+// Identity
+func (event *ClientConfigChanged) Identity() string {
+	return event.ClientName
+}
@@ -2,26 +2,55 @@ package event
 
 import (
 	"encoding/json"
-
-	"github.com/streadway/amqp"
 )
 
 func init() {
 	var _ DomainEvent = &ClientRunFunction{}
+	Register(ClientRunFunctionType, 1, func() DomainEvent {
+		return &ClientRunFunction{}
+	})
 }
 
+const (
+	ClientRunFunctionType        = "client_run_function"
+	clientRunFunctionTopicPrefix = "function_client_run_consumer."
+)
+
+// ClientRunFunction is published by bloc-server to run a function.
+// GroupName & FunctionName are set only if the run is routed to the
+// replicas subscribed to the function group or the function
 type ClientRunFunction struct {
 	FunctionRunRecordID string
 	ClientName          string
-	deliveryTag         uint64
+	GroupName           string `json:",omitempty"`
+	FunctionName        string `json:",omitempty"`
+	// Priority higher runs first when the client's concurrency is saturated
+	Priority uint8 `json:",omitempty"`
+	Delivered
 }
 
-func (event *ClientRunFunction) Topic() string {
-	return "function_client_run_consumer." + event.ClientName
+// ClientRunFunctionTopic is the routing key of function runs:
+// - `function_client_run_consumer.<client>` for all functions of the client
+// - `function_client_run_consumer.<client>.<group>` for a function group
+// - `function_client_run_consumer.<client>.<group>.<function>` for a function
+func ClientRunFunctionTopic(clientName, groupName, functionName string) string {
+	topic := clientRunFunctionTopicPrefix + clientName
+	if groupName == "" {
+		return topic
+	}
+	topic += "." + groupName
+	if functionName == "" {
+		return topic
+	}
+	return topic + "." + functionName
 }
 
-func (event *ClientRunFunction) DeliveryTag() uint64 {
-	return event.deliveryTag
+func (event *ClientRunFunction) Type() string {
+	return ClientRunFunctionType
+}
+
+func (event *ClientRunFunction) Topic() string {
+	return ClientRunFunctionTopic(event.ClientName, event.GroupName, event.FunctionName)
 }
 
 // Marshal .
@@ -30,13 +59,19 @@ func (event *ClientRunFunction) Marshal() ([]byte, error) {
 }
 
 // Unmarshal .
-func (event *ClientRunFunction) Unmarshal(data *amqp.Delivery) (err error) {
-	err = json.Unmarshal(data.Body, event)
-	event.deliveryTag = data.DeliveryTag
-	return
+func (event *ClientRunFunction) Unmarshal(payload []byte) (err error) {
+	return json.Unmarshal(payload, event)
 }
 
 // Identity
 func (event *ClientRunFunction) Identity() string {
 	return event.FunctionRunRecordID
 }
+
+// RunPriority is the higher one of the event's & the delivery's priority
+func (event *ClientRunFunction) RunPriority() uint8 {
+	if d := event.Delivery(); d != nil && d.Priority > event.Priority {
+		return d.Priority
+	}
+	return event.Priority
+}
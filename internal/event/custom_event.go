@@ -0,0 +1,60 @@
+package event
+
+import (
+	"encoding/json"
+	"time"
+)
+
+func init() {
+	var _ DomainEvent = &CustomEvent{}
+	Register(CustomEventType, 1, func() DomainEvent {
+		return &CustomEvent{}
+	})
+}
+
+const (
+	CustomEventType        = "custom_event"
+	customEventTopicPrefix = "custom_event."
+)
+
+// CustomEvent is published by a function node in the middle of a run,
+// for other systems to react to
+type CustomEvent struct {
+	Name                string
+	ClientName          string
+	FunctionID          string
+	FunctionRunRecordID string
+	TraceID             string
+	SpanID              string
+	Data                json.RawMessage `json:",omitempty"`
+	PublishedAt         time.Time
+	Delivered
+}
+
+// CustomEventTopic is `custom_event.<client>.<name>`
+func CustomEventTopic(clientName, name string) string {
+	return customEventTopicPrefix + clientName + "." + name
+}
+
+func (event *CustomEvent) Type() string {
+	return CustomEventType
+}
+
+func (event *CustomEvent) Topic() string {
+	return CustomEventTopic(event.ClientName, event.Name)
+}
+
+// Marshal .
+func (event *CustomEvent) Marshal() ([]byte, error) {
+	return json.Marshal(event)
+}
+
+// Unmarshal .
+func (event *CustomEvent) Unmarshal(payload []byte) (err error) {
+	return json.Unmarshal(payload, event)
+}
+
+// Identity
+func (event *CustomEvent) Identity() string {
+	return event.FunctionRunRecordID
+}
@@ -1,21 +1,142 @@
 package event
 
 import (
+	"encoding/json"
+	"log"
+	"sync"
+
 	"github.com/fBloc/bloc-client-go/internal/mq"
-	"github.com/streadway/amqp"
 
 	"github.com/pkg/errors"
 )
 
 type DomainEvent interface {
+	// Type is the name the event is registered by
+	Type() string
 	Topic() string
+	// Marshal & Unmarshal the payload, which is wrapped by the envelope
 	Marshal() ([]byte, error)
-	Unmarshal(data *amqp.Delivery) (err error)
+	Unmarshal(payload []byte) (err error)
 	Identity() string
-	DeliveryTag() uint64
+	// Delivery returns the delivery the event is decoded from
+	Delivery() *mq.Delivery
+	SetDelivery(delivery *mq.Delivery)
+}
+
+// Delivered implements the delivery part of DomainEvent
+type Delivered struct {
+	delivery *mq.Delivery
+}
+
+func (d *Delivered) Delivery() *mq.Delivery {
+	return d.delivery
+}
+
+func (d *Delivered) SetDelivery(delivery *mq.Delivery) {
+	d.delivery = delivery
+}
+
+var (
+	needInitialMqInsAsEventChannelError = errors.New("lack init event mq rely")
+	ErrUnknownEventType                 = errors.New("unknown event type")
+	ErrUnsupportedVersion               = errors.New("unsupported event schema version")
+	ErrBroadcastNotSupported            = errors.New("event mq does not support broadcast")
+)
+
+type Factory func() DomainEvent
+
+type registration struct {
+	version int
+	factory Factory
 }
 
-var needInitialMqInsAsEventChannelError = errors.New("lack init event mq rely")
+var (
+	registry     = make(map[string]registration)
+	registryLock sync.RWMutex
+)
+
+// Register makes the event type decodable. version is the latest schema
+// version this client understands, msgs of a newer version are rejected
+func Register(eventType string, version int, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[eventType]; ok {
+		panic("event type registered twice: " + eventType)
+	}
+	registry[eventType] = registration{version: version, factory: factory}
+}
+
+func lookup(eventType string) (registration, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	r, ok := registry[eventType]
+	if !ok {
+		return r, errors.Wrap(ErrUnknownEventType, eventType)
+	}
+	return r, nil
+}
+
+// New returns a new instance of the registered event type
+func New(eventType string) (DomainEvent, error) {
+	r, err := lookup(eventType)
+	if err != nil {
+		return nil, err
+	}
+	return r.factory(), nil
+}
+
+// envelope is how events are kept in the msg body
+type envelope struct {
+	Type    string          `json:"type"`
+	Version int             `json:"version"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Encode wraps the event's payload by the envelope of it's registered version
+func Encode(event DomainEvent) ([]byte, error) {
+	r, err := lookup(event.Type())
+	if err != nil {
+		return nil, err
+	}
+	payload, err := event.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{
+		Type:    event.Type(),
+		Version: r.version,
+		Payload: payload})
+}
+
+// Decode the delivery into a new instance of it's event type.
+// bodies without the envelope are taken as the payload of defaultType,
+// which is how bloc-server published before schemas were versioned
+func Decode(delivery *mq.Delivery, defaultType string) (DomainEvent, error) {
+	var env envelope
+	if err := json.Unmarshal(delivery.Body, &env); err != nil {
+		return nil, errors.Wrap(err, "unmarshal event envelope failed")
+	}
+	if env.Type == "" {
+		env.Type = defaultType
+		env.Payload = delivery.Body
+	}
+
+	r, err := lookup(env.Type)
+	if err != nil {
+		return nil, err
+	}
+	if env.Version > r.version {
+		return nil, errors.Wrapf(
+			ErrUnsupportedVersion, "%s version %d, latest supported %d",
+			env.Type, env.Version, r.version)
+	}
+	event := r.factory()
+	if err := event.Unmarshal(env.Payload); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %s failed", env.Type)
+	}
+	event.SetDelivery(delivery)
+	return event, nil
+}
 
 // EventChannel 存取event的的通道，
 // 由于是分布式的，故肯定需要引入消息队列中间件
@@ -31,12 +152,35 @@ func InjectMq(eventChannel mq.MsgQueue) {
 	driver.mqIns = eventChannel
 }
 
+// Publish the event to it's topic
+func Publish(event DomainEvent) error {
+	return PublishWithPriority(event, 0)
+}
+
+// PublishWithPriority ignores the priority if the mq does not support it
+func PublishWithPriority(event DomainEvent, priority uint8) error {
+	if driver.mqIns == nil {
+		return needInitialMqInsAsEventChannelError
+	}
+	data, err := Encode(event)
+	if err != nil {
+		return err
+	}
+	if publisher, ok := driver.mqIns.(mq.PriorityPublisher); ok && priority > 0 {
+		return publisher.PubWithPriority(event.Topic(), data, priority)
+	}
+	return driver.mqIns.Pub(event.Topic(), data)
+}
+
 /*
 ListenEvent 监听某项事件
 
 对比PubEvent，为什么多了listenerTag参数呢？
 因为发布是发布一种类型的事件，其不需要也不应该知道有哪些地方需要订阅此事件
 也就是说对于同一个事件的发布，可能有多个订阅者，所以需要传入订阅者的标识
+
+event is only used for it's topic & type, every delivery is decoded into
+a new instance. events of different types can be sent to the same chan
 */
 func ListenEvent(
 	event DomainEvent, listenerTag string,
@@ -45,32 +189,65 @@ func ListenEvent(
 	if driver.mqIns == nil {
 		panic(needInitialMqInsAsEventChannelError)
 	}
+	if _, err := lookup(event.Type()); err != nil {
+		return err
+	}
 
-	deliveryChan := make(chan *amqp.Delivery)
+	deliveryChan := make(chan *mq.Delivery)
 	err := driver.mqIns.Pull(event.Topic(), listenerTag, deliveryChan)
 	if err != nil {
 		return errors.Wrap(err, "pull event failed")
 	}
+	go forward(event.Type(), deliveryChan, respEventChan)
+	return nil
+}
 
-	go func() {
-		for del := range deliveryChan {
-			err = event.Unmarshal(del)
-			if err != nil {
-				panic(err)
-			}
-			respEventChan <- event
-		}
-	}()
+// ListenBroadcastEvent is ListenEvent in which every listener gets
+// all the events, e.g. each replica should check whether it's running
+// the canceled function
+func ListenBroadcastEvent(event DomainEvent, respEventChan chan DomainEvent) error {
+	if driver.mqIns == nil {
+		panic(needInitialMqInsAsEventChannelError)
+	}
+	if _, err := lookup(event.Type()); err != nil {
+		return err
+	}
+	broadcaster, ok := driver.mqIns.(mq.Broadcaster)
+	if !ok {
+		return ErrBroadcastNotSupported
+	}
 
+	deliveryChan := make(chan *mq.Delivery)
+	err := broadcaster.PullBroadcast(event.Topic(), deliveryChan)
+	if err != nil {
+		return errors.Wrap(err, "pull broadcast event failed")
+	}
+	go forward(event.Type(), deliveryChan, respEventChan)
 	return nil
 }
 
+// forward decodes deliveries to events. undecodable ones are dropped as
+// they will never be decoded by this client
+func forward(
+	defaultType string,
+	deliveryChan chan *mq.Delivery, respEventChan chan DomainEvent,
+) {
+	for del := range deliveryChan {
+		event, err := Decode(del, defaultType)
+		if err != nil {
+			log.Printf("drop undecodable event from %s: %v", del.Topic, err)
+			del.Nack(false)
+			continue
+		}
+		respEventChan <- event
+	}
+}
+
 func AckEvent(
 	event DomainEvent,
 ) error {
-	if driver.mqIns == nil {
-		panic(needInitialMqInsAsEventChannelError)
+	if event.Delivery() == nil {
+		return errors.New("event is not from a delivery")
 	}
-
-	return driver.mqIns.Ack(event.DeliveryTag())
+	return event.Delivery().Ack()
 }
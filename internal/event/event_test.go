@@ -0,0 +1,122 @@
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/mq"
+	"github.com/fBloc/bloc-client-go/internal/mq/memory"
+
+	"github.com/pkg/errors"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	data, err := Encode(&FunctionRunCancel{
+		FunctionRunRecordID: "record_1", ClientName: "client", Reason: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the default type is only for legacy bodies
+	e, err := Decode(&mq.Delivery{Body: data}, ClientRunFunctionType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel, ok := e.(*FunctionRunCancel)
+	if !ok {
+		t.Fatalf("should decode to FunctionRunCancel, get: %T", e)
+	}
+	if cancel.FunctionRunRecordID != "record_1" || cancel.Reason != "user" {
+		t.Errorf("unexpected decoded event: %+v", cancel)
+	}
+	if cancel.Delivery() == nil {
+		t.Error("decoded event should keep it's delivery")
+	}
+}
+
+func TestDecodeLegacyBody(t *testing.T) {
+	body := []byte(`{"FunctionRunRecordID":"record_1","ClientName":"client"}`)
+	e, err := Decode(&mq.Delivery{Body: body}, ClientRunFunctionType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.(*ClientRunFunction).FunctionRunRecordID != "record_1" {
+		t.Errorf("unexpected decoded event: %+v", e)
+	}
+}
+
+func TestDecodeUnsupported(t *testing.T) {
+	cases := map[string]error{
+		`{"type":"client_run_function","version":99,"payload":{}}`: ErrUnsupportedVersion,
+		`{"type":"not_registered","version":1,"payload":{}}`:       ErrUnknownEventType,
+	}
+	for body, expected := range cases {
+		_, err := Decode(&mq.Delivery{Body: []byte(body)}, ClientRunFunctionType)
+		if !errors.Is(err, expected) {
+			t.Errorf("decode %s should fail by %v, get: %v", body, expected, err)
+		}
+	}
+}
+
+func receive(t *testing.T, eventChan chan DomainEvent) DomainEvent {
+	t.Helper()
+	select {
+	case e := <-eventChan:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("receive event timeout")
+	}
+	return nil
+}
+
+func TestListenEvent(t *testing.T) {
+	mmq := memory.New()
+	defer mmq.Close()
+	InjectMq(mmq)
+
+	eventChan := make(chan DomainEvent)
+	err := ListenEvent(&ClientRunFunction{ClientName: "client"}, "client", eventChan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mmq.Pub("function_client_run_consumer.client", []byte("not json"))
+	for _, id := range []string{"record_1", "record_2"} {
+		err := Publish(&ClientRunFunction{FunctionRunRecordID: id, ClientName: "client"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// undecodable one is dropped, each delivery gets it's own instance
+	first, second := receive(t, eventChan), receive(t, eventChan)
+	if first.Identity() != "record_1" || second.Identity() != "record_2" {
+		t.Fatalf("unexpected events: %s, %s", first.Identity(), second.Identity())
+	}
+	AckEvent(first)
+	AckEvent(second)
+	if mmq.Unacked() != 0 || mmq.Ready("client") != 0 {
+		t.Errorf("all deliveries should be acked or dropped")
+	}
+}
+
+func TestListenBroadcastEvent(t *testing.T) {
+	mmq := memory.New()
+	defer mmq.Close()
+	InjectMq(mmq)
+
+	eventChans := []chan DomainEvent{make(chan DomainEvent), make(chan DomainEvent)}
+	for _, eventChan := range eventChans {
+		err := ListenBroadcastEvent(&FunctionRunCancel{ClientName: "client"}, eventChan)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := Publish(&FunctionRunCancel{FunctionRunRecordID: "record_1", ClientName: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, eventChan := range eventChans {
+		if e := receive(t, eventChan); e.Identity() != "record_1" {
+			t.Errorf("every listener should get the event, get: %+v", e)
+		}
+	}
+}
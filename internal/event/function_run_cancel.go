@@ -0,0 +1,49 @@
+package event
+
+import (
+	"encoding/json"
+)
+
+func init() {
+	var _ DomainEvent = &FunctionRunCancel{}
+	Register(FunctionRunCancelType, 1, func() DomainEvent {
+		return &FunctionRunCancel{}
+	})
+}
+
+const (
+	FunctionRunCancelType        = "function_run_cancel"
+	functionRunCancelTopicPrefix = "function_run_cancel."
+)
+
+// FunctionRunCancel is broadcast by bloc-server to cancel a running function,
+// the replica running it stops the run
+type FunctionRunCancel struct {
+	FunctionRunRecordID string
+	ClientName          string
+	Reason              string `json:",omitempty"`
+	Delivered
+}
+
+func (event *FunctionRunCancel) Type() string {
+	return FunctionRunCancelType
+}
+
+func (event *FunctionRunCancel) Topic() string {
+	return functionRunCancelTopicPrefix + event.ClientName
+}
+
+// Marshal .
+func (event *FunctionRunCancel) Marshal() ([]byte, error) {
+	return json.Marshal(event)
+}
+
+// Unmarshal .
+func (event *FunctionRunCancel) Unmarshal(payload []byte) (err error) {
+	return json.Unmarshal(payload, event)
+}
+
+// Identity
+func (event *FunctionRunCancel) Identity() string {
+	return event.FunctionRunRecordID
+}
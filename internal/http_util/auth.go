@@ -0,0 +1,115 @@
+package http_util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ClientHeader        = "X-Bloc-Client"
+	TimestampHeader     = "X-Bloc-Timestamp"
+	ContentSHA256Header = "X-Bloc-Content-SHA256"
+	SignatureHeader     = "X-Bloc-Signature"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid request signature")
+	// ErrStreamNotAuthenticated is returned by Stream if the Authenticator
+	// is not a StreamAuthenticator
+	ErrStreamNotAuthenticated = errors.New("authenticator can not authenticate a streaming request")
+)
+
+// Authenticator sets the credentials to every request before it's sent
+type Authenticator interface {
+	Authenticate(req *http.Request, body []byte) error
+}
+
+// StreamAuthenticator is the Authenticator able to authenticate a streaming
+// request, whose body is not known when it's sent
+type StreamAuthenticator interface {
+	AuthenticateStream(req *http.Request) error
+}
+
+// CanAuthenticateStream is true if auth is nil or a StreamAuthenticator
+func CanAuthenticateStream(auth Authenticator) bool {
+	if auth == nil {
+		return true
+	}
+	_, ok := auth.(StreamAuthenticator)
+	return ok
+}
+
+// BearerToken authenticates by the static token in the Authorization header
+type BearerToken string
+
+func (token BearerToken) Authenticate(req *http.Request, body []byte) error {
+	return token.AuthenticateStream(req)
+}
+
+func (token BearerToken) AuthenticateStream(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(token))
+	return nil
+}
+
+// HMACSigner signs the method, uri, timestamp, client name & body hash of
+// every request by the secret shared with the server.
+// it can not authenticate a streaming request, whose body is unknown up front
+type HMACSigner struct {
+	ClientName string
+	Secret     []byte
+}
+
+func bodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func (hS HMACSigner) signature(req *http.Request, timestamp, contentHash string) string {
+	mac := hmac.New(sha256.New, hS.Secret)
+	mac.Write([]byte(strings.Join([]string{
+		req.Method, req.URL.RequestURI(), timestamp, hS.ClientName, contentHash,
+	}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (hS HMACSigner) Authenticate(req *http.Request, body []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	contentHash := bodyHash(body)
+	req.Header.Set(ClientHeader, hS.ClientName)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(ContentSHA256Header, contentHash)
+	req.Header.Set(SignatureHeader, hS.signature(req, timestamp, contentHash))
+	return nil
+}
+
+// Verify checks the signature of a request received by the server,
+// which should be signed within maxSkew
+func (hS HMACSigner) Verify(req *http.Request, body []byte, maxSkew time.Duration) error {
+	if req.Header.Get(ClientHeader) != hS.ClientName {
+		return errors.Wrap(ErrInvalidSignature, "client name mismatch")
+	}
+	timestamp := req.Header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Wrap(ErrInvalidSignature, "invalid timestamp")
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > maxSkew || skew < -maxSkew {
+		return errors.Wrap(ErrInvalidSignature, "timestamp expired")
+	}
+	contentHash := bodyHash(body)
+	if req.Header.Get(ContentSHA256Header) != contentHash {
+		return errors.Wrap(ErrInvalidSignature, "body hash mismatch")
+	}
+	expected := hS.signature(req, timestamp, contentHash)
+	if !hmac.Equal([]byte(req.Header.Get(SignatureHeader)), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
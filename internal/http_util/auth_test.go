@@ -0,0 +1,58 @@
+package http_util
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func signedRequest(t *testing.T, signer HMACSigner, body []byte) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("POST", "http://127.0.0.1/api/v1/client/report_log", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Authenticate(req, body); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestHMACSigner(t *testing.T) {
+	signer := HMACSigner{ClientName: "client", Secret: []byte("secret")}
+	body := []byte(`{"logs":[]}`)
+
+	req := signedRequest(t, signer, body)
+	if err := signer.Verify(req, body, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := signer.Verify(req, []byte(`{"logs":null}`), time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered body should be rejected, get: %v", err)
+	}
+	other := HMACSigner{ClientName: "client", Secret: []byte("other")}
+	if err := other.Verify(req, body, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("request signed by other secret should be rejected, get: %v", err)
+	}
+	impersonated := HMACSigner{ClientName: "other_client", Secret: []byte("secret")}
+	if err := impersonated.Verify(req, body, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("request of other client should be rejected, get: %v", err)
+	}
+
+	req.Header.Set(TimestampHeader, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	if err := signer.Verify(req, body, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expired request should be rejected, get: %v", err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+	BearerToken("token").Authenticate(req, nil)
+	if req.Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("unexpected authorization header: %s", req.Header.Get("Authorization"))
+	}
+}
@@ -0,0 +1,101 @@
+package http_util
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open, server seems down")
+
+// CircuitBreakerConfig opens the breaker after FailureThreshold failures in
+// a row, requests fail fast then. after OpenTimeout one request is let
+// through to probe, the breaker closes if it succeeds
+type CircuitBreakerConfig struct {
+	FailureThreshold int
+	OpenTimeout      time.Duration
+}
+
+var DefaultCircuitBreakerConfig = CircuitBreakerConfig{
+	FailureThreshold: 10,
+	OpenTimeout:      30 * time.Second}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type circuitBreaker struct {
+	conf     CircuitBreakerConfig
+	state    breakerState
+	failures int
+	openedAt time.Time
+	sync.Mutex
+}
+
+func newCircuitBreaker(conf CircuitBreakerConfig) *circuitBreaker {
+	if conf.FailureThreshold <= 0 {
+		conf.FailureThreshold = DefaultCircuitBreakerConfig.FailureThreshold
+	}
+	if conf.OpenTimeout <= 0 {
+		conf.OpenTimeout = DefaultCircuitBreakerConfig.OpenTimeout
+	}
+	return &circuitBreaker{conf: conf}
+}
+
+// allow returns ErrCircuitOpen if the request should not be sent
+func (cB *circuitBreaker) allow() error {
+	if cB == nil {
+		return nil
+	}
+	cB.Lock()
+	defer cB.Unlock()
+	switch cB.state {
+	case breakerOpen:
+		if time.Since(cB.openedAt) < cB.conf.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		cB.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		// only the probe is let through
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+func (cB *circuitBreaker) done(success bool) {
+	if cB == nil {
+		return
+	}
+	cB.Lock()
+	defer cB.Unlock()
+	if success {
+		cB.state = breakerClosed
+		cB.failures = 0
+		return
+	}
+	cB.failures++
+	if cB.state == breakerHalfOpen || cB.failures >= cB.conf.FailureThreshold {
+		cB.state = breakerOpen
+		cB.openedAt = time.Now()
+	}
+}
+
+// release ends a request which tells nothing about the server, e.g. failed
+// before sent or canceled by the caller. the probe of a half-open breaker
+// is given to the next request
+func (cB *circuitBreaker) release() {
+	if cB == nil {
+		return
+	}
+	cB.Lock()
+	defer cB.Unlock()
+	if cB.state == breakerHalfOpen {
+		cB.state = breakerOpen
+	}
+}
@@ -0,0 +1,113 @@
+package http_util
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// LoadBalance is how the endpoint of a request is picked
+type LoadBalance string
+
+const (
+	// Failover sends to the first healthy endpoint in order
+	Failover LoadBalance = "failover"
+	// RoundRobin rotates among the healthy endpoints
+	RoundRobin LoadBalance = "round_robin"
+)
+
+func (lB LoadBalance) IsValid() bool {
+	return lB == "" || lB == Failover || lB == RoundRobin
+}
+
+// ParseURL parses the base url of an endpoint. rawUrl without scheme,
+// like `ip:port/prefix`, is taken as of defaultScheme
+func ParseURL(rawUrl, defaultScheme string) (*url.URL, error) {
+	if !strings.Contains(rawUrl, "://") {
+		rawUrl = defaultScheme + "://" + rawUrl
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid url %s", rawUrl)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("invalid url %s: scheme should be http or https", rawUrl)
+	}
+	if u.Host == "" {
+		return nil, errors.Errorf("invalid url %s: lack host", rawUrl)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.Errorf("invalid url %s: should not have query or fragment", rawUrl)
+	}
+	return u, nil
+}
+
+// JoinURL appends subPath to the path of base, each segment is escaped
+func JoinURL(base *url.URL, subPath string) string {
+	u := *base
+	u.Path = path.Join("/", base.Path, subPath)
+	u.RawPath = ""
+	return u.String()
+}
+
+// endpoint is a replica of the server, with it's own circuit breaker
+// as the health of it
+type endpoint struct {
+	base    *url.URL
+	breaker *circuitBreaker
+}
+
+type endpoints struct {
+	list        []*endpoint
+	loadBalance LoadBalance
+	next        int
+	sync.Mutex
+}
+
+func newEndpoints(
+	bases []*url.URL, loadBalance LoadBalance, breakerConf CircuitBreakerConfig,
+) *endpoints {
+	e := &endpoints{loadBalance: loadBalance}
+	for _, base := range bases {
+		e.list = append(e.list, &endpoint{
+			base:    base,
+			breaker: newCircuitBreaker(breakerConf)})
+	}
+	return e
+}
+
+// pick returns the endpoint to send to, whose breaker let the request
+// through. last is the endpoint failed by the previous attempt, which is
+// picked only if no other one is healthy
+func (e *endpoints) pick(last *endpoint) (*endpoint, error) {
+	if len(e.list) == 0 {
+		return nil, errors.New("no server endpoint")
+	}
+	e.Lock()
+	start := 0
+	if e.loadBalance == RoundRobin {
+		start = e.next
+		e.next = (e.next + 1) % len(e.list)
+	}
+	e.Unlock()
+
+	for i := 0; i < len(e.list); i++ {
+		candidate := e.list[(start+i)%len(e.list)]
+		if candidate == last && len(e.list) > 1 {
+			continue
+		}
+		if candidate.breaker.allow() == nil {
+			return candidate, nil
+		}
+	}
+	if last != nil {
+		if err := last.breaker.allow(); err != nil {
+			return nil, err
+		}
+		return last, nil
+	}
+	return nil, ErrCircuitOpen
+}
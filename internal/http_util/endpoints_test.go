@@ -0,0 +1,97 @@
+package http_util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func mustParseURL(t *testing.T, rawUrl string) *url.URL {
+	u, err := ParseURL(rawUrl, "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestParseURL(t *testing.T) {
+	u := mustParseURL(t, "127.0.0.1:8080/bloc")
+	if u.Scheme != "http" || u.Host != "127.0.0.1:8080" || u.Path != "/bloc" {
+		t.Errorf("url without scheme should use the default one: %s", u)
+	}
+	for _, invalid := range []string{"ftp://server", "http://", "http://server?a=1", "http://%zz"} {
+		if _, err := ParseURL(invalid, "http"); err == nil {
+			t.Errorf("%s should be invalid", invalid)
+		}
+	}
+}
+
+func TestJoinURL(t *testing.T) {
+	base := mustParseURL(t, "https://bloc.example.com/bloc/api/v1/client/")
+	if u := JoinURL(base, "get_byte_value_by_key/a b#c"); u !=
+		"https://bloc.example.com/bloc/api/v1/client/get_byte_value_by_key/a%20b%23c" {
+		t.Errorf("unexpected joined url: %s", u)
+	}
+	if base.Path != "/bloc/api/v1/client/" {
+		t.Errorf("base should not be changed: %s", base)
+	}
+}
+
+// pathServer records the requests it get
+func pathServer(t *testing.T, status int) (*httptest.Server, *int32) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/prefix/api/ping" {
+			t.Errorf("unexpected request path: %s", r.URL.Path)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"status_code": 200}`))
+	}))
+	t.Cleanup(s.Close)
+	return s, &requests
+}
+
+func TestFailoverToHealthyEndpoint(t *testing.T) {
+	down, downRequests := pathServer(t, http.StatusServiceUnavailable)
+	up, upRequests := pathServer(t, http.StatusOK)
+	c := New(nil, Config{
+		Retry:          &fastRetry,
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Hour},
+		Endpoints: []*url.URL{
+			mustParseURL(t, down.URL+"/prefix"), mustParseURL(t, up.URL+"/prefix")}})
+
+	var resp interface{}
+	for i := 0; i < 3; i++ {
+		if err := c.Get(context.Background(), "api/ping", BlankHeader, &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if atomic.LoadInt32(downRequests) != 1 || atomic.LoadInt32(upRequests) != 3 {
+		t.Errorf("retry should go to the healthy endpoint & the down one skipped, requested %d & %d times",
+			*downRequests, *upRequests)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	first, firstRequests := pathServer(t, http.StatusOK)
+	second, secondRequests := pathServer(t, http.StatusOK)
+	c := New(nil, Config{
+		LoadBalance: RoundRobin,
+		Endpoints: []*url.URL{
+			mustParseURL(t, first.URL+"/prefix"), mustParseURL(t, second.URL+"/prefix")}})
+
+	var resp interface{}
+	for i := 0; i < 4; i++ {
+		if err := c.Get(context.Background(), "/api/ping", BlankHeader, &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if atomic.LoadInt32(firstRequests) != 2 || atomic.LoadInt32(secondRequests) != 2 {
+		t.Errorf("requests should be rotated, requested %d & %d times", *firstRequests, *secondRequests)
+	}
+}
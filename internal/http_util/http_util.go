@@ -2,94 +2,283 @@ package http_util
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
-)
+	"time"
 
-var (
-	httpClient  *http.Client
-	BlankHeader = map[string]string{}
+	"github.com/pkg/errors"
 )
 
+var BlankHeader = map[string]string{}
+
 const urlPrefix = "http://"
 
-func init() {
+// NewDefaultHTTPClient returns the http client used if none is provided
+func NewDefaultHTTPClient() *http.Client {
 	t := http.DefaultTransport.(*http.Transport).Clone()
 	t.MaxIdleConns = 300
 	t.MaxConnsPerHost = 300
 	t.MaxIdleConnsPerHost = 100
-	httpClient = &http.Client{Transport: t}
+	return &http.Client{Transport: t}
 }
 
-func get(remoteUrl string, headers map[string]string, respStructPointer interface{}) error {
-	if !strings.HasPrefix(remoteUrl, urlPrefix) {
-		remoteUrl = urlPrefix + remoteUrl
+// Config is how the requests of Client are sent, zero values are the defaults
+type Config struct {
+	// Auth authenticates every request if it's not nil
+	Auth Authenticator
+	// Timeout is of each attempt
+	Timeout time.Duration
+	// Retry is for idempotent requests
+	Retry *RetryPolicy
+	// NonIdempotentRetry is for non-idempotent requests
+	NonIdempotentRetry *RetryPolicy
+	// CircuitBreaker is of each endpoint, shared by all requests of the Client
+	CircuitBreaker *CircuitBreakerConfig
+	// Endpoints are the base urls of the server replicas, the urls of
+	// requests are relative to them if set, otherwise are absolute
+	Endpoints []*url.URL
+	// LoadBalance is how Endpoints are picked, Failover by default
+	LoadBalance LoadBalance
+}
+
+// Client does json requests by the http client it's created with
+type Client struct {
+	httpClient         *http.Client
+	auth               Authenticator
+	timeout            time.Duration
+	retry              RetryPolicy
+	nonIdempotentRetry RetryPolicy
+	endpoints          *endpoints
+}
+
+// New uses the default http client if httpClient is nil
+func New(httpClient *http.Client, conf Config) *Client {
+	if httpClient == nil {
+		httpClient = NewDefaultHTTPClient()
 	}
-	req, _ := http.NewRequest("GET", remoteUrl, nil)
-	for k, v := range headers {
-		req.Header.Set(k, v)
+	c := &Client{
+		httpClient:         httpClient,
+		auth:               conf.Auth,
+		timeout:            conf.Timeout,
+		retry:              DefaultRetryPolicy,
+		nonIdempotentRetry: DefaultNonIdempotentRetryPolicy}
+	if c.timeout <= 0 {
+		c.timeout = DefaultTimeout
 	}
-	httpResp, err := httpClient.Do(req)
-	if err != nil {
-		return err
+	if conf.Retry != nil {
+		c.retry = *conf.Retry
 	}
-	defer httpResp.Body.Close()
-	body, _ := ioutil.ReadAll(httpResp.Body)
-	err = json.Unmarshal(body, respStructPointer)
-	if err != nil {
-		return err
+	if conf.NonIdempotentRetry != nil {
+		c.nonIdempotentRetry = *conf.NonIdempotentRetry
+	}
+	breakerConf := DefaultCircuitBreakerConfig
+	if conf.CircuitBreaker != nil {
+		breakerConf = *conf.CircuitBreaker
 	}
-	return nil
+	bases := conf.Endpoints
+	if len(bases) == 0 {
+		// a single endpoint of absolute urls
+		bases = []*url.URL{nil}
+	}
+	c.endpoints = newEndpoints(bases, conf.LoadBalance, breakerConf)
+	return c
 }
 
-func Get(remoteUrl string, headers map[string]string, respStructPointer interface{}) (err error) {
-	for retriedAmount := 0; retriedAmount < 3; retriedAmount++ {
-		err = get(remoteUrl, headers, respStructPointer)
-		if err == nil {
-			return
-		}
+func withScheme(remoteUrl string) string {
+	if strings.HasPrefix(remoteUrl, urlPrefix) || strings.HasPrefix(remoteUrl, "https://") {
+		return remoteUrl
 	}
-	return
+	return urlPrefix + remoteUrl
 }
 
-func post(remoteUrl string, headers map[string]string, bodyByte []byte) ([]byte, error) {
-	if !strings.HasPrefix(remoteUrl, urlPrefix) {
-		remoteUrl = urlPrefix + remoteUrl
+func (ep *endpoint) url(remoteUrl string) string {
+	if ep.base == nil {
+		return withScheme(remoteUrl)
 	}
-	req, err := http.NewRequest("POST", remoteUrl, bytes.NewBuffer(bodyByte))
+	return JoinURL(ep.base, remoteUrl)
+}
+
+// doOnce sends the request a single time to ep, which is let through by
+// it's breaker. non-2xx responses are returned as StatusError with the body
+func (c *Client) doOnce(
+	ctx context.Context, ep *endpoint, method, remoteUrl string,
+	headers map[string]string, bodyByte []byte,
+) ([]byte, error) {
+	callerCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var body io.Reader
+	if bodyByte != nil {
+		body = bytes.NewReader(bodyByte)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, ep.url(remoteUrl), body)
 	if err != nil {
-		return []byte{}, err
+		ep.breaker.release()
+		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
+	if c.auth != nil {
+		if err := c.auth.Authenticate(req, bodyByte); err != nil {
+			ep.breaker.release()
+			return nil, errors.Wrap(err, "authenticate request failed")
+		}
+	}
 
-	resp, err := httpClient.Do(req)
-	if resp != nil {
-		defer resp.Body.Close()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, failed(callerCtx, ctx, ep, err)
 	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return []byte{}, err
+		return nil, failed(callerCtx, ctx, ep, err)
+	}
+	// the server is alive if it does not respond a status to retry
+	ep.breaker.done(!retryableStatus(resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return respBody, &StatusError{StatusCode: resp.StatusCode, Body: respBody}
 	}
-	return ioutil.ReadAll(resp.Body)
+	return respBody, nil
 }
 
-func PostJson(
-	remoteUrl string, headers map[string]string,
-	bodyByte []byte, respIns interface{}) error {
+// failed counts the failure of a sent request to the endpoint's breaker,
+// unless it's caused by the caller's ctx done. the error is returned with
+// the caller's ctx error, or ErrTimeout if ctx is timed out
+func failed(callerCtx, ctx context.Context, ep *endpoint, err error) error {
+	if callerCtx.Err() != nil {
+		ep.breaker.release()
+		return errors.Wrap(callerCtx.Err(), err.Error())
+	}
+	ep.breaker.done(false)
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.Wrap(ErrTimeout, err.Error())
+	}
+	return err
+}
+
+// do sends the request until it succeeds or the retry policy is used up,
+// a retry goes to another endpoint if there is a healthy one
+func (c *Client) do(
+	ctx context.Context, method, remoteUrl string,
+	headers map[string]string, bodyByte []byte, idempotent bool,
+) (respBody []byte, err error) {
+	policy := c.nonIdempotentRetry
+	if idempotent {
+		policy = c.retry
+	}
+	var ep *endpoint
+	for attempt := 1; ; attempt++ {
+		ep, err = c.endpoints.pick(ep)
+		if err == nil {
+			respBody, err = c.doOnce(ctx, ep, method, remoteUrl, headers, bodyByte)
+		}
+		if err == nil || attempt >= policy.MaxAttempts ||
+			ctx.Err() != nil || !shouldRetry(err, idempotent) {
+			return respBody, err
+		}
+		if !sleep(ctx, policy.backoff(attempt)) {
+			return respBody, err
+		}
+	}
+}
+
+// Get is retried as an idempotent request
+func (c *Client) Get(
+	ctx context.Context, remoteUrl string,
+	headers map[string]string, respStructPointer interface{},
+) error {
+	respBody, err := c.do(ctx, "GET", remoteUrl, headers, nil, true)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(respBody, respStructPointer)
+}
+
+func (c *Client) postJson(
+	ctx context.Context, remoteUrl string, headers map[string]string,
+	bodyByte []byte, respIns interface{}, idempotent bool,
+) error {
 	// if not do copy, exist concurrency issue
 	copyHeader := make(map[string]string, len(headers)+1)
 	for k, v := range headers {
 		copyHeader[k] = v
 	}
-	copyHeader["content-type"] = "application/json"
+	copyHeader["Content-Type"] = "application/json"
 
-	respBodyByte, err := post(remoteUrl, copyHeader, bodyByte)
+	respBodyByte, err := c.do(ctx, "POST", remoteUrl, copyHeader, bodyByte, idempotent)
 	if err != nil {
 		return err
 	}
 	return json.Unmarshal(respBodyByte, respIns)
 }
+
+// PostJson is retried only if the request surely not reach the server
+func (c *Client) PostJson(
+	ctx context.Context, remoteUrl string, headers map[string]string,
+	bodyByte []byte, respIns interface{}) error {
+	return c.postJson(ctx, remoteUrl, headers, bodyByte, respIns, false)
+}
+
+// PostJsonIdempotent is for the requests safe to be handled more than once
+// by the server, it's retried just like Get
+func (c *Client) PostJsonIdempotent(
+	ctx context.Context, remoteUrl string, headers map[string]string,
+	bodyByte []byte, respIns interface{}) error {
+	return c.postJson(ctx, remoteUrl, headers, bodyByte, respIns, true)
+}
+
+// Stream sends a POST whose body is streamed from body until it's closed,
+// so it's neither retried nor timed out. it's authenticated by the
+// StreamAuthenticator, ErrStreamNotAuthenticated if auth is not one.
+// the response is returned once the server responds 2xx,
+// the caller should close it's body
+func (c *Client) Stream(
+	ctx context.Context, remoteUrl string,
+	headers map[string]string, body io.Reader,
+) (*http.Response, error) {
+	if !CanAuthenticateStream(c.auth) {
+		return nil, ErrStreamNotAuthenticated
+	}
+	ep, err := c.endpoints.pick(nil)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", ep.url(remoteUrl), body)
+	if err != nil {
+		ep.breaker.release()
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if c.auth != nil {
+		if err := c.auth.(StreamAuthenticator).AuthenticateStream(req); err != nil {
+			ep.breaker.release()
+			return nil, errors.Wrap(err, "authenticate request failed")
+		}
+	}
+
+	// the timeout of httpClient would break the long-lived stream,
+	// which is bounded by ctx only
+	streamClient := *c.httpClient
+	streamClient.Timeout = 0
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, failed(ctx, ctx, ep, err)
+	}
+	ep.breaker.done(!retryableStatus(resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: respBody}
+	}
+	return resp, nil
+}
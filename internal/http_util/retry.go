@@ -0,0 +1,160 @@
+package http_util
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultTimeout = 30 * time.Second
+
+// ErrTimeout is returned when the server does not respond in the timeout
+var ErrTimeout = errors.New("server does not respond in time")
+
+// RetryPolicy is how a failed request is retried, by exponential backoff:
+// the n-th retry waits InitialBackoff * Multiplier^(n-1), capped by MaxBackoff,
+// randomized by ±Jitter of it
+type RetryPolicy struct {
+	// MaxAttempts includes the first one, <= 1 means no retry
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is in [0, 1]
+	Jitter float64
+}
+
+var (
+	// DefaultRetryPolicy is for idempotent requests, which are retried
+	// on network errors, 5xx & 429
+	DefaultRetryPolicy = RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2}
+	// DefaultNonIdempotentRetryPolicy is for non-idempotent requests, which are
+	// only retried if they surely not reach the server
+	DefaultNonIdempotentRetryPolicy = RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2}
+)
+
+func (rP RetryPolicy) backoff(retried int) time.Duration {
+	backoff := float64(rP.InitialBackoff)
+	for i := 1; i < retried; i++ {
+		backoff *= rP.Multiplier
+	}
+	if rP.MaxBackoff > 0 && backoff > float64(rP.MaxBackoff) {
+		backoff = float64(rP.MaxBackoff)
+	}
+	if rP.Jitter > 0 {
+		backoff += backoff * rP.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// StatusError is returned when the server responds a non-2xx status
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (sE *StatusError) Error() string {
+	return "server responded " + http.StatusText(sE.StatusCode)
+}
+
+func retryableStatus(statusCode int) bool {
+	return statusCode >= http.StatusInternalServerError ||
+		statusCode == http.StatusTooManyRequests
+}
+
+// notSent reports whether the request surely not reach the server,
+// so that even a non-idempotent one is safe to retry
+func notSent(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial"
+	}
+	return false
+}
+
+// Unreachable reports whether err is got as the server is not reached or
+// does not respond, so that the same request may succeed later. errors of
+// the caller's ctx, building or authenticating the request are not
+func Unreachable(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrTimeout) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		tlsFailed(err) {
+		return false
+	}
+	var (
+		opErr  *net.OpError
+		urlErr *url.Error
+	)
+	if errors.As(err, &opErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// timeout of http.Client
+	return errors.As(err, &urlErr) && urlErr.Timeout()
+}
+
+// tlsFailed reports whether the TLS handshake failed by the certificates,
+// which retrying does not help
+func tlsFailed(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+		opErr            *net.OpError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) {
+		return true
+	}
+	// alerts sent by the server, e.g. the client certificate is rejected
+	return errors.As(err, &opErr) && opErr.Op == "remote error"
+}
+
+func shouldRetry(err error, idempotent bool) bool {
+	if tlsFailed(err) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return retryableStatus(statusErr.StatusCode) &&
+			(idempotent || statusErr.StatusCode == http.StatusServiceUnavailable ||
+				statusErr.StatusCode == http.StatusTooManyRequests)
+	}
+	if idempotent {
+		return true
+	}
+	return notSent(err)
+}
+
+// sleep returns false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,174 @@
+package http_util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var fastRetry = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	Multiplier:     2}
+
+// flakyServer responds status to the first failures requests, then ok
+func flakyServer(t *testing.T, status int, failures int32) (*httptest.Server, *int32) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"status_code": 200}`))
+	}))
+	t.Cleanup(s.Close)
+	return s, &requests
+}
+
+func TestIdempotentRetry(t *testing.T) {
+	s, requests := flakyServer(t, http.StatusInternalServerError, 2)
+	c := New(nil, Config{Retry: &fastRetry})
+
+	var resp interface{}
+	if err := c.PostJsonIdempotent(context.Background(), s.URL, BlankHeader, []byte("{}"), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(requests) != 3 {
+		t.Errorf("should succeed at the 3rd attempt, requested %d times", *requests)
+	}
+}
+
+func TestNonIdempotentRetry(t *testing.T) {
+	s, requests := flakyServer(t, http.StatusInternalServerError, 1)
+	c := New(nil, Config{NonIdempotentRetry: &fastRetry})
+
+	var resp interface{}
+	err := c.PostJson(context.Background(), s.URL, BlankHeader, []byte("{}"), &resp)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("should fail by the 500 response, get: %v", err)
+	}
+	if atomic.LoadInt32(requests) != 1 {
+		t.Errorf("500 of non-idempotent request should not be retried, requested %d times", *requests)
+	}
+
+	// 503 means the request is not handled
+	s, requests = flakyServer(t, http.StatusServiceUnavailable, 1)
+	if err := c.PostJson(context.Background(), s.URL, BlankHeader, []byte("{}"), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(requests) != 2 {
+		t.Errorf("503 should be retried, requested %d times", *requests)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer s.Close()
+	noRetry := RetryPolicy{MaxAttempts: 1}
+	c := New(nil, Config{Timeout: 20 * time.Millisecond, Retry: &noRetry})
+
+	var resp interface{}
+	start := time.Now()
+	if err := c.Get(context.Background(), s.URL, BlankHeader, &resp); err == nil {
+		t.Fatal("request should time out")
+	}
+	if time.Since(start) > 150*time.Millisecond {
+		t.Errorf("request should be canceled by timeout, taken %s", time.Since(start))
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	s, requests := flakyServer(t, http.StatusBadGateway, 2)
+	noRetry := RetryPolicy{MaxAttempts: 1}
+	c := New(nil, Config{
+		Retry:          &noRetry,
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond}})
+
+	var resp interface{}
+	for i := 0; i < 2; i++ {
+		c.Get(context.Background(), s.URL, BlankHeader, &resp)
+	}
+	if err := c.Get(context.Background(), s.URL, BlankHeader, &resp); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("breaker should be open after 2 failures, get: %v", err)
+	}
+	if atomic.LoadInt32(requests) != 2 {
+		t.Errorf("request should not be sent when breaker is open, requested %d times", *requests)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := c.Get(context.Background(), s.URL, BlankHeader, &resp); err != nil {
+		t.Fatalf("probe after open timeout should succeed, get: %v", err)
+	}
+	if err := c.Get(context.Background(), s.URL, BlankHeader, &resp); err != nil {
+		t.Fatalf("breaker should be closed after the probe succeeded, get: %v", err)
+	}
+}
+
+// failingAuth fails to authenticate while fail is set
+type failingAuth struct {
+	fail int32
+}
+
+func (fA *failingAuth) Authenticate(req *http.Request, body []byte) error {
+	if atomic.LoadInt32(&fA.fail) == 1 {
+		return errors.New("no credential")
+	}
+	return nil
+}
+
+func TestCircuitBreakerProbeNotSent(t *testing.T) {
+	s, requests := flakyServer(t, http.StatusBadGateway, 1)
+	noRetry := RetryPolicy{MaxAttempts: 1}
+	auth := &failingAuth{}
+	c := New(nil, Config{
+		Auth:           auth,
+		Retry:          &noRetry,
+		CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: 50 * time.Millisecond}})
+
+	var resp interface{}
+	c.Get(context.Background(), s.URL, BlankHeader, &resp)
+	time.Sleep(60 * time.Millisecond)
+
+	// the probe is not sent, neither does the canceled one tell the server is down
+	atomic.StoreInt32(&auth.fail, 1)
+	if err := c.Get(context.Background(), s.URL, BlankHeader, &resp); errors.Is(err, ErrCircuitOpen) || err == nil {
+		t.Fatalf("probe should fail by authenticating, get: %v", err)
+	}
+	atomic.StoreInt32(&auth.fail, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Get(ctx, s.URL, BlankHeader, &resp)
+
+	if err := c.Get(context.Background(), s.URL, BlankHeader, &resp); err != nil {
+		t.Fatalf("the probe should be given to the next request, get: %v", err)
+	}
+	if atomic.LoadInt32(requests) != 2 {
+		t.Errorf("unexpected requests: %d", *requests)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+		Jitter:         0.5}
+	cases := map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second}
+	for retried, base := range cases {
+		for i := 0; i < 100; i++ {
+			backoff := policy.backoff(retried)
+			if backoff < base/2 || backoff > base*3/2 {
+				t.Fatalf("backoff of retry %d should be %s ± 50%%, get %s", retried, base, backoff)
+			}
+		}
+	}
+}
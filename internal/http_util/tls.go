@@ -0,0 +1,40 @@
+package http_util
+
+import (
+	"net/http"
+
+	"github.com/fBloc/bloc-client-go/internal/tlsconf"
+
+	"github.com/pkg/errors"
+)
+
+// TLSConfig makes the server called by https://
+type TLSConfig = tlsconf.Config
+
+// WithTLS returns a copy of httpClient whose transport uses the tls config,
+// httpClient is the default one if nil.
+// a custom RoundTripper should set up TLS by itself
+func WithTLS(httpClient *http.Client, tlsConf *TLSConfig) (*http.Client, error) {
+	if httpClient == nil {
+		httpClient = NewDefaultHTTPClient()
+	}
+	tlsClientConfig, err := tlsConf.TLSClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var transport *http.Transport
+	switch t := httpClient.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return nil, errors.Errorf("cannot set TLS to the custom transport %T", t)
+	}
+	transport.TLSClientConfig = tlsClientConfig
+
+	copied := *httpClient
+	copied.Transport = transport
+	return &copied, nil
+}
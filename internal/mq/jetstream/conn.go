@@ -0,0 +1,297 @@
+package jetstream
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/mq"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	var _ mq.MsgQueue = &JetStreamMQ{}
+	var _ mq.Broadcaster = &JetStreamMQ{}
+}
+
+const (
+	DefaultStreamName    = "bloc"
+	DefaultSubjectPrefix = "bloc"
+	// DefaultAckWait is long as a function run is acked after it finished,
+	// a msg being handled is kept in progress anyway
+	DefaultAckWait = 30 * time.Minute
+	fetchMaxWait   = 5 * time.Second
+)
+
+type JetStreamConfig struct {
+	URLs     []string
+	User     string
+	Password string
+	Token    string
+	// StreamName is the stream all topics are kept in, created if not exist
+	StreamName string
+	// SubjectPrefix is prepended to every topic to get it's subject,
+	// the stream is bound to `SubjectPrefix.>`
+	SubjectPrefix string
+	// MaxDeliver is the max times a msg is delivered before given up,
+	// <= 0 means no limit
+	MaxDeliver int
+	// AckWait is how long an unacked msg is waited before redelivered,
+	// the msg not acked yet is told in progress every AckWait/3
+	AckWait time.Duration
+}
+
+func (jC *JetStreamConfig) IsNil() bool {
+	if jC == nil {
+		return true
+	}
+	return len(jC.URLs) <= 0
+}
+
+func (jC *JetStreamConfig) withDefault() JetStreamConfig {
+	conf := *jC
+	if conf.StreamName == "" {
+		conf.StreamName = DefaultStreamName
+	}
+	if conf.SubjectPrefix == "" {
+		conf.SubjectPrefix = DefaultSubjectPrefix
+	}
+	if conf.AckWait <= 0 {
+		conf.AckWait = DefaultAckWait
+	}
+	return conf
+}
+
+// JetStreamMQ implements mq.MsgQueue on NATS JetStream.
+// a topic maps to the subject `SubjectPrefix.topic`, and a puller tag maps to
+// a durable pull consumer filtered by the subject, so replicas using the same
+// puller tag share the msgs just like a rabbit queue.
+type JetStreamMQ struct {
+	conf JetStreamConfig
+	conn *nats.Conn
+	js   nats.JetStreamContext
+}
+
+func Connect(conf *JetStreamConfig) (*JetStreamMQ, error) {
+	if conf.IsNil() {
+		return nil, errors.New("jetstream config lack urls")
+	}
+	c := conf.withDefault()
+
+	opts := []nats.Option{
+		nats.Name("bloc-client-go"),
+		nats.MaxReconnects(-1),
+	}
+	if c.User != "" {
+		opts = append(opts, nats.UserInfo(c.User, c.Password))
+	}
+	if c.Token != "" {
+		opts = append(opts, nats.Token(c.Token))
+	}
+	conn, err := nats.Connect(strings.Join(c.URLs, ","), opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "connect to nats failed")
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "get jetstream context failed")
+	}
+
+	jsMQ := &JetStreamMQ{conf: c, conn: conn, js: js}
+	if err := jsMQ.ensureStream(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return jsMQ, nil
+}
+
+func (jsMQ *JetStreamMQ) ensureStream() error {
+	_, err := jsMQ.js.StreamInfo(jsMQ.conf.StreamName)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return errors.Wrap(err, "get stream info failed")
+	}
+	_, err = jsMQ.js.AddStream(&nats.StreamConfig{
+		Name:     jsMQ.conf.StreamName,
+		Subjects: []string{jsMQ.conf.SubjectPrefix + ".>"},
+		// msgs are removed once acked by all durable consumers,
+		// which is what a rabbit queue per puller tag does
+		Retention: nats.InterestPolicy,
+		Storage:   nats.FileStorage,
+	})
+	return errors.Wrap(err, "create stream failed")
+}
+
+func (jsMQ *JetStreamMQ) ensureConsumer(topic, durable string) error {
+	_, err := jsMQ.js.ConsumerInfo(jsMQ.conf.StreamName, durable)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, nats.ErrConsumerNotFound) {
+		return errors.Wrap(err, "get consumer info failed")
+	}
+	consumerConf := &nats.ConsumerConfig{
+		Durable:       durable,
+		FilterSubject: jsMQ.subject(topic),
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       jsMQ.conf.AckWait,
+		DeliverPolicy: nats.DeliverAllPolicy,
+	}
+	if jsMQ.conf.MaxDeliver > 0 {
+		consumerConf.MaxDeliver = jsMQ.conf.MaxDeliver
+	}
+	_, err = jsMQ.js.AddConsumer(jsMQ.conf.StreamName, consumerConf)
+	return errors.Wrap(err, "failed to create durable consumer")
+}
+
+func (jsMQ *JetStreamMQ) subject(topic string) string {
+	return jsMQ.conf.SubjectPrefix + "." + topic
+}
+
+// durableName replaces chars not allowed in a durable name
+func durableName(pullerTag string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(pullerTag)
+}
+
+func (jsMQ *JetStreamMQ) Pub(topic string, data []byte) error {
+	_, err := jsMQ.js.Publish(jsMQ.subject(topic), data)
+	return err
+}
+
+func (jsMQ *JetStreamMQ) Pull(
+	topic, pullerTag string,
+	respDeliveryChan chan *mq.Delivery,
+) error {
+	durable := durableName(pullerTag)
+	if err := jsMQ.ensureConsumer(topic, durable); err != nil {
+		return err
+	}
+	// bind to the consumer instead of letting the library create it,
+	// or it would be deleted once the subscription is drained
+	sub, err := jsMQ.js.PullSubscribe(
+		jsMQ.subject(topic), durable,
+		nats.Bind(jsMQ.conf.StreamName, durable), nats.ManualAck())
+	if err != nil {
+		return errors.Wrap(err, "failed to subscribe the durable consumer")
+	}
+
+	go func() {
+		for {
+			// the next is fetched after the last one is taken by the receiver,
+			// so the unacked msgs are bounded by how many it holds
+			msgs, err := sub.Fetch(1, nats.MaxWait(fetchMaxWait))
+			if errors.Is(err, nats.ErrTimeout) {
+				continue
+			}
+			if err != nil {
+				if !sub.IsValid() || jsMQ.conn.IsClosed() {
+					return
+				}
+				time.Sleep(time.Second)
+				continue
+			}
+			for _, msg := range msgs {
+				respDeliveryChan <- jsMQ.toDelivery(msg)
+			}
+		}
+	}()
+	return nil
+}
+
+// PullBroadcast pulls by an ephemeral consumer, which is deleted once closed
+func (jsMQ *JetStreamMQ) PullBroadcast(topic string, respDeliveryChan chan *mq.Delivery) error {
+	_, err := jsMQ.js.Subscribe(
+		jsMQ.subject(topic),
+		func(msg *nats.Msg) {
+			respDeliveryChan <- jsMQ.toDelivery(msg)
+		},
+		nats.DeliverNew(), nats.ManualAck(), nats.AckWait(jsMQ.conf.AckWait))
+	return errors.Wrap(err, "failed to subscribe an ephemeral consumer")
+}
+
+func (jsMQ *JetStreamMQ) toDelivery(msg *nats.Msg) *mq.Delivery {
+	d := &mq.Delivery{
+		Acknowledger: newMsgAcknowledger(msg, jsMQ.conf.AckWait/3),
+		Topic:        strings.TrimPrefix(msg.Subject, jsMQ.conf.SubjectPrefix+"."),
+		Body:         msg.Data,
+	}
+	if len(msg.Header) > 0 {
+		d.Headers = make(map[string]interface{}, len(msg.Header))
+		for k := range msg.Header {
+			d.Headers[k] = msg.Header.Get(k)
+		}
+	}
+	if meta, err := msg.Metadata(); err == nil {
+		d.DeliveryCount = int(meta.NumDelivered)
+		d.Redelivered = meta.NumDelivered > 1
+	}
+	return d
+}
+
+// Close drains the connection, unacked msgs are redelivered after AckWait
+func (jsMQ *JetStreamMQ) Close() error {
+	return jsMQ.conn.Drain()
+}
+
+// msgAcknowledger keeps the msg in progress until it's acked or nacked,
+// or it's redelivered after AckWait while still being handled
+type msgAcknowledger struct {
+	msg      *nats.Msg
+	done     chan struct{}
+	doneOnce sync.Once
+	// serializes the acks of msg
+	sync.Mutex
+}
+
+func newMsgAcknowledger(msg *nats.Msg, inProgressInterval time.Duration) *msgAcknowledger {
+	mA := &msgAcknowledger{msg: msg, done: make(chan struct{})}
+	go mA.keepInProgress(inProgressInterval)
+	return mA
+}
+
+func (mA *msgAcknowledger) keepInProgress(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mA.Lock()
+			mA.msg.InProgress()
+			mA.Unlock()
+		case <-mA.done:
+			return
+		}
+	}
+}
+
+// settle stops keeping the msg in progress & acks it by ack
+func (mA *msgAcknowledger) settle(ack func(...nats.AckOpt) error) error {
+	mA.doneOnce.Do(func() { close(mA.done) })
+	mA.Lock()
+	defer mA.Unlock()
+	return ack()
+}
+
+func (mA *msgAcknowledger) Ack() error {
+	return mA.settle(mA.msg.Ack)
+}
+
+// Nack without requeue terminates the msg so it will never be redelivered
+func (mA *msgAcknowledger) Nack(requeue bool) error {
+	if requeue {
+		return mA.settle(mA.msg.Nak)
+	}
+	return mA.settle(mA.msg.Term)
+}
+
+// NackWithDelay makes the server redeliver the msg after delay
+func (mA *msgAcknowledger) NackWithDelay(delay time.Duration) error {
+	return mA.settle(func(opts ...nats.AckOpt) error {
+		return mA.msg.NakWithDelay(delay, opts...)
+	})
+}
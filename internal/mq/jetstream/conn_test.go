@@ -0,0 +1,214 @@
+package jetstream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/mq"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+func runServer(t *testing.T) *server.Server {
+	t.Helper()
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func connect(t *testing.T, conf *JetStreamConfig) *JetStreamMQ {
+	t.Helper()
+	jsMQ, err := Connect(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { jsMQ.Close() })
+	return jsMQ
+}
+
+func receive(t *testing.T, deliveryChan chan *mq.Delivery) *mq.Delivery {
+	t.Helper()
+	select {
+	case d := <-deliveryChan:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("receive delivery timeout")
+	}
+	return nil
+}
+
+func TestPubPullAck(t *testing.T) {
+	s := runServer(t)
+	jsMQ := connect(t, &JetStreamConfig{URLs: []string{s.ClientURL()}})
+
+	topic := "function_client_run_consumer.tryout"
+	deliveryChan := make(chan *mq.Delivery)
+	if err := jsMQ.Pull(topic, "tryout", deliveryChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := jsMQ.Pub(topic, []byte("run")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := receive(t, deliveryChan)
+	if string(d.Body) != "run" || d.Topic != topic || d.Redelivered || d.DeliveryCount != 1 {
+		t.Fatalf("unexpected delivery: %+v", d)
+	}
+	if err := d.Ack(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case d := <-deliveryChan:
+		t.Fatalf("acked msg should not be redelivered: %+v", d)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRedeliveryAndMaxDeliver(t *testing.T) {
+	s := runServer(t)
+	jsMQ := connect(t, &JetStreamConfig{
+		URLs:       []string{s.ClientURL()},
+		MaxDeliver: 2,
+		AckWait:    time.Second})
+
+	topic := "function_client_run_consumer.tryout"
+	deliveryChan := make(chan *mq.Delivery)
+	if err := jsMQ.Pull(topic, "tryout", deliveryChan); err != nil {
+		t.Fatal(err)
+	}
+	jsMQ.Pub(topic, []byte("run"))
+
+	first := receive(t, deliveryChan)
+	if err := first.Nack(true); err != nil {
+		t.Fatal(err)
+	}
+	second := receive(t, deliveryChan)
+	if string(second.Body) != "run" || !second.Redelivered || second.DeliveryCount != 2 {
+		t.Fatalf("nacked msg should be redelivered: %+v", second)
+	}
+	// reached max deliver, should not be delivered any more even not acked
+	second.Nack(true)
+	select {
+	case d := <-deliveryChan:
+		t.Fatalf("msg exceeds max deliver should not be redelivered: %+v", d)
+	case <-time.After(1500 * time.Millisecond):
+	}
+}
+
+func TestDurableConsumerSurvivesReconnect(t *testing.T) {
+	s := runServer(t)
+	conf := &JetStreamConfig{URLs: []string{s.ClientURL()}}
+	topic := "function_client_run_consumer.tryout"
+
+	first := connect(t, conf)
+	firstChan := make(chan *mq.Delivery)
+	first.Pull(topic, "tryout", firstChan)
+	first.Close()
+
+	// published while no one pulling, kept by the durable consumer
+	publisher := connect(t, conf)
+	publisher.Pub(topic, []byte("run"))
+
+	second := connect(t, conf)
+	secondChan := make(chan *mq.Delivery)
+	if err := second.Pull(topic, "tryout", secondChan); err != nil {
+		t.Fatal(err)
+	}
+	d := receive(t, secondChan)
+	if string(d.Body) != "run" {
+		t.Fatalf("unexpected delivery: %+v", d)
+	}
+	d.Ack()
+}
+
+func TestPullBroadcast(t *testing.T) {
+	s := runServer(t)
+	replicas := []*JetStreamMQ{
+		connect(t, &JetStreamConfig{URLs: []string{s.ClientURL()}}),
+		connect(t, &JetStreamConfig{URLs: []string{s.ClientURL()}}),
+	}
+
+	topic := "function_run_cancel.tryout"
+	deliveryChans := make([]chan *mq.Delivery, len(replicas))
+	for i, replica := range replicas {
+		deliveryChans[i] = make(chan *mq.Delivery)
+		if err := replica.PullBroadcast(topic, deliveryChans[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := replicas[0].Pub(topic, []byte("cancel")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, deliveryChan := range deliveryChans {
+		d := receive(t, deliveryChan)
+		if string(d.Body) != "cancel" || d.Topic != topic {
+			t.Fatalf("unexpected delivery: %+v", d)
+		}
+		if err := d.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLongRunKeptInProgress(t *testing.T) {
+	s := runServer(t)
+	jsMQ := connect(t, &JetStreamConfig{
+		URLs:    []string{s.ClientURL()},
+		AckWait: 600 * time.Millisecond})
+
+	topic := "function_client_run_consumer.tryout"
+	deliveryChan := make(chan *mq.Delivery, 1)
+	if err := jsMQ.Pull(topic, "tryout", deliveryChan); err != nil {
+		t.Fatal(err)
+	}
+	jsMQ.Pub(topic, []byte("run"))
+
+	d := receive(t, deliveryChan)
+	// runs longer than AckWait
+	select {
+	case redelivered := <-deliveryChan:
+		t.Fatalf("msg being handled should not be redelivered: %+v", redelivered)
+	case <-time.After(2 * time.Second):
+	}
+	if err := d.Ack(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNackWithDelay(t *testing.T) {
+	s := runServer(t)
+	jsMQ := connect(t, &JetStreamConfig{URLs: []string{s.ClientURL()}})
+
+	topic := "function_client_run_consumer.tryout"
+	deliveryChan := make(chan *mq.Delivery)
+	if err := jsMQ.Pull(topic, "tryout", deliveryChan); err != nil {
+		t.Fatal(err)
+	}
+	jsMQ.Pub(topic, []byte("run"))
+
+	nackedAt := time.Now()
+	if err := receive(t, deliveryChan).NackWithDelay(500 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	d := receive(t, deliveryChan)
+	if time.Since(nackedAt) < 500*time.Millisecond || !d.Redelivered {
+		t.Fatalf("msg should be redelivered after the delay, redelivered in %s", time.Since(nackedAt))
+	}
+	d.Ack()
+}
@@ -0,0 +1,391 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fBloc/bloc-client-go/internal/mq"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	var _ mq.MsgQueue = &MemoryMQ{}
+	var _ mq.Broadcaster = &MemoryMQ{}
+}
+
+var (
+	ErrUnknownDeliveryTag = errors.New("unknown delivery tag")
+	ErrClosed             = errors.New("memory mq already closed")
+)
+
+// MemoryMQ is an in-process mq.MsgQueue which follows the same model as the
+// rabbit implementation: every puller tag is a durable queue bound to the
+// topic exchange by routing key, a published msg is copied to every queue
+// whose binding matches, and a delivered msg stays unacked until it is acked
+// or requeued. It is meant for tests which should not rely on a live broker.
+type MemoryMQ struct {
+	queues      map[string]*queue
+	unacked     map[uint64]*unackedDelivery
+	deliveryTag uint64
+	pubSeq      uint64
+	broadcasts  int
+	closed      chan struct{}
+	closeOnce   sync.Once
+	sync.Mutex
+}
+
+type msg struct {
+	seq           uint64 // publish sequence, queue is kept in this order
+	topic         string
+	body          []byte
+	deliveryCount int
+}
+
+type queue struct {
+	name     string
+	bindings []string
+	ready    []*msg
+	notify   chan struct{}
+	// requeued is closed & renewed every time msgs are requeued, so that
+	// pullers blocked on sending a later msg give it back and keep the order
+	requeued chan struct{}
+}
+
+type unackedDelivery struct {
+	queue *queue
+	msg   *msg
+	// inFlight means popped from the queue but not yet received by the puller
+	inFlight bool
+}
+
+// acknowledger acks the delivery by it's delivery tag
+type acknowledger struct {
+	mq          *MemoryMQ
+	deliveryTag uint64
+}
+
+func New() *MemoryMQ {
+	return &MemoryMQ{
+		queues:  make(map[string]*queue),
+		unacked: make(map[uint64]*unackedDelivery),
+		closed:  make(chan struct{}),
+	}
+}
+
+// topicMatch reports whether routingKey matches the binding key under amqp
+// topic exchange rules: words are split by ".", "*" matches exactly one word
+// and "#" matches zero or more words.
+func topicMatch(bindingKey, routingKey string) bool {
+	return wordsMatch(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+}
+
+func wordsMatch(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if wordsMatch(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && wordsMatch(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] &&
+			wordsMatch(pattern[1:], words[1:])
+	}
+}
+
+func (q *queue) wakeUp() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *queue) boundTo(topic string) bool {
+	for _, binding := range q.bindings {
+		if binding == topic {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *queue) matches(topic string) bool {
+	for _, binding := range q.bindings {
+		if topicMatch(binding, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// putBack inserts the msg back to the queue by its publish sequence
+func (q *queue) putBack(m *msg) {
+	index := sort.Search(len(q.ready), func(i int) bool {
+		return q.ready[i].seq > m.seq
+	})
+	q.ready = append(q.ready, nil)
+	copy(q.ready[index+1:], q.ready[index:])
+	q.ready[index] = m
+	q.wakeUp()
+}
+
+// Bind declares the queue if not exist and binds it to the topic.
+// Msgs published to a topic no queue is bound to are dropped just like
+// rabbit does, so tests publishing before the consumer is up should Bind first.
+func (mmq *MemoryMQ) Bind(topic, queueName string) {
+	mmq.Lock()
+	defer mmq.Unlock()
+	mmq.bind(topic, queueName)
+}
+
+func (mmq *MemoryMQ) bind(topic, queueName string) *queue {
+	q, ok := mmq.queues[queueName]
+	if !ok {
+		q = &queue{
+			name:     queueName,
+			notify:   make(chan struct{}, 1),
+			requeued: make(chan struct{})}
+		mmq.queues[queueName] = q
+	}
+	if !q.boundTo(topic) {
+		q.bindings = append(q.bindings, topic)
+	}
+	return q
+}
+
+func (mmq *MemoryMQ) isClosed() bool {
+	select {
+	case <-mmq.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (mmq *MemoryMQ) Pub(topic string, data []byte) error {
+	mmq.Lock()
+	defer mmq.Unlock()
+	if mmq.isClosed() {
+		return ErrClosed
+	}
+
+	mmq.pubSeq++
+	for _, q := range mmq.queues {
+		if !q.matches(topic) {
+			continue
+		}
+		body := make([]byte, len(data))
+		copy(body, data)
+		q.ready = append(q.ready, &msg{
+			seq:   mmq.pubSeq,
+			topic: topic,
+			body:  body})
+		q.wakeUp()
+	}
+	return nil
+}
+
+func (mmq *MemoryMQ) Pull(
+	topic, pullerTag string,
+	respDeliveryChan chan *mq.Delivery,
+) error {
+	mmq.Lock()
+	if mmq.isClosed() {
+		mmq.Unlock()
+		return ErrClosed
+	}
+	q := mmq.bind(topic, pullerTag)
+	mmq.Unlock()
+
+	go func() {
+		for {
+			d, deliveryTag, requeued := mmq.deliverNext(q)
+			if d == nil {
+				select {
+				case <-q.notify:
+					continue
+				case <-mmq.closed:
+					return
+				}
+			}
+			select {
+			case respDeliveryChan <- d:
+				mmq.landed(deliveryTag)
+			case <-requeued:
+				mmq.giveBack(deliveryTag)
+			case <-mmq.closed:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// PullBroadcast pulls from a queue of it's own, just like rabbit's exclusive queue
+func (mmq *MemoryMQ) PullBroadcast(topic string, respDeliveryChan chan *mq.Delivery) error {
+	mmq.Lock()
+	mmq.broadcasts++
+	queueName := fmt.Sprintf("broadcast.%d", mmq.broadcasts)
+	mmq.Unlock()
+	return mmq.Pull(topic, queueName, respDeliveryChan)
+}
+
+// deliverNext pops the head of the queue and marks it as unacked
+func (mmq *MemoryMQ) deliverNext(q *queue) (*mq.Delivery, uint64, chan struct{}) {
+	mmq.Lock()
+	defer mmq.Unlock()
+	if len(q.ready) == 0 {
+		return nil, 0, nil
+	}
+	m := q.ready[0]
+	q.ready = q.ready[1:]
+	if len(q.ready) > 0 {
+		q.wakeUp()
+	}
+
+	mmq.deliveryTag++
+	mmq.unacked[mmq.deliveryTag] = &unackedDelivery{
+		queue: q, msg: m, inFlight: true}
+	m.deliveryCount++
+	body := make([]byte, len(m.body))
+	copy(body, m.body)
+	d := &mq.Delivery{
+		Acknowledger:  &acknowledger{mq: mmq, deliveryTag: mmq.deliveryTag},
+		Topic:         m.topic,
+		Body:          body,
+		Redelivered:   m.deliveryCount > 1,
+		DeliveryCount: m.deliveryCount,
+	}
+	return d, mmq.deliveryTag, q.requeued
+}
+
+func (mmq *MemoryMQ) landed(deliveryTag uint64) {
+	mmq.Lock()
+	defer mmq.Unlock()
+	if u, ok := mmq.unacked[deliveryTag]; ok {
+		u.inFlight = false
+	}
+}
+
+// giveBack returns a popped but not received msg to the queue
+func (mmq *MemoryMQ) giveBack(deliveryTag uint64) {
+	mmq.Lock()
+	defer mmq.Unlock()
+	u, ok := mmq.unacked[deliveryTag]
+	if !ok || !u.inFlight {
+		return
+	}
+	delete(mmq.unacked, deliveryTag)
+	// never received, so not counted as delivered
+	u.msg.deliveryCount--
+	u.queue.putBack(u.msg)
+}
+
+func (mmq *MemoryMQ) Ack(deliveryTag uint64) error {
+	mmq.Lock()
+	defer mmq.Unlock()
+	if _, ok := mmq.unacked[deliveryTag]; !ok {
+		return errors.Wrapf(ErrUnknownDeliveryTag, "delivery tag %d", deliveryTag)
+	}
+	delete(mmq.unacked, deliveryTag)
+	return nil
+}
+
+// Requeue puts an unacked msg back to its queue and marks it as redelivered.
+// If requeue is false the msg is dropped.
+func (mmq *MemoryMQ) Requeue(deliveryTag uint64, requeue bool) error {
+	mmq.Lock()
+	defer mmq.Unlock()
+	u, ok := mmq.unacked[deliveryTag]
+	if !ok {
+		return errors.Wrapf(ErrUnknownDeliveryTag, "delivery tag %d", deliveryTag)
+	}
+	delete(mmq.unacked, deliveryTag)
+	if requeue {
+		mmq.requeue(u)
+		mmq.notifyRequeued(u.queue)
+	}
+	return nil
+}
+
+func (mmq *MemoryMQ) requeue(u *unackedDelivery) {
+	u.queue.putBack(u.msg)
+}
+
+func (mmq *MemoryMQ) notifyRequeued(q *queue) {
+	close(q.requeued)
+	q.requeued = make(chan struct{})
+}
+
+// RequeueUnacked requeues every unacked msg of the queue, just like the broker
+// does when the consumer's channel is closed before acking.
+// It returns the amount of requeued msgs.
+func (mmq *MemoryMQ) RequeueUnacked(queueName string) int {
+	mmq.Lock()
+	defer mmq.Unlock()
+	q, ok := mmq.queues[queueName]
+	if !ok {
+		return 0
+	}
+	amount := 0
+	for tag, u := range mmq.unacked {
+		if u.queue != q || u.inFlight {
+			continue
+		}
+		delete(mmq.unacked, tag)
+		mmq.requeue(u)
+		amount++
+	}
+	if amount > 0 {
+		mmq.notifyRequeued(q)
+	}
+	return amount
+}
+
+// Ready returns the amount of msgs waiting to be delivered in the queue
+func (mmq *MemoryMQ) Ready(queueName string) int {
+	mmq.Lock()
+	defer mmq.Unlock()
+	q, ok := mmq.queues[queueName]
+	if !ok {
+		return 0
+	}
+	return len(q.ready)
+}
+
+// Unacked returns the amount of delivered but not yet acked msgs
+func (mmq *MemoryMQ) Unacked() int {
+	mmq.Lock()
+	defer mmq.Unlock()
+	amount := 0
+	for _, u := range mmq.unacked {
+		if !u.inFlight {
+			amount++
+		}
+	}
+	return amount
+}
+
+// Close stops delivering msgs. Msgs not yet acked stay unacked.
+func (mmq *MemoryMQ) Close() {
+	mmq.closeOnce.Do(func() {
+		close(mmq.closed)
+	})
+}
+
+func (a *acknowledger) Ack() error {
+	return a.mq.Ack(a.deliveryTag)
+}
+
+func (a *acknowledger) Nack(requeue bool) error {
+	return a.mq.Requeue(a.deliveryTag, requeue)
+}
@@ -0,0 +1,147 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/mq"
+)
+
+func receive(t *testing.T, deliveryChan chan *mq.Delivery) *mq.Delivery {
+	t.Helper()
+	select {
+	case d := <-deliveryChan:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("receive delivery timeout")
+	}
+	return nil
+}
+
+func TestTopicMatch(t *testing.T) {
+	cases := []struct {
+		binding, routingKey string
+		match               bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.b", "a.c", false},
+		{"a.*", "a.b", true},
+		{"a.*", "a.b.c", false},
+		{"a.#", "a", true},
+		{"a.#", "a.b.c", true},
+		{"#.c", "a.b.c", true},
+		{"a.*.c", "a.b.c", true},
+		{"a.*.c", "a.c", false},
+	}
+	for _, c := range cases {
+		if topicMatch(c.binding, c.routingKey) != c.match {
+			t.Errorf("topicMatch(%s, %s) should be %v",
+				c.binding, c.routingKey, c.match)
+		}
+	}
+}
+
+func TestPubPullAck(t *testing.T) {
+	mmq := New()
+	defer mmq.Close()
+
+	deliveryChan := make(chan *mq.Delivery)
+	if err := mmq.Pull("topic.a", "queue_a", deliveryChan); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmq.Pub("topic.a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// no queue bound to, should be dropped
+	if err := mmq.Pub("topic.b", []byte("dropped")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := receive(t, deliveryChan)
+	if string(d.Body) != "hello" || d.Topic != "topic.a" || d.Redelivered || d.DeliveryCount != 1 {
+		t.Fatalf("unexpected delivery: %+v", d)
+	}
+	if mmq.Unacked() != 1 {
+		t.Fatalf("should have 1 unacked msg, get %d", mmq.Unacked())
+	}
+	if err := d.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if mmq.Unacked() != 0 {
+		t.Fatalf("should have no unacked msg, get %d", mmq.Unacked())
+	}
+	if err := d.Ack(); err == nil {
+		t.Fatal("ack twice should fail")
+	}
+}
+
+func TestRedelivery(t *testing.T) {
+	mmq := New()
+	defer mmq.Close()
+
+	mmq.Bind("topic.a", "queue_a")
+	mmq.Pub("topic.a", []byte("first"))
+	mmq.Pub("topic.a", []byte("second"))
+	if mmq.Ready("queue_a") != 2 {
+		t.Fatalf("should have 2 ready msgs, get %d", mmq.Ready("queue_a"))
+	}
+
+	deliveryChan := make(chan *mq.Delivery)
+	mmq.Pull("topic.a", "queue_a", deliveryChan)
+
+	first := receive(t, deliveryChan)
+	if string(first.Body) != "first" {
+		t.Fatalf("msg should be delivered in order, get %s", first.Body)
+	}
+	// consumer crashed before ack
+	if amount := mmq.RequeueUnacked("queue_a"); amount != 1 {
+		t.Fatalf("should requeue 1 msg, get %d", amount)
+	}
+
+	redelivered := receive(t, deliveryChan)
+	if string(redelivered.Body) != "first" || !redelivered.Redelivered {
+		t.Fatalf("requeued msg should be redelivered first: %+v", redelivered)
+	}
+	if err := redelivered.Nack(true); err != nil {
+		t.Fatal(err)
+	}
+	redelivered = receive(t, deliveryChan)
+	if string(redelivered.Body) != "first" || redelivered.DeliveryCount != 3 {
+		t.Fatalf("nacked msg should be redelivered: %+v", redelivered)
+	}
+	redelivered.Ack()
+
+	second := receive(t, deliveryChan)
+	if string(second.Body) != "second" || second.Redelivered {
+		t.Fatalf("unexpected delivery: %+v", second)
+	}
+	second.Nack(false)
+	if mmq.Unacked() != 0 || mmq.Ready("queue_a") != 0 {
+		t.Fatalf("rejected without requeue should be dropped")
+	}
+}
+
+func TestPullBroadcast(t *testing.T) {
+	mmq := New()
+	defer mmq.Close()
+
+	deliveryChans := []chan *mq.Delivery{make(chan *mq.Delivery), make(chan *mq.Delivery)}
+	for _, deliveryChan := range deliveryChans {
+		if err := mmq.PullBroadcast("topic.a", deliveryChan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mmq.Pub("topic.a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	for _, deliveryChan := range deliveryChans {
+		d := receive(t, deliveryChan)
+		if string(d.Body) != "hello" {
+			t.Fatalf("unexpected delivery: %+v", d)
+		}
+		d.Ack()
+	}
+	if mmq.Unacked() != 0 {
+		t.Errorf("all deliveries should be acked")
+	}
+}
@@ -1,9 +1,93 @@
 package mq
 
-import "github.com/streadway/amqp"
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNoAcknowledger = errors.New("delivery has no acknowledger")
+	// ErrUnroutable is returned by Pub if no queue is bound to the topic,
+	// by brokers able to tell it
+	ErrUnroutable = errors.New("msg is not routed to any queue")
+)
+
+// Acknowledger acks a single delivery back to the broker it comes from
+type Acknowledger interface {
+	Ack() error
+	// Nack tells the broker the delivery is not handled,
+	// if requeue it will be delivered again, otherwise dropped/dead-lettered
+	Nack(requeue bool) error
+}
+
+// DelayedNacker is implemented by the Acknowledger able to requeue the
+// delivery to be delivered again after the delay
+type DelayedNacker interface {
+	NackWithDelay(delay time.Duration) error
+}
+
+// Delivery is a broker neutral msg delivered by MsgQueue.Pull
+type Delivery struct {
+	Acknowledger Acknowledger
+
+	Topic   string // topic the msg is published to
+	Headers map[string]interface{}
+	Body    []byte
+
+	// Redelivered is true if the msg is delivered before but not acked
+	Redelivered bool
+	// DeliveryCount is the amount of times the msg is delivered including
+	// this time. 0 means the broker does not track it
+	DeliveryCount int
+	// Priority is the msg priority set by the publisher, higher first.
+	// 0 if the broker does not support it
+	Priority uint8
+}
+
+func (d *Delivery) Ack() error {
+	if d.Acknowledger == nil {
+		return ErrNoAcknowledger
+	}
+	return d.Acknowledger.Ack()
+}
+
+func (d *Delivery) Nack(requeue bool) error {
+	if d.Acknowledger == nil {
+		return ErrNoAcknowledger
+	}
+	return d.Acknowledger.Nack(requeue)
+}
+
+// NackWithDelay requeues the delivery to be delivered again after delay,
+// through the broker if the Acknowledger is a DelayedNacker. otherwise,
+// e.g. of the in-memory mq, the delivery is kept unacked in this process
+// and requeued after delay, while the caller does not wait
+func (d *Delivery) NackWithDelay(delay time.Duration) error {
+	if d.Acknowledger == nil {
+		return ErrNoAcknowledger
+	}
+	if nacker, ok := d.Acknowledger.(DelayedNacker); ok {
+		return nacker.NackWithDelay(delay)
+	}
+	time.AfterFunc(delay, func() { d.Acknowledger.Nack(true) })
+	return nil
+}
 
 type MsgQueue interface {
 	Pub(topic string, data []byte) error
-	Pull(topic, pullerTag string, respMsgByteChan chan *amqp.Delivery) error
-	Ack(deliveryTag uint64) error
+	Pull(topic, pullerTag string, respDeliveryChan chan *Delivery) error
+}
+
+// PriorityPublisher is implemented by the MsgQueue able to deliver higher
+// priority msgs first
+type PriorityPublisher interface {
+	PubWithPriority(topic string, data []byte, priority uint8) error
+}
+
+// Broadcaster is implemented by the MsgQueue able to deliver every msg to
+// each puller instead of one of them, for events every replica should handle.
+// a broadcast puller only gets msgs published after it pulls
+type Broadcaster interface {
+	PullBroadcast(topic string, respDeliveryChan chan *Delivery) error
 }
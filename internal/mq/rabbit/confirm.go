@@ -0,0 +1,160 @@
+package rabbit
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/mq"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+const DefaultConfirmTimeout = 5 * time.Second
+
+var (
+	ErrNacked         = errors.New("publish nacked by rabbitMQ")
+	ErrConfirmTimeout = errors.New("wait publish confirm from rabbitMQ timeout")
+)
+
+// confirmer publishes on a confirm mode channel and matches the broker's
+// confirms & returns back to each publish by it's delivery tag.
+// a returned msg's MessageId is it's delivery tag.
+type confirmer struct {
+	channel  *amqp.Channel
+	seq      uint64
+	pending  map[uint64]chan error
+	returned map[uint64]amqp.Return
+	sync.Mutex
+}
+
+func newConfirmer(channel *amqp.Channel) (*confirmer, error) {
+	if err := channel.Confirm(false); err != nil {
+		return nil, errors.Wrap(err, "failed to put channel into confirm mode")
+	}
+	c := &confirmer{
+		channel:  channel,
+		pending:  make(map[uint64]chan error),
+		returned: make(map[uint64]amqp.Return)}
+	go c.run(
+		channel.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		// unbuffered, amqp dispatches a msg's return before it's confirm,
+		// so the return is received before the confirm is sent
+		channel.NotifyReturn(make(chan amqp.Return)))
+	return c, nil
+}
+
+// run keeps draining confirms & returns, or amqp blocks the whole channel
+func (c *confirmer) run(confirms chan amqp.Confirmation, returns chan amqp.Return) {
+	for {
+		select {
+		case r, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			c.recordReturn(r)
+		case confirm, ok := <-confirms:
+			if !ok {
+				c.failAll(ErrNotConnected)
+				return
+			}
+			// select picks randomly, the returns arrived are recorded
+			// before resolving the confirm
+			for drained := false; !drained && returns != nil; {
+				select {
+				case r, ok := <-returns:
+					if !ok {
+						returns = nil
+						break
+					}
+					c.recordReturn(r)
+				default:
+					drained = true
+				}
+			}
+			c.resolve(confirm)
+		}
+	}
+}
+
+func (c *confirmer) recordReturn(r amqp.Return) {
+	tag, err := strconv.ParseUint(r.MessageId, 10, 64)
+	if err != nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.returned[tag] = r
+}
+
+func (c *confirmer) resolve(confirm amqp.Confirmation) {
+	c.Lock()
+	defer c.Unlock()
+	r, isReturned := c.returned[confirm.DeliveryTag]
+	delete(c.returned, confirm.DeliveryTag)
+	resultChan, ok := c.pending[confirm.DeliveryTag]
+	if !ok {
+		// the publish has given up waiting
+		return
+	}
+	delete(c.pending, confirm.DeliveryTag)
+
+	var err error
+	if !confirm.Ack {
+		err = ErrNacked
+	} else if isReturned {
+		err = errors.Wrapf(
+			mq.ErrUnroutable, "msg of %s returned: %d %s",
+			r.RoutingKey, r.ReplyCode, r.ReplyText)
+	}
+	resultChan <- err
+}
+
+func (c *confirmer) failAll(err error) {
+	c.Lock()
+	defer c.Unlock()
+	for tag, resultChan := range c.pending {
+		resultChan <- err
+		delete(c.pending, tag)
+	}
+	c.returned = make(map[uint64]amqp.Return)
+}
+
+// publish publishes a mandatory msg and waits for it's confirm
+func (c *confirmer) publish(
+	exchange, routingKey string, msg amqp.Publishing, timeout time.Duration,
+) error {
+	resultChan := make(chan error, 1)
+
+	// delivery tags are the publish order on the channel
+	c.Lock()
+	c.seq++
+	tag := c.seq
+	msg.MessageId = strconv.FormatUint(tag, 10)
+	err := c.channel.Publish(
+		exchange,   // exchange
+		routingKey, // routing key
+		true,       // mandatory
+		false,      // immediate
+		msg)
+	if err != nil {
+		c.seq--
+		c.Unlock()
+		return err
+	}
+	c.pending[tag] = resultChan
+	c.Unlock()
+
+	select {
+	case err := <-resultChan:
+		return err
+	case <-time.After(timeout):
+		c.Lock()
+		delete(c.pending, tag)
+		delete(c.returned, tag)
+		c.Unlock()
+		return ErrConfirmTimeout
+	}
+}
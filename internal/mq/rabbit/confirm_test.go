@@ -0,0 +1,68 @@
+package rabbit
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/fBloc/bloc-client-go/internal/mq"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+func TestConfirmerResolve(t *testing.T) {
+	c := &confirmer{
+		pending:  make(map[uint64]chan error),
+		returned: make(map[uint64]amqp.Return)}
+	results := make(map[uint64]chan error)
+	for tag := uint64(1); tag <= 4; tag++ {
+		results[tag] = make(chan error, 1)
+		c.pending[tag] = results[tag]
+	}
+	c.returned[2] = amqp.Return{MessageId: "2", RoutingKey: "not_bound", ReplyCode: 312, ReplyText: "NO_ROUTE"}
+
+	c.resolve(amqp.Confirmation{DeliveryTag: 1, Ack: true})
+	c.resolve(amqp.Confirmation{DeliveryTag: 2, Ack: true})
+	c.resolve(amqp.Confirmation{DeliveryTag: 3, Ack: false})
+	// the publish has given up waiting
+	c.resolve(amqp.Confirmation{DeliveryTag: 5, Ack: true})
+	c.failAll(ErrNotConnected)
+
+	if err := <-results[1]; err != nil {
+		t.Errorf("acked publish should suc: %v", err)
+	}
+	if err := <-results[2]; !errors.Is(err, mq.ErrUnroutable) {
+		t.Errorf("returned publish should be unroutable: %v", err)
+	}
+	if err := <-results[3]; !errors.Is(err, ErrNacked) {
+		t.Errorf("nacked publish should fail: %v", err)
+	}
+	if err := <-results[4]; !errors.Is(err, ErrNotConnected) {
+		t.Errorf("unconfirmed publish should fail when channel closed: %v", err)
+	}
+	if len(c.pending) != 0 || len(c.returned) != 0 {
+		t.Errorf("should not leak: %v, %v", c.pending, c.returned)
+	}
+}
+
+func TestConfirmerUnroutablePublish(t *testing.T) {
+	for tag := uint64(1); tag <= 100; tag++ {
+		resultChan := make(chan error, 1)
+		c := &confirmer{
+			pending:  map[uint64]chan error{tag: resultChan},
+			returned: make(map[uint64]amqp.Return)}
+		// the worst case, the return & it's confirm are both ready when run selects
+		confirms := make(chan amqp.Confirmation, 1)
+		returns := make(chan amqp.Return, 1)
+		returns <- amqp.Return{
+			MessageId: strconv.FormatUint(tag, 10), RoutingKey: "not_bound",
+			ReplyCode: 312, ReplyText: "NO_ROUTE"}
+		confirms <- amqp.Confirmation{DeliveryTag: tag, Ack: true}
+		go c.run(confirms, returns)
+
+		if err := <-resultChan; !errors.Is(err, mq.ErrUnroutable) {
+			t.Fatalf("unroutable publish %d should fail: %v", tag, err)
+		}
+		close(confirms)
+	}
+}
@@ -3,9 +3,10 @@ package rabbit
 import (
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/fBloc/bloc-client-go/internal/mq"
-	"github.com/sirius1024/go-amqp-reconnect/rabbitmq"
 
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
@@ -13,12 +14,38 @@ import (
 
 func init() {
 	var _ mq.MsgQueue = &RabbitMQ{}
+	var _ mq.PriorityPublisher = &RabbitMQ{}
+	var _ mq.Broadcaster = &RabbitMQ{}
+	var _ mq.DelayedNacker = &deliveryAcknowledger{}
 }
 
 const topicExchangeName = "bloc_topic_exchange"
 
+var (
+	ErrNotConnected = errors.New("rabbitMQ is not connected")
+	ErrClosed       = errors.New("rabbitMQ is closed")
+)
+
+// RabbitMQ keeps a connection & a channel to rabbitMQ.
+// when they drop, it reconnects with backoff, re-declares the exchange and
+// the queues, and re-consumes for every former Pull.
 type RabbitMQ struct {
-	channel *rabbitmq.Channel
+	urls             []string
+	dialConfig       amqp.Config
+	nextURLIndex     int
+	conn             *amqp.Connection
+	channel          *amqp.Channel
+	confirmer        *confirmer
+	confirmTimeout   time.Duration
+	prefetch         int
+	maxPriority      uint8
+	pullers          []*puller
+	state            State
+	stateCallbacks   []func(StateChange)
+	reconnectHistory []ReconnectRecord
+	closed           chan struct{}
+	closeOnce        sync.Once
+	sync.RWMutex
 }
 
 type RabbitConfig struct {
@@ -26,72 +53,299 @@ type RabbitConfig struct {
 	Password string
 	Host     []string
 	Vhost    string
+	// TLS makes hosts dialed by amqps://
+	TLS *TLSConfig
+	// ExternalAuth authenticates by the TLS client certificate through
+	// SASL EXTERNAL, user & password are not needed then
+	ExternalAuth bool
+	// ConfirmTimeout is how long Pub waits for the broker's confirm,
+	// <= 0 means DefaultConfirmTimeout
+	ConfirmTimeout time.Duration
+	// Prefetch is the max unacked deliveries of the channel, <= 0 means 1
+	Prefetch int
+	// MaxPriority declares queues with `x-max-priority`, 0 means no priority.
+	// an existing queue declared with another value must be deleted first
+	MaxPriority uint8
 }
 
 func (rC *RabbitConfig) IsNil() bool {
 	if rC == nil {
 		return true
 	}
-	return len(rC.Host) <= 0 ||
-		rC.User == "" ||
-		rC.Password == ""
+	if len(rC.Host) <= 0 {
+		return true
+	}
+	if rC.ExternalAuth {
+		return rC.TLS == nil
+	}
+	return rC.User == "" || rC.Password == ""
+}
+
+func (rC *RabbitConfig) amqpURLs() []string {
+	scheme := "amqp"
+	if rC.TLS != nil {
+		scheme = "amqps"
+	}
+	userInfo := fmt.Sprintf("%s:%s@", rC.User, rC.Password)
+	if rC.ExternalAuth {
+		userInfo = ""
+	}
+	urls := make([]string, 0, len(rC.Host))
+	for _, host := range rC.Host {
+		urls = append(
+			urls,
+			fmt.Sprintf("%s://%s%s/%s", scheme, userInfo, host, rC.Vhost))
+	}
+	return urls
+}
+
+type puller struct {
+	topic     string
+	queueName string
+	// broadcast puller consumes from an exclusive queue named by the broker,
+	// which is deleted once disconnected and re-declared after reconnected
+	broadcast bool
+	respChan  chan *mq.Delivery
 }
 
-func failOnError(err error, msg string) {
+// Connect dials rabbitMQ, hosts of a cluster are tried in turn.
+// stateCallbacks are registered before connecting, see OnStateChange
+func Connect(conf *RabbitConfig, stateCallbacks ...func(StateChange)) (*RabbitMQ, error) {
+	if conf.IsNil() {
+		return nil, errors.New("rabbit config lack host, user or password")
+	}
+	dialConfig, err := conf.dialConfig()
 	if err != nil {
-		log.Fatalf("%s: %s", msg, err)
+		return nil, err
+	}
+	rmq := &RabbitMQ{
+		urls:           conf.amqpURLs(),
+		dialConfig:     dialConfig,
+		confirmTimeout: conf.ConfirmTimeout,
+		prefetch:       conf.Prefetch,
+		maxPriority:    conf.MaxPriority,
+		stateCallbacks: stateCallbacks,
+		closed:         make(chan struct{})}
+	if rmq.confirmTimeout <= 0 {
+		rmq.confirmTimeout = DefaultConfirmTimeout
+	}
+	if rmq.prefetch <= 0 {
+		rmq.prefetch = 1
+	}
+	if err := rmq.connect(); err != nil {
+		return nil, err
 	}
+	return rmq, nil
 }
 
-func InitChannel(conf *RabbitConfig) *RabbitMQ {
-	var connection *rabbitmq.Connection
-	var err error
-	if len(conf.Host) > 1 { // cluster
-		conStrs := make([]string, len(conf.Host))
-		for _, i := range conf.Host {
-			conStrs = append(
-				conStrs,
-				fmt.Sprintf(
-					"amqp://%s:%s@%s/%s",
-					conf.User, conf.Password, i, conf.Vhost))
+func (rmq *RabbitMQ) isClosed() bool {
+	select {
+	case <-rmq.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (rmq *RabbitMQ) dial() (*amqp.Connection, error) {
+	var lastErr error
+	for i := 0; i < len(rmq.urls); i++ {
+		index := (rmq.nextURLIndex + i) % len(rmq.urls)
+		conn, err := amqp.DialConfig(rmq.urls[index], dialConfigFor(rmq.dialConfig))
+		if err == nil {
+			rmq.nextURLIndex = index
+			return conn, nil
+		}
+		lastErr = err
+	}
+	// start from the next host on the next dial
+	rmq.nextURLIndex = (rmq.nextURLIndex + 1) % len(rmq.urls)
+	return nil, errors.Wrap(lastErr, "failed to connect to rabbitMQ")
+}
+
+// connect establishes the connection & the channel, re-consumes for the
+// registered pullers and watches them for drops
+func (rmq *RabbitMQ) connect() error {
+	conn, err := rmq.dial()
+	if err != nil {
+		return err
+	}
+	channel, err := setupChannel(conn, rmq.prefetch)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	publisher, err := newConfirmer(channel)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	rmq.Lock()
+	pullers := make([]*puller, len(rmq.pullers))
+	copy(pullers, rmq.pullers)
+	rmq.Unlock()
+	for _, p := range pullers {
+		if err := rmq.consume(channel, p); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	rmq.Lock()
+	if rmq.isClosed() {
+		rmq.Unlock()
+		conn.Close()
+		return ErrClosed
+	}
+	// pullers added while connecting are consumed here, as they found no channel
+	for _, p := range rmq.pullers[len(pullers):] {
+		if err := rmq.consume(channel, p); err != nil {
+			rmq.Unlock()
+			conn.Close()
+			return err
 		}
-		connection, err = rabbitmq.DialCluster(conStrs)
-	} else {
-		connection, err = rabbitmq.Dial(
-			fmt.Sprintf(
-				"amqp://%s:%s@%s/%s",
-				conf.User, conf.Password, conf.Host[0], conf.Vhost))
 	}
-	failOnError(err, "Failed to connect to RabbitMQ")
+	rmq.conn = conn
+	rmq.channel = channel
+	rmq.confirmer = publisher
+	rmq.Unlock()
 
-	channel, err := connection.Channel()
-	failOnError(err, "Failed to open a channel")
+	go rmq.watch(
+		conn.NotifyClose(make(chan *amqp.Error, 1)),
+		channel.NotifyClose(make(chan *amqp.Error, 1)))
+	rmq.setState(StateConnected, nil)
+	return nil
+}
 
-	channel.Qos(1, 0, false)
-	channel.ExchangeDeclare(
+func setupChannel(conn *amqp.Connection, prefetch int) (*amqp.Channel, error) {
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open a channel")
+	}
+	if err := channel.Qos(prefetch, 0, false); err != nil {
+		channel.Close()
+		return nil, errors.Wrap(err, "failed to set qos")
+	}
+	err = channel.ExchangeDeclare(
 		topicExchangeName,
 		"topic",
 		true, false, false, false, nil)
-	return &RabbitMQ{channel: channel}
+	if err != nil {
+		channel.Close()
+		return nil, errors.Wrap(err, "failed to declare exchange")
+	}
+	return channel, nil
+}
+
+// watch waits for the connection or the channel to drop, then reconnects
+func (rmq *RabbitMQ) watch(connClosed, channelClosed chan *amqp.Error) {
+	var amqpErr *amqp.Error
+	select {
+	case amqpErr = <-connClosed:
+	case amqpErr = <-channelClosed:
+	case <-rmq.closed:
+		return
+	}
+	if rmq.isClosed() {
+		return
+	}
+
+	var cause error = ErrNotConnected
+	if amqpErr != nil {
+		cause = amqpErr
+	}
+	rmq.Lock()
+	conn := rmq.conn
+	rmq.conn, rmq.channel, rmq.confirmer = nil, nil, nil
+	rmq.Unlock()
+	// the connection is kept when only the channel dropped
+	if conn != nil {
+		conn.Close()
+	}
+
+	log.Printf("rabbitMQ disconnected: %v", cause)
+	rmq.setState(StateDisconnected, cause)
+	rmq.reconnect(cause)
+}
+
+func (rmq *RabbitMQ) reconnect(cause error) {
+	record := ReconnectRecord{DisconnectedAt: time.Now(), Cause: cause.Error()}
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-rmq.closed:
+			return
+		case <-time.After(backoff(attempt)):
+		}
+
+		err := rmq.connect()
+		if err == nil {
+			record.Attempts = attempt
+			record.ReconnectedAt = time.Now()
+			rmq.addReconnectRecord(record)
+			log.Printf(
+				"rabbitMQ reconnected after %d attempts, %s since disconnected",
+				attempt, record.ReconnectedAt.Sub(record.DisconnectedAt))
+			return
+		}
+		if errors.Is(err, ErrClosed) {
+			return
+		}
+		log.Printf("rabbitMQ reconnect attempt %d failed: %v", attempt, err)
+		rmq.setState(StateReconnecting, err)
+	}
+}
+
+// Close stops reconnecting and closes the connection
+func (rmq *RabbitMQ) Close() error {
+	var err error
+	rmq.closeOnce.Do(func() {
+		rmq.Lock()
+		close(rmq.closed)
+		conn := rmq.conn
+		rmq.conn, rmq.channel, rmq.confirmer = nil, nil, nil
+		rmq.Unlock()
+		if conn != nil {
+			err = conn.Close()
+		}
+		rmq.setState(StateClosed, nil)
+	})
+	return err
 }
 
-func (rmq *RabbitMQ) initQueueAndBindToExchange(
-	topic, queueName string,
+func (rmq *RabbitMQ) currentConfirmer() (*confirmer, error) {
+	rmq.RLock()
+	defer rmq.RUnlock()
+	if rmq.isClosed() {
+		return nil, ErrClosed
+	}
+	if rmq.confirmer == nil {
+		return nil, ErrNotConnected
+	}
+	return rmq.confirmer, nil
+}
+
+func initQueueAndBindToExchange(
+	channel *amqp.Channel, topic, queueName string, maxPriority uint8,
 ) (amqp.Queue, error) {
 	var err error
-	q, err := rmq.channel.QueueDeclare(
+	var args amqp.Table
+	if maxPriority > 0 {
+		args = amqp.Table{"x-max-priority": maxPriority}
+	}
+	q, err := channel.QueueDeclare(
 		queueName, // name
 		true,      // durable
 		false,     // delete when unused
 		false,     // exclusive
 		false,     // no-wait
-		nil,       // arguments
+		args,      // arguments
 	)
 	if err != nil {
 		return amqp.Queue{}, err
 	}
 
-	err = rmq.channel.QueueBind(
+	err = channel.QueueBind(
 		q.Name,            // queue name
 		topic,             // routing key
 		topicExchangeName, // exchange
@@ -100,34 +354,158 @@ func (rmq *RabbitMQ) initQueueAndBindToExchange(
 	return q, err
 }
 
-func (rmq *RabbitMQ) Ack(deilveryTag uint64) error {
-	return rmq.channel.Ack(deilveryTag, false)
+func initBroadcastQueue(channel *amqp.Channel, topic string) (amqp.Queue, error) {
+	q, err := channel.QueueDeclare(
+		"",    // name, generated by the broker
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	err = channel.QueueBind(q.Name, topic, topicExchangeName, false, nil)
+	return q, err
+}
+
+// deliveryAcknowledger acks the amqp delivery by it's delivery tag
+type deliveryAcknowledger struct {
+	delivery amqp.Delivery
+	rmq      *RabbitMQ
+	puller   *puller
+}
+
+func (dA *deliveryAcknowledger) Ack() error {
+	return dA.delivery.Ack(false)
+}
+
+func (dA *deliveryAcknowledger) Nack(requeue bool) error {
+	return dA.delivery.Nack(false, requeue)
+}
+
+// NackWithDelay moves the msg to the delay queue of the puller's queue,
+// where it's dead-lettered back to the queue after delay. msgs of a
+// broadcast queue, which is gone once disconnected, are requeued after
+// delay by the client
+func (dA *deliveryAcknowledger) NackWithDelay(delay time.Duration) error {
+	if dA.puller.broadcast {
+		time.AfterFunc(delay, func() { dA.Nack(true) })
+		return nil
+	}
+	if err := dA.rmq.delay(dA.puller.queueName, dA.delivery, delay); err != nil {
+		log.Printf("delay msg of queue %s failed, requeue it now: %v", dA.puller.queueName, err)
+		return dA.Nack(true)
+	}
+	return dA.Ack()
+}
+
+// delayQueue is the name & arguments of the queue delaying msgs of queue,
+// which is deleted after not used for a while
+func delayQueue(queue string, delay time.Duration) (string, amqp.Table) {
+	ttl := delay.Milliseconds()
+	if ttl <= 0 {
+		ttl = 1
+	}
+	return fmt.Sprintf("%s.delay.%d", queue, ttl), amqp.Table{
+		"x-message-ttl":             ttl,
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": queue,
+		"x-expires":                 ttl + int64(delayQueueExpires/time.Millisecond)}
+}
+
+// delayQueueExpires is how long an unused delay queue is kept after it's ttl
+const delayQueueExpires = time.Hour
+
+// delay publishes the msg to the delay queue of queue
+func (rmq *RabbitMQ) delay(queue string, d amqp.Delivery, delay time.Duration) error {
+	publisher, err := rmq.currentConfirmer()
+	if err != nil {
+		return err
+	}
+	name, args := delayQueue(queue, delay)
+	if _, err := publisher.channel.QueueDeclare(name, true, false, false, false, args); err != nil {
+		return errors.Wrap(err, "declare delay queue failed")
+	}
+	// the routing key is the queue's after dead-lettered
+	headers := make(amqp.Table, len(d.Headers)+1)
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[topicHeader] = topicOf(d)
+	return publisher.publish(
+		"", name,
+		amqp.Publishing{
+			Headers:      headers,
+			DeliveryMode: amqp.Persistent,
+			ContentType:  d.ContentType,
+			Priority:     d.Priority,
+			Body:         d.Body,
+		},
+		rmq.confirmTimeout)
+}
+
+// topicHeader keeps the topic of a delayed msg
+const topicHeader = "x-bloc-topic"
+
+func topicOf(d amqp.Delivery) string {
+	if topic, ok := d.Headers[topicHeader].(string); ok {
+		return topic
+	}
+	return d.RoutingKey
+}
+
+func (p *puller) toDelivery(rmq *RabbitMQ, d amqp.Delivery) *mq.Delivery {
+	return &mq.Delivery{
+		Acknowledger: &deliveryAcknowledger{delivery: d, rmq: rmq, puller: p},
+		Topic:        topicOf(d),
+		Headers:      d.Headers,
+		Body:         d.Body,
+		Redelivered:  d.Redelivered,
+		Priority:     d.Priority,
+	}
 }
 
+// Pub waits for the broker's confirm. it fails with mq.ErrUnroutable if no
+// queue is bound to the topic, and with ErrNotConnected while reconnecting
 func (rmq *RabbitMQ) Pub(topic string, data []byte) error {
-	err := rmq.channel.Publish(
-		topicExchangeName, // exchange
-		topic,             // routing key
-		false,             // mandatory
-		false,             // immediate
+	return rmq.PubWithPriority(topic, data, 0)
+}
+
+// PubWithPriority is Pub with the msg priority, which takes effect only if
+// the queue is declared with MaxPriority
+func (rmq *RabbitMQ) PubWithPriority(topic string, data []byte, priority uint8) error {
+	publisher, err := rmq.currentConfirmer()
+	if err != nil {
+		return err
+	}
+	return publisher.publish(
+		topicExchangeName, topic,
 		amqp.Publishing{
 			DeliveryMode: amqp.Persistent,
 			ContentType:  "text/plain",
+			Priority:     priority,
 			Body:         data,
-		})
-	return err
+		},
+		rmq.confirmTimeout)
 }
 
-func (rmq *RabbitMQ) Pull(
-	topic, pullerTag string,
-	respMsgByteChan chan *amqp.Delivery,
-) error {
-	queue, err := rmq.initQueueAndBindToExchange(topic, pullerTag)
+// consume declares the puller's queue and forwards it's deliveries
+// until the channel drops
+func (rmq *RabbitMQ) consume(channel *amqp.Channel, p *puller) error {
+	var queue amqp.Queue
+	var err error
+	if p.broadcast {
+		queue, err = initBroadcastQueue(channel, p.topic)
+	} else {
+		queue, err = initQueueAndBindToExchange(channel, p.topic, p.queueName, rmq.maxPriority)
+	}
 	if err != nil {
 		return errors.Wrap(err, "initial queue & bind to exchange failed")
 	}
 
-	msgs, err := rmq.channel.Consume(
+	msgs, err := channel.Consume(
 		queue.Name, // queue
 		"",         // consumer
 		false,      // auto-ack
@@ -142,9 +520,43 @@ func (rmq *RabbitMQ) Pull(
 
 	go func() {
 		for d := range msgs {
-			respMsgByteChan <- &d
+			p.respChan <- p.toDelivery(rmq, d)
 		}
 	}()
+	return nil
+}
+
+// Pull keeps consuming across reconnects, a Pull while reconnecting
+// starts consuming once reconnected
+func (rmq *RabbitMQ) Pull(
+	topic, pullerTag string,
+	respDeliveryChan chan *mq.Delivery,
+) error {
+	return rmq.addPuller(
+		&puller{topic: topic, queueName: pullerTag, respChan: respDeliveryChan})
+}
+
+// PullBroadcast consumes from an exclusive queue of it's own
+func (rmq *RabbitMQ) PullBroadcast(topic string, respDeliveryChan chan *mq.Delivery) error {
+	return rmq.addPuller(
+		&puller{topic: topic, broadcast: true, respChan: respDeliveryChan})
+}
 
+func (rmq *RabbitMQ) addPuller(p *puller) error {
+	// locked through consuming so a drop in between will not miss the puller
+	rmq.Lock()
+	defer rmq.Unlock()
+	if rmq.isClosed() {
+		return ErrClosed
+	}
+	rmq.pullers = append(rmq.pullers, p)
+	if rmq.channel == nil {
+		// consumed by the reconnect
+		return nil
+	}
+	if err := rmq.consume(rmq.channel, p); err != nil {
+		rmq.pullers = rmq.pullers[:len(rmq.pullers)-1]
+		return err
+	}
 	return nil
 }
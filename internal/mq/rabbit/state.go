@@ -0,0 +1,108 @@
+package rabbit
+
+import (
+	"time"
+)
+
+type State int
+
+const (
+	StateConnected State = iota + 1
+	StateDisconnected
+	StateReconnecting
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// StateChange is passed to the state callbacks when the connection state changes.
+// Err is the cause of a disconnection or the last failed reconnect attempt
+type StateChange struct {
+	State State
+	Err   error
+	At    time.Time
+}
+
+// ReconnectRecord is one successful recovery of a dropped connection
+type ReconnectRecord struct {
+	DisconnectedAt time.Time
+	ReconnectedAt  time.Time
+	// Attempts is how many dials it takes to reconnect
+	Attempts int
+	Cause    string
+}
+
+const (
+	reconnectMinBackoff = 500 * time.Millisecond
+	reconnectMaxBackoff = 30 * time.Second
+	maxReconnectHistory = 100
+)
+
+// backoff doubles from reconnectMinBackoff with each attempt up to reconnectMaxBackoff
+func backoff(attempt int) time.Duration {
+	d := reconnectMinBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= reconnectMaxBackoff {
+			return reconnectMaxBackoff
+		}
+	}
+	return d
+}
+
+// OnStateChange registers a callback called on every connection state change.
+// callbacks are called synchronously, so they should not block
+func (rmq *RabbitMQ) OnStateChange(callback func(StateChange)) {
+	rmq.Lock()
+	defer rmq.Unlock()
+	rmq.stateCallbacks = append(rmq.stateCallbacks, callback)
+}
+
+func (rmq *RabbitMQ) State() State {
+	rmq.RLock()
+	defer rmq.RUnlock()
+	return rmq.state
+}
+
+// ReconnectHistory returns the latest reconnects, oldest first
+func (rmq *RabbitMQ) ReconnectHistory() []ReconnectRecord {
+	rmq.RLock()
+	defer rmq.RUnlock()
+	resp := make([]ReconnectRecord, len(rmq.reconnectHistory))
+	copy(resp, rmq.reconnectHistory)
+	return resp
+}
+
+func (rmq *RabbitMQ) setState(state State, err error) {
+	rmq.Lock()
+	rmq.state = state
+	callbacks := make([]func(StateChange), len(rmq.stateCallbacks))
+	copy(callbacks, rmq.stateCallbacks)
+	rmq.Unlock()
+
+	change := StateChange{State: state, Err: err, At: time.Now()}
+	for _, callback := range callbacks {
+		callback(change)
+	}
+}
+
+func (rmq *RabbitMQ) addReconnectRecord(record ReconnectRecord) {
+	rmq.Lock()
+	defer rmq.Unlock()
+	rmq.reconnectHistory = append(rmq.reconnectHistory, record)
+	if len(rmq.reconnectHistory) > maxReconnectHistory {
+		rmq.reconnectHistory = rmq.reconnectHistory[len(rmq.reconnectHistory)-maxReconnectHistory:]
+	}
+}
@@ -0,0 +1,55 @@
+package rabbit
+
+import (
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/tlsconf"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+const (
+	heartbeat = 10 * time.Second
+	locale    = "en_US"
+)
+
+// TLSConfig makes the connection dialed by amqps://.
+// the client certificate is needed by EXTERNAL auth
+type TLSConfig = tlsconf.Config
+
+// externalAuth is the SASL EXTERNAL mechanism, the broker
+// authenticates by the client certificate
+type externalAuth struct{}
+
+func (*externalAuth) Mechanism() string {
+	return "EXTERNAL"
+}
+
+func (*externalAuth) Response() string {
+	return ""
+}
+
+func (rC *RabbitConfig) dialConfig() (amqp.Config, error) {
+	conf := amqp.Config{Heartbeat: heartbeat, Locale: locale}
+	if rC.TLS != nil {
+		tlsConf, err := rC.TLS.TLSClientConfig()
+		if err != nil {
+			return conf, errors.Wrap(err, "set up rabbit TLS failed")
+		}
+		conf.TLSClientConfig = tlsConf
+	}
+	if rC.ExternalAuth {
+		conf.SASL = []amqp.Authentication{&externalAuth{}}
+	}
+	return conf, nil
+}
+
+// dialConfigFor copies the tls config for each dial, as amqp fills
+// the ServerName by the dialed host if it's empty
+func dialConfigFor(conf amqp.Config) amqp.Config {
+	if conf.TLSClientConfig != nil {
+		conf.TLSClientConfig = conf.TLSClientConfig.Clone()
+	}
+	return conf
+}
@@ -0,0 +1,463 @@
+package redis_stream
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/mq"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	var _ mq.MsgQueue = &RedisStreamMQ{}
+	var _ mq.Broadcaster = &RedisStreamMQ{}
+}
+
+const (
+	DefaultStreamPrefix = "bloc"
+	// DefaultClaimMinIdle is long as a function run is acked after it finished,
+	// a msg being handled is kept claimed anyway
+	DefaultClaimMinIdle = 30 * time.Minute
+	DefaultMaxLen       = 100000
+	bodyField           = "body"
+	readBlock           = 2 * time.Second
+	retryInterval       = time.Second
+)
+
+var consumerSeq int64
+
+type RedisStreamConfig struct {
+	Addr     string
+	Username string
+	Password string
+	DB       int
+	// StreamPrefix is prepended to every topic to get it's stream key
+	StreamPrefix string
+	// ClaimMinIdle is how long a msg stays unacked before it's reclaimed
+	// from the crashed consumer and redelivered. the idle time of a msg
+	// not acked yet is reset every ClaimMinIdle/3
+	ClaimMinIdle time.Duration
+	// MaxDeliver is the max times a msg is delivered before given up,
+	// <= 0 means no limit
+	MaxDeliver int
+	// MaxLen approximately caps each stream's length as acked msgs are
+	// not deleted, <= 0 means DefaultMaxLen
+	MaxLen int64
+	// MaxUnacked is the max msgs a Pull holds unacked, the next is read
+	// after one of them is acked or nacked. <= 0 means bounded only by
+	// the receiver taking them
+	MaxUnacked int
+}
+
+func (rC *RedisStreamConfig) IsNil() bool {
+	if rC == nil {
+		return true
+	}
+	return rC.Addr == ""
+}
+
+func (rC *RedisStreamConfig) withDefault() RedisStreamConfig {
+	conf := *rC
+	if conf.StreamPrefix == "" {
+		conf.StreamPrefix = DefaultStreamPrefix
+	}
+	if conf.ClaimMinIdle <= 0 {
+		conf.ClaimMinIdle = DefaultClaimMinIdle
+	}
+	if conf.MaxLen <= 0 {
+		conf.MaxLen = DefaultMaxLen
+	}
+	return conf
+}
+
+// RedisStreamMQ implements mq.MsgQueue on redis streams(redis >= 6.2).
+// a topic maps to the stream `StreamPrefix:topic`, and a puller tag maps to
+// a consumer group of it, so replicas using the same puller tag share the
+// msgs just like a rabbit queue.
+type RedisStreamMQ struct {
+	conf   RedisStreamConfig
+	client *redis.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func Connect(conf *RedisStreamConfig) (*RedisStreamMQ, error) {
+	if conf.IsNil() {
+		return nil, errors.New("redis stream config lack addr")
+	}
+	c := conf.withDefault()
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     c.Addr,
+		Username: c.Username,
+		Password: c.Password,
+		DB:       c.DB})
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := client.Ping(ctx).Err(); err != nil {
+		cancel()
+		client.Close()
+		return nil, errors.Wrap(err, "connect to redis failed")
+	}
+	return &RedisStreamMQ{conf: c, client: client, ctx: ctx, cancel: cancel}, nil
+}
+
+func (rMQ *RedisStreamMQ) stream(topic string) string {
+	return rMQ.conf.StreamPrefix + ":" + topic
+}
+
+// consumerName is unique for every Pull, so that a restarted client
+// will not be mistaken as the crashed one
+func consumerName(pullerTag string) string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf(
+		"%s-%s-%d-%d",
+		pullerTag, hostname, os.Getpid(), atomic.AddInt64(&consumerSeq, 1))
+}
+
+func (rMQ *RedisStreamMQ) Pub(topic string, data []byte) error {
+	return rMQ.client.XAdd(rMQ.ctx, &redis.XAddArgs{
+		Stream: rMQ.stream(topic),
+		MaxLen: rMQ.conf.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{bodyField: data},
+	}).Err()
+}
+
+func (rMQ *RedisStreamMQ) ensureGroup(stream, group string) error {
+	// read from the very beginning so msgs pubed before the first pull are kept
+	err := rMQ.client.XGroupCreateMkStream(rMQ.ctx, stream, group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return errors.Wrap(err, "create consumer group failed")
+	}
+	return nil
+}
+
+func (rMQ *RedisStreamMQ) Pull(
+	topic, pullerTag string,
+	respDeliveryChan chan *mq.Delivery,
+) error {
+	p := &puller{
+		mq:       rMQ,
+		topic:    topic,
+		stream:   rMQ.stream(topic),
+		group:    pullerTag,
+		consumer: consumerName(pullerTag),
+		respChan: respDeliveryChan}
+	if rMQ.conf.MaxUnacked > 0 {
+		p.unacked = make(chan struct{}, rMQ.conf.MaxUnacked)
+	}
+	if err := rMQ.ensureGroup(p.stream, p.group); err != nil {
+		return err
+	}
+	go p.run()
+	return nil
+}
+
+// PullBroadcast reads the stream without a consumer group,
+// so there is nothing to ack
+func (rMQ *RedisStreamMQ) PullBroadcast(topic string, respDeliveryChan chan *mq.Delivery) error {
+	stream := rMQ.stream(topic)
+	// start from the last msg now, so msgs added between reads are not missed
+	lastID := "0-0"
+	latest, err := rMQ.client.XRevRangeN(rMQ.ctx, stream, "+", "-", 1).Result()
+	if err != nil {
+		return errors.Wrap(err, "get the last msg of stream failed")
+	}
+	if len(latest) > 0 {
+		lastID = latest[0].ID
+	}
+
+	go func() {
+		for rMQ.ctx.Err() == nil {
+			streams, err := rMQ.client.XRead(rMQ.ctx, &redis.XReadArgs{
+				Streams: []string{stream, lastID},
+				Block:   readBlock,
+			}).Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if err != nil {
+				time.Sleep(retryInterval)
+				continue
+			}
+			for _, s := range streams {
+				for _, msg := range s.Messages {
+					lastID = msg.ID
+					d := toDelivery(topic, &msg, 1)
+					d.Acknowledger = noopAcknowledger{}
+					select {
+					case respDeliveryChan <- d:
+					case <-rMQ.ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops pulling, unacked msgs are reclaimed by other consumers after ClaimMinIdle
+func (rMQ *RedisStreamMQ) Close() error {
+	rMQ.cancel()
+	return rMQ.client.Close()
+}
+
+type puller struct {
+	mq       *RedisStreamMQ
+	topic    string
+	stream   string
+	group    string
+	consumer string
+	respChan chan *mq.Delivery
+	// unacked bounds the msgs held unacked, nil if not bounded
+	unacked chan struct{}
+}
+
+func (p *puller) stopped() bool {
+	return p.mq.ctx.Err() != nil
+}
+
+// acquire blocks until less than MaxUnacked msgs are unacked,
+// false if stopped
+func (p *puller) acquire() bool {
+	if p.unacked == nil {
+		return true
+	}
+	select {
+	case p.unacked <- struct{}{}:
+		return true
+	case <-p.mq.ctx.Done():
+		return false
+	}
+}
+
+func (p *puller) release() {
+	if p.unacked != nil {
+		<-p.unacked
+	}
+}
+
+func (p *puller) run() {
+	for p.acquire() {
+		// msgs of crashed consumers first, then new ones,
+		// the next is read after the last one is taken by the receiver
+		msg, deliveryCount, err := p.reclaim()
+		if err == nil && msg == nil {
+			msg, err = p.readNew()
+			deliveryCount = 1
+		}
+		if err != nil {
+			p.release()
+			if p.stopped() {
+				return
+			}
+			time.Sleep(retryInterval)
+			continue
+		}
+		if msg == nil {
+			p.release()
+			continue
+		}
+
+		if p.mq.conf.MaxDeliver > 0 && deliveryCount > p.mq.conf.MaxDeliver {
+			// given up, just like a rejected rabbit msg without requeue
+			p.mq.client.XAck(p.mq.ctx, p.stream, p.group, msg.ID)
+			p.release()
+			continue
+		}
+		select {
+		case p.respChan <- p.toDelivery(msg, deliveryCount):
+		case <-p.mq.ctx.Done():
+			return
+		}
+	}
+}
+
+// reclaim claims a msg idled too long in other(crashed) consumer's pending list
+func (p *puller) reclaim() (*redis.XMessage, int, error) {
+	msgs, _, err := p.mq.client.XAutoClaim(p.mq.ctx, &redis.XAutoClaimArgs{
+		Stream:   p.stream,
+		Group:    p.group,
+		Consumer: p.consumer,
+		MinIdle:  p.mq.conf.ClaimMinIdle,
+		Start:    "0-0",
+		Count:    1,
+	}).Result()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "xautoclaim failed")
+	}
+	if len(msgs) == 0 {
+		return nil, 0, nil
+	}
+
+	msg := msgs[0]
+	pendings, err := p.mq.client.XPendingExt(p.mq.ctx, &redis.XPendingExtArgs{
+		Stream: p.stream,
+		Group:  p.group,
+		Start:  msg.ID,
+		End:    msg.ID,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "xpending failed")
+	}
+	deliveryCount := 2
+	if len(pendings) > 0 {
+		deliveryCount = int(pendings[0].RetryCount)
+	}
+	return &msg, deliveryCount, nil
+}
+
+func (p *puller) readNew() (*redis.XMessage, error) {
+	streams, err := p.mq.client.XReadGroup(p.mq.ctx, &redis.XReadGroupArgs{
+		Group:    p.group,
+		Consumer: p.consumer,
+		Streams:  []string{p.stream, ">"},
+		Count:    1,
+		Block:    readBlock,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "xreadgroup failed")
+	}
+	for _, stream := range streams {
+		if len(stream.Messages) > 0 {
+			return &stream.Messages[0], nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *puller) toDelivery(msg *redis.XMessage, deliveryCount int) *mq.Delivery {
+	d := toDelivery(p.topic, msg, deliveryCount)
+	mA := &msgAcknowledger{
+		puller: p, id: msg.ID, deliveryCount: deliveryCount,
+		done: make(chan struct{})}
+	go mA.keepClaimed(p.mq.conf.ClaimMinIdle / 3)
+	d.Acknowledger = mA
+	return d
+}
+
+func toDelivery(topic string, msg *redis.XMessage, deliveryCount int) *mq.Delivery {
+	d := &mq.Delivery{
+		Topic:         topic,
+		Redelivered:   deliveryCount > 1,
+		DeliveryCount: deliveryCount,
+	}
+	for field, value := range msg.Values {
+		if field == bodyField {
+			if body, ok := value.(string); ok {
+				d.Body = []byte(body)
+			}
+			continue
+		}
+		if d.Headers == nil {
+			d.Headers = make(map[string]interface{})
+		}
+		d.Headers[field] = value
+	}
+	return d
+}
+
+// msgAcknowledger keeps the msg claimed until it's acked or nacked,
+// or other consumers reclaim it after ClaimMinIdle while still being handled
+type msgAcknowledger struct {
+	puller        *puller
+	id            string
+	deliveryCount int
+	settled       bool
+	done          chan struct{}
+	sync.Mutex
+}
+
+// keepClaimed resets the idle time of the msg by claiming it again
+func (mA *msgAcknowledger) keepClaimed(interval time.Duration) {
+	p := mA.puller
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mA.Lock()
+			if !mA.settled {
+				// JUSTID does not increase the delivery count
+				p.mq.client.Do(
+					p.mq.ctx,
+					"XCLAIM", p.stream, p.group, p.consumer, 0, mA.id, "JUSTID")
+			}
+			mA.Unlock()
+		case <-mA.done:
+			return
+		case <-p.mq.ctx.Done():
+			return
+		}
+	}
+}
+
+// settle stops keeping the msg claimed & acks it by ack
+func (mA *msgAcknowledger) settle(ack func() error) error {
+	mA.Lock()
+	defer mA.Unlock()
+	if !mA.settled {
+		mA.settled = true
+		close(mA.done)
+		mA.puller.release()
+	}
+	return ack()
+}
+
+func (mA *msgAcknowledger) Ack() error {
+	return mA.settle(mA.ack)
+}
+
+func (mA *msgAcknowledger) ack() error {
+	p := mA.puller
+	return p.mq.client.XAck(context.Background(), p.stream, p.group, mA.id).Err()
+}
+
+// Nack with requeue marks the msg idled long enough so it is reclaimed
+// right away, without requeue the msg is acked to be given up
+func (mA *msgAcknowledger) Nack(requeue bool) error {
+	if !requeue {
+		return mA.settle(mA.ack)
+	}
+	return mA.NackWithDelay(0)
+}
+
+// NackWithDelay marks the msg idled so it is reclaimed after delay
+func (mA *msgAcknowledger) NackWithDelay(delay time.Duration) error {
+	p := mA.puller
+	idle := p.mq.conf.ClaimMinIdle - delay
+	if idle < 0 {
+		idle = 0
+	}
+	return mA.settle(func() error {
+		return p.mq.client.Do(
+			context.Background(),
+			"XCLAIM", p.stream, p.group, p.consumer, 0, mA.id,
+			"IDLE", idle.Milliseconds(),
+			"RETRYCOUNT", mA.deliveryCount,
+			"JUSTID",
+		).Err()
+	})
+}
+
+type noopAcknowledger struct{}
+
+func (noopAcknowledger) Ack() error {
+	return nil
+}
+
+func (noopAcknowledger) Nack(requeue bool) error {
+	return nil
+}
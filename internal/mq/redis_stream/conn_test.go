@@ -0,0 +1,226 @@
+package redis_stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/mq"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+const topic = "function_client_run_consumer.tryout"
+
+func connect(t *testing.T, conf *RedisStreamConfig) *RedisStreamMQ {
+	t.Helper()
+	rMQ, err := Connect(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rMQ.Close() })
+	return rMQ
+}
+
+func receive(t *testing.T, deliveryChan chan *mq.Delivery) *mq.Delivery {
+	t.Helper()
+	select {
+	case d := <-deliveryChan:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("receive delivery timeout")
+	}
+	return nil
+}
+
+func pendingAmount(t *testing.T, rMQ *RedisStreamMQ) int64 {
+	t.Helper()
+	pending, err := rMQ.client.XPending(rMQ.ctx, rMQ.stream(topic), "tryout").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pending.Count
+}
+
+func TestPubPullAck(t *testing.T) {
+	s := miniredis.RunT(t)
+	rMQ := connect(t, &RedisStreamConfig{Addr: s.Addr()})
+
+	// pubed before pulled, should be kept by the consumer group
+	if err := rMQ.Pub(topic, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	deliveryChan := make(chan *mq.Delivery)
+	if err := rMQ.Pull(topic, "tryout", deliveryChan); err != nil {
+		t.Fatal(err)
+	}
+	rMQ.Pub(topic, []byte("second"))
+
+	for _, body := range []string{"first", "second"} {
+		d := receive(t, deliveryChan)
+		if string(d.Body) != body || d.Topic != topic || d.Redelivered || d.DeliveryCount != 1 {
+			t.Fatalf("unexpected delivery: %+v", d)
+		}
+		if err := d.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if amount := pendingAmount(t, rMQ); amount != 0 {
+		t.Fatalf("acked msgs should not be pending, get %d", amount)
+	}
+}
+
+func TestReclaimFromCrashedConsumer(t *testing.T) {
+	s := miniredis.RunT(t)
+	conf := &RedisStreamConfig{Addr: s.Addr(), ClaimMinIdle: 100 * time.Millisecond}
+
+	crashed := connect(t, conf)
+	crashedChan := make(chan *mq.Delivery)
+	crashed.Pull(topic, "tryout", crashedChan)
+	crashed.Pub(topic, []byte("run"))
+	receive(t, crashedChan)
+	crashed.Close()
+
+	rMQ := connect(t, conf)
+	deliveryChan := make(chan *mq.Delivery)
+	if err := rMQ.Pull(topic, "tryout", deliveryChan); err != nil {
+		t.Fatal(err)
+	}
+	d := receive(t, deliveryChan)
+	if string(d.Body) != "run" || !d.Redelivered || d.DeliveryCount != 2 {
+		t.Fatalf("msg of crashed consumer should be redelivered: %+v", d)
+	}
+	d.Ack()
+	if amount := pendingAmount(t, rMQ); amount != 0 {
+		t.Fatalf("acked msgs should not be pending, get %d", amount)
+	}
+}
+
+func TestNackAndMaxDeliver(t *testing.T) {
+	s := miniredis.RunT(t)
+	rMQ := connect(t, &RedisStreamConfig{Addr: s.Addr(), MaxDeliver: 2})
+
+	deliveryChan := make(chan *mq.Delivery)
+	rMQ.Pull(topic, "tryout", deliveryChan)
+	rMQ.Pub(topic, []byte("run"))
+
+	first := receive(t, deliveryChan)
+	if err := first.Nack(true); err != nil {
+		t.Fatal(err)
+	}
+	second := receive(t, deliveryChan)
+	if string(second.Body) != "run" || !second.Redelivered || second.DeliveryCount != 2 {
+		t.Fatalf("nacked msg should be redelivered: %+v", second)
+	}
+	// reached max deliver, should be given up
+	second.Nack(true)
+	select {
+	case d := <-deliveryChan:
+		t.Fatalf("msg exceeds max deliver should not be redelivered: %+v", d)
+	case <-time.After(3 * time.Second):
+	}
+	if amount := pendingAmount(t, rMQ); amount != 0 {
+		t.Fatalf("given up msg should not be pending, get %d", amount)
+	}
+}
+
+func TestPullBroadcast(t *testing.T) {
+	s := miniredis.RunT(t)
+	replicas := []*RedisStreamMQ{
+		connect(t, &RedisStreamConfig{Addr: s.Addr()}),
+		connect(t, &RedisStreamConfig{Addr: s.Addr()}),
+	}
+
+	// pubed before pulled, should not be broadcast
+	if err := replicas[0].Pub(topic, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	deliveryChans := make([]chan *mq.Delivery, len(replicas))
+	for i, replica := range replicas {
+		deliveryChans[i] = make(chan *mq.Delivery)
+		if err := replica.PullBroadcast(topic, deliveryChans[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := replicas[0].Pub(topic, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, deliveryChan := range deliveryChans {
+		d := receive(t, deliveryChan)
+		if string(d.Body) != "new" || d.Topic != topic {
+			t.Fatalf("unexpected delivery: %+v", d)
+		}
+		if err := d.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLongRunKeptClaimed(t *testing.T) {
+	s := miniredis.RunT(t)
+	conf := &RedisStreamConfig{Addr: s.Addr(), ClaimMinIdle: 300 * time.Millisecond}
+
+	owner := connect(t, conf)
+	ownerChan := make(chan *mq.Delivery)
+	owner.Pull(topic, "tryout", ownerChan)
+	owner.Pub(topic, []byte("run"))
+	d := receive(t, ownerChan)
+
+	other := connect(t, conf)
+	otherChan := make(chan *mq.Delivery)
+	if err := other.Pull(topic, "tryout", otherChan); err != nil {
+		t.Fatal(err)
+	}
+	// runs longer than ClaimMinIdle, and the read block of the other one
+	select {
+	case reclaimed := <-otherChan:
+		t.Fatalf("msg being handled should not be reclaimed: %+v", reclaimed)
+	case <-time.After(readBlock + time.Second):
+	}
+	if err := d.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if amount := pendingAmount(t, owner); amount != 0 {
+		t.Fatalf("acked msgs should not be pending, get %d", amount)
+	}
+}
+
+func TestMaxUnacked(t *testing.T) {
+	s := miniredis.RunT(t)
+	rMQ := connect(t, &RedisStreamConfig{Addr: s.Addr(), MaxUnacked: 1})
+
+	deliveryChan := make(chan *mq.Delivery, 2)
+	rMQ.Pull(topic, "tryout", deliveryChan)
+	rMQ.Pub(topic, []byte("first"))
+	rMQ.Pub(topic, []byte("second"))
+
+	first := receive(t, deliveryChan)
+	select {
+	case d := <-deliveryChan:
+		t.Fatalf("should not read more than MaxUnacked msgs: %+v", d)
+	case <-time.After(300 * time.Millisecond):
+	}
+	first.Ack()
+	if d := receive(t, deliveryChan); string(d.Body) != "second" {
+		t.Fatalf("unexpected delivery: %+v", d)
+	}
+}
+
+func TestNackWithDelay(t *testing.T) {
+	s := miniredis.RunT(t)
+	rMQ := connect(t, &RedisStreamConfig{Addr: s.Addr(), ClaimMinIdle: time.Minute})
+
+	deliveryChan := make(chan *mq.Delivery)
+	rMQ.Pull(topic, "tryout", deliveryChan)
+	rMQ.Pub(topic, []byte("run"))
+
+	nackedAt := time.Now()
+	if err := receive(t, deliveryChan).NackWithDelay(500 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	d := receive(t, deliveryChan)
+	if time.Since(nackedAt) < 500*time.Millisecond || !d.Redelivered {
+		t.Fatalf("msg should be redelivered after the delay, redelivered in %s", time.Since(nackedAt))
+	}
+	d.Ack()
+}
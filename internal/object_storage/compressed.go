@@ -0,0 +1,74 @@
+package object_storage
+
+import (
+	"io"
+
+	"github.com/fBloc/bloc-client-go/internal/compress"
+)
+
+func init() {
+	var _ ObjectStorage = &compressedObjectStorage{}
+}
+
+// compressedObjectStorage compresses data whose size reaches threshold before
+// storing and marks the encoding in the object's Meta.
+// Reads decompress by the marker, so objects stored as is are also readable.
+type compressedObjectStorage struct {
+	ObjectStorage
+	encoding  compress.Encoding
+	threshold int
+}
+
+func NewCompressed(
+	objectStorage ObjectStorage,
+	encoding compress.Encoding, threshold int,
+) ObjectStorage {
+	return &compressedObjectStorage{
+		ObjectStorage: objectStorage,
+		encoding:      encoding,
+		threshold:     threshold}
+}
+
+func (cOS *compressedObjectStorage) Set(key string, data []byte) error {
+	return cOS.SetWithMeta(key, data, Meta{})
+}
+
+func (cOS *compressedObjectStorage) SetWithMeta(
+	key string, data []byte, meta Meta,
+) error {
+	if meta.ContentEncoding != "" { // already encoded by caller
+		return cOS.ObjectStorage.SetWithMeta(key, data, meta)
+	}
+	encoded, encoding, err := compress.EncodeIfLarge(cOS.encoding, cOS.threshold, data)
+	if err != nil {
+		return err
+	}
+	meta.ContentEncoding = string(encoding)
+	return cOS.ObjectStorage.SetWithMeta(key, encoded, meta)
+}
+
+func (cOS *compressedObjectStorage) Get(key string) ([]byte, error) {
+	data, _, err := cOS.GetWithMeta(key)
+	return data, err
+}
+
+func (cOS *compressedObjectStorage) GetWithMeta(key string) ([]byte, Meta, error) {
+	data, meta, err := cOS.ObjectStorage.GetWithMeta(key)
+	if err != nil {
+		return data, meta, err
+	}
+	data, err = compress.Decode(compress.Encoding(meta.ContentEncoding), data)
+	if err != nil {
+		return nil, meta, err
+	}
+	meta.ContentEncoding = ""
+	return data, meta, nil
+}
+
+// SetFromReader stores the stream as is, streams are mostly files
+// which are already compressed by their own format
+func (cOS *compressedObjectStorage) SetFromReader(
+	key string, reader io.Reader, meta Meta,
+) (int64, error) {
+	return cOS.ObjectStorage.SetFromReader(key, reader, meta)
+}
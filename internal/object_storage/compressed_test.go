@@ -0,0 +1,46 @@
+package object_storage_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fBloc/bloc-client-go/internal/compress"
+	"github.com/fBloc/bloc-client-go/internal/object_storage"
+	"github.com/fBloc/bloc-client-go/internal/object_storage/memory"
+)
+
+func TestCompressedObjectStorage(t *testing.T) {
+	raw := memory.New()
+	compressed := object_storage.NewCompressed(raw, compress.Zstd, 100)
+
+	small := []byte("small")
+	large := bytes.Repeat([]byte("large"), 100)
+	for key, data := range map[string][]byte{"small": small, "large": large} {
+		if err := compressed.Set(key, data); err != nil {
+			t.Fatal(err)
+		}
+		got, err := compressed.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: read data not match written", key)
+		}
+	}
+
+	_, meta, _ := raw.GetWithMeta("small")
+	if meta.ContentEncoding != "" {
+		t.Errorf("data under threshold should be stored as is")
+	}
+	stored, meta, _ := raw.GetWithMeta("large")
+	if meta.ContentEncoding != string(compress.Zstd) || len(stored) >= len(large) {
+		t.Errorf("data over threshold should be stored compressed with marker")
+	}
+
+	// data stored by others without compression is also readable
+	raw.Set("plain", large)
+	got, err := compressed.Get("plain")
+	if err != nil || !bytes.Equal(got, large) {
+		t.Errorf("plain data should be read as is")
+	}
+}
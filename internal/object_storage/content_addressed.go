@@ -0,0 +1,32 @@
+package object_storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const contentAddressedKeyPrefix = "sha256/"
+
+// ContentAddressedKey derives the object key from the hash of the content,
+// so same content always maps to the same key
+func ContentAddressedKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return contentAddressedKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// SetContentAddressed stores data under it's content addressed key.
+// the upload is skipped when the object already exists,
+// uploaded reports whether data is really uploaded.
+func SetContentAddressed(
+	objectStorage ObjectStorage, data []byte,
+) (key string, uploaded bool, err error) {
+	key = ContentAddressedKey(data)
+	exists, err := objectStorage.Exists(key)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		return key, false, nil
+	}
+	return key, true, objectStorage.Set(key, data)
+}
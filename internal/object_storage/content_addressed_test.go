@@ -0,0 +1,43 @@
+package object_storage_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fBloc/bloc-client-go/internal/compress"
+	"github.com/fBloc/bloc-client-go/internal/object_storage"
+	"github.com/fBloc/bloc-client-go/internal/object_storage/memory"
+)
+
+func TestSetContentAddressed(t *testing.T) {
+	raw := memory.New()
+	// content address should be derived before compress
+	objectStorage := object_storage.NewCompressed(raw, compress.Gzip, 1)
+
+	table := bytes.Repeat([]byte("reference table row\n"), 100)
+	key, uploaded, err := object_storage.SetContentAddressed(objectStorage, table)
+	if err != nil || !uploaded {
+		t.Fatalf("first set should upload: %v", err)
+	}
+	if key != object_storage.ContentAddressedKey(table) {
+		t.Errorf("key should be derived from content: %s", key)
+	}
+
+	sameKey, uploaded, err := object_storage.SetContentAddressed(objectStorage, table)
+	if err != nil || uploaded || sameKey != key {
+		t.Fatalf("same content should share the key without upload")
+	}
+
+	otherKey, uploaded, _ := object_storage.SetContentAddressed(objectStorage, []byte("other"))
+	if !uploaded || otherKey == key {
+		t.Fatalf("different content should get different key")
+	}
+
+	got, err := objectStorage.Get(key)
+	if err != nil || !bytes.Equal(got, table) {
+		t.Errorf("get by content addressed key should return the content")
+	}
+	if len(raw.Keys()) != 2 {
+		t.Errorf("should only keep 2 blobs, get %d", len(raw.Keys()))
+	}
+}
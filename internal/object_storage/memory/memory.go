@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/fBloc/bloc-client-go/internal/object_storage"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	var _ object_storage.ObjectStorage = &ObjectStorageMemoryRepository{}
+}
+
+var ErrKeyNotExist = errors.New("key not exist")
+
+// ObjectStorageMemoryRepository keeps objects in process memory.
+// It is meant for tests which should not rely on a live minio.
+type ObjectStorageMemoryRepository struct {
+	objects map[string]*object
+	sync.RWMutex
+}
+
+type object struct {
+	data []byte
+	meta object_storage.Meta
+}
+
+func New() *ObjectStorageMemoryRepository {
+	return &ObjectStorageMemoryRepository{
+		objects: make(map[string]*object)}
+}
+
+func (oSMR *ObjectStorageMemoryRepository) Set(key string, byteData []byte) error {
+	return oSMR.SetWithMeta(key, byteData, object_storage.Meta{})
+}
+
+func (oSMR *ObjectStorageMemoryRepository) SetWithMeta(
+	key string, byteData []byte, meta object_storage.Meta,
+) error {
+	data := make([]byte, len(byteData))
+	copy(data, byteData)
+
+	oSMR.Lock()
+	defer oSMR.Unlock()
+	oSMR.objects[key] = &object{data: data, meta: meta}
+	return nil
+}
+
+func (oSMR *ObjectStorageMemoryRepository) Get(key string) ([]byte, error) {
+	data, _, err := oSMR.GetWithMeta(key)
+	return data, err
+}
+
+func (oSMR *ObjectStorageMemoryRepository) Exists(key string) (bool, error) {
+	oSMR.RLock()
+	defer oSMR.RUnlock()
+	_, ok := oSMR.objects[key]
+	return ok, nil
+}
+
+func (oSMR *ObjectStorageMemoryRepository) GetWithMeta(
+	key string,
+) ([]byte, object_storage.Meta, error) {
+	oSMR.RLock()
+	defer oSMR.RUnlock()
+	obj, ok := oSMR.objects[key]
+	if !ok {
+		return []byte{}, object_storage.Meta{}, errors.Wrapf(ErrKeyNotExist, "key %s", key)
+	}
+	resp := make([]byte, len(obj.data))
+	copy(resp, obj.data)
+	return resp, obj.meta, nil
+}
+
+func (oSMR *ObjectStorageMemoryRepository) SetFromReader(
+	key string, reader io.Reader, meta object_storage.Meta,
+) (int64, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data)), oSMR.SetWithMeta(key, data, meta)
+}
+
+// Keys returns all stored keys
+func (oSMR *ObjectStorageMemoryRepository) Keys() []string {
+	oSMR.RLock()
+	defer oSMR.RUnlock()
+	keys := make([]string, 0, len(oSMR.objects))
+	for key := range oSMR.objects {
+		keys = append(keys, key)
+	}
+	return keys
+}
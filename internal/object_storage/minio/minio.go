@@ -3,6 +3,9 @@ package minio
 import (
 	"bytes"
 	"context"
+	"io"
+	"io/ioutil"
+	"mime"
 	"sync"
 
 	minioConn "github.com/fBloc/bloc-client-go/internal/conns/minio"
@@ -30,6 +33,12 @@ func New(addresses []string, key, password string, bucketName string) *ObjectSto
 }
 
 func (oSMR *ObjectStorageMinioRepository) Set(key string, byteData []byte) error {
+	return oSMR.SetWithMeta(key, byteData, object_storage.Meta{})
+}
+
+func (oSMR *ObjectStorageMinioRepository) SetWithMeta(
+	key string, byteData []byte, meta object_storage.Meta,
+) error {
 	objJsonIOReader := bytes.NewReader(byteData)
 	_, err := oSMR.client.PutObject(
 		context.Background(),
@@ -37,23 +46,79 @@ func (oSMR *ObjectStorageMinioRepository) Set(key string, byteData []byte) error
 		key,
 		objJsonIOReader,
 		objJsonIOReader.Size(),
-		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+		putObjectOptions(meta))
 	return err
 }
 
+func (oSMR *ObjectStorageMinioRepository) SetFromReader(
+	key string, reader io.Reader, meta object_storage.Meta,
+) (int64, error) {
+	// size -1 makes minio upload the stream by multipart
+	info, err := oSMR.client.PutObject(
+		context.Background(),
+		oSMR.bucketName,
+		key,
+		reader,
+		-1,
+		putObjectOptions(meta))
+	return info.Size, err
+}
+
+func putObjectOptions(meta object_storage.Meta) minio.PutObjectOptions {
+	opts := minio.PutObjectOptions{
+		ContentType:     meta.ContentType,
+		ContentEncoding: meta.ContentEncoding}
+	if opts.ContentType == "" {
+		opts.ContentType = "application/octet-stream"
+	}
+	if meta.Filename != "" {
+		opts.ContentDisposition = mime.FormatMediaType(
+			"attachment", map[string]string{"filename": meta.Filename})
+	}
+	return opts
+}
+
 func (oSMR *ObjectStorageMinioRepository) Get(key string) ([]byte, error) {
+	data, _, err := oSMR.GetWithMeta(key)
+	return data, err
+}
+
+func (oSMR *ObjectStorageMinioRepository) Exists(key string) (bool, error) {
+	_, err := oSMR.client.StatObject(
+		context.Background(), oSMR.bucketName, key, minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return false, nil
+	}
+	return false, err
+}
+
+func (oSMR *ObjectStorageMinioRepository) GetWithMeta(
+	key string,
+) ([]byte, object_storage.Meta, error) {
 	reader, err := oSMR.client.GetObject(
 		context.Background(), oSMR.bucketName, key, minio.GetObjectOptions{})
 	if err != nil {
-		return []byte{}, err
+		return []byte{}, object_storage.Meta{}, err
 	}
 	defer reader.Close()
 
 	stat, err := reader.Stat()
 	if err != nil {
-		return []byte{}, err
+		return []byte{}, object_storage.Meta{}, err
+	}
+	meta := object_storage.Meta{
+		ContentType:     stat.ContentType,
+		ContentEncoding: stat.Metadata.Get("Content-Encoding")}
+	_, dispositionParams, err := mime.ParseMediaType(stat.Metadata.Get("Content-Disposition"))
+	if err == nil {
+		meta.Filename = dispositionParams["filename"]
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return []byte{}, meta, err
 	}
-	data := make([]byte, stat.Size)
-	reader.Read(data)
-	return data, nil
+	return data, meta, nil
 }
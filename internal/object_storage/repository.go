@@ -1,6 +1,24 @@
 package object_storage
 
+import "io"
+
+// Meta is the metadata kept along with an object
+type Meta struct {
+	ContentType string
+	// Filename is the name the object is downloaded as, blank means not a file
+	Filename string
+	// ContentEncoding is the compress encoding of the stored data,
+	// blank means stored as is
+	ContentEncoding string
+}
+
 type ObjectStorage interface {
 	Set(key string, data []byte) error
 	Get(key string) ([]byte, error)
+	Exists(key string) (bool, error)
+	SetWithMeta(key string, data []byte, meta Meta) error
+	GetWithMeta(key string) ([]byte, Meta, error)
+	// SetFromReader streams data of unknown size into the object storage
+	// and returns the stored size
+	SetFromReader(key string, reader io.Reader, meta Meta) (int64, error)
 }
@@ -0,0 +1,346 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	pendingDir = "pending"
+	deadDir    = "dead"
+	entryExt   = ".json"
+
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = time.Minute
+)
+
+// Entry is a call kept in the outbox until it's sent
+type Entry struct {
+	Seq       uint64          `json:"seq"`
+	Kind      string          `json:"kind"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Stats is the metrics of the outbox
+type Stats struct {
+	// Pending is the amount of entries waiting to be sent
+	Pending int
+	// OldestPendingAt is when the oldest pending entry is appended,
+	// zero if none is pending
+	OldestPendingAt time.Time
+	Appended        uint64
+	Replayed        uint64
+	// Dropped entries are moved to the dead dir as they can never be sent
+	Dropped        uint64
+	ReplayFailures uint64
+	LastError      string
+}
+
+type permanentError struct {
+	error
+}
+
+func (pE permanentError) Unwrap() error {
+	return pE.error
+}
+
+// Permanent marks the send error as not to be retried,
+// the entry is moved to the dead dir then
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Outbox is a write-ahead log of calls on disk. entries are replayed in
+// the order they are appended, each one is removed only after it's sent.
+type Outbox struct {
+	dir            string
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	seq            uint64
+	pending        []uint64
+	oldestAt       time.Time
+	stats          Stats
+	notify         chan struct{}
+	sync.Mutex
+}
+
+// Open loads the entries left in dir by the last run
+func Open(dir string, initialBackoff, maxBackoff time.Duration) (*Outbox, error) {
+	for _, sub := range []string{pendingDir, deadDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, errors.Wrap(err, "create outbox dir failed")
+		}
+	}
+	if initialBackoff <= 0 {
+		initialBackoff = DefaultInitialBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	o := &Outbox{
+		dir:            dir,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		notify:         make(chan struct{}, 1)}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, pendingDir))
+	if err != nil {
+		return nil, errors.Wrap(err, "read outbox dir failed")
+	}
+	for _, file := range files {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), entryExt), 10, 64)
+		if err != nil || !strings.HasSuffix(file.Name(), entryExt) {
+			continue // temp file of an interrupted append
+		}
+		o.pending = append(o.pending, seq)
+		if seq > o.seq {
+			o.seq = seq
+		}
+	}
+	sort.Slice(o.pending, func(i, j int) bool { return o.pending[i] < o.pending[j] })
+	if len(o.pending) > 0 {
+		if head, err := o.read(o.pending[0]); err == nil {
+			o.oldestAt = head.CreatedAt
+		}
+	}
+	return o, nil
+}
+
+func (o *Outbox) path(sub string, seq uint64) string {
+	return filepath.Join(o.dir, sub, fmt.Sprintf("%020d%s", seq, entryExt))
+}
+
+// writeFile makes sure the file is either fully written or not there
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+func (o *Outbox) read(seq uint64) (*Entry, error) {
+	data, err := ioutil.ReadFile(o.path(pendingDir, seq))
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Append returns the seq of the entry after it's persisted
+func (o *Outbox) Append(kind string, payload []byte) (uint64, error) {
+	o.Lock()
+	defer o.Unlock()
+	entry := Entry{
+		Seq:       o.seq + 1,
+		Kind:      kind,
+		Payload:   payload,
+		CreatedAt: time.Now()}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeFile(o.path(pendingDir, entry.Seq), data); err != nil {
+		return 0, errors.Wrap(err, "write outbox entry failed")
+	}
+
+	o.seq = entry.Seq
+	if len(o.pending) == 0 {
+		o.oldestAt = entry.CreatedAt
+	}
+	o.pending = append(o.pending, entry.Seq)
+	o.stats.Appended++
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+	return entry.Seq, nil
+}
+
+// Each calls fn with every pending entry in order
+func (o *Outbox) Each(fn func(Entry)) error {
+	o.Lock()
+	defer o.Unlock()
+	for _, seq := range o.pending {
+		entry, err := o.read(seq)
+		if err != nil {
+			return errors.Wrap(err, "read outbox entry failed")
+		}
+		fn(*entry)
+	}
+	return nil
+}
+
+// UpdateEntry replaces the payload of the entry of seq if fn returns a new
+// one, nothing is done if the entry is not pending any more
+func (o *Outbox) UpdateEntry(seq uint64, fn func(Entry) (payload []byte, ok bool)) error {
+	o.Lock()
+	defer o.Unlock()
+	pending := false
+	for _, s := range o.pending {
+		if s == seq {
+			pending = true
+			break
+		}
+	}
+	if !pending {
+		return nil
+	}
+	entry, err := o.read(seq)
+	if err != nil {
+		return errors.Wrap(err, "read outbox entry failed")
+	}
+	payload, ok := fn(*entry)
+	if !ok {
+		return nil
+	}
+	entry.Payload = payload
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(o.path(pendingDir, seq), data); err != nil {
+		return errors.Wrap(err, "rewrite outbox entry failed")
+	}
+	return nil
+}
+
+// Pending returns the amount of entries waiting to be sent
+func (o *Outbox) Pending() int {
+	o.Lock()
+	defer o.Unlock()
+	return len(o.pending)
+}
+
+func (o *Outbox) Stats() Stats {
+	o.Lock()
+	defer o.Unlock()
+	stats := o.stats
+	stats.Pending = len(o.pending)
+	if stats.Pending > 0 {
+		stats.OldestPendingAt = o.oldestAt
+	}
+	return stats
+}
+
+func (o *Outbox) head() (*Entry, error) {
+	o.Lock()
+	defer o.Unlock()
+	if len(o.pending) == 0 {
+		return nil, nil
+	}
+	return o.read(o.pending[0])
+}
+
+// done removes the head entry, into the dead dir if dead
+func (o *Outbox) done(entry *Entry, dead bool, sendErr error) {
+	o.Lock()
+	defer o.Unlock()
+	if dead {
+		os.Rename(o.path(pendingDir, entry.Seq), o.path(deadDir, entry.Seq))
+		o.stats.Dropped++
+		o.stats.LastError = sendErr.Error()
+	} else {
+		os.Remove(o.path(pendingDir, entry.Seq))
+		o.stats.Replayed++
+	}
+	o.pending = o.pending[1:]
+	if len(o.pending) > 0 {
+		if next, err := o.read(o.pending[0]); err == nil {
+			o.oldestAt = next.CreatedAt
+		}
+	}
+}
+
+func (o *Outbox) failed(err error) {
+	o.Lock()
+	defer o.Unlock()
+	o.stats.ReplayFailures++
+	o.stats.LastError = err.Error()
+}
+
+// Replay sends the entries one by one in order until ctx is done.
+// a failed entry is retried with exponential backoff, and blocks the
+// ones after it, unless send marks the error Permanent
+func (o *Outbox) Replay(ctx context.Context, send func(Entry) error) {
+	backoff := o.initialBackoff
+	for {
+		entry, err := o.head()
+		if err != nil {
+			// unreadable entry would never be sent
+			o.Lock()
+			seq := o.pending[0]
+			o.Unlock()
+			o.done(&Entry{Seq: seq}, true, errors.Wrap(err, "read outbox entry failed"))
+			continue
+		}
+		if entry == nil {
+			select {
+			case <-o.notify:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		err = send(*entry)
+		var permanent permanentError
+		switch {
+		case err == nil:
+			o.done(entry, false, nil)
+			backoff = o.initialBackoff
+			continue
+		case errors.As(err, &permanent):
+			o.done(entry, true, err)
+			continue
+		}
+
+		o.failed(err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		backoff *= 2
+		if backoff > o.maxBackoff {
+			backoff = o.maxBackoff
+		}
+	}
+}
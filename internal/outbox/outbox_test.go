@@ -0,0 +1,143 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func replayUntil(
+	t *testing.T, o *Outbox, send func(Entry) error, done func(Stats) bool,
+) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go o.Replay(ctx, send)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for !done(o.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("replay not done in time, stats: %+v", o.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplayInOrderWithRetry(t *testing.T) {
+	o, err := Open(t.TempDir(), time.Millisecond, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{`"a"`, `"b"`, `"c"`} {
+		if _, err := o.Append("kind", []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sent []string
+	failures := 2
+	replayUntil(t, o, func(e Entry) error {
+		if failures > 0 {
+			failures--
+			return errors.New("server unavailable")
+		}
+		sent = append(sent, string(e.Payload))
+		return nil
+	}, func(s Stats) bool { return s.Replayed == 3 })
+
+	if len(sent) != 3 || sent[0] != `"a"` || sent[1] != `"b"` || sent[2] != `"c"` {
+		t.Errorf("entries should be replayed in order, get: %v", sent)
+	}
+	stats := o.Stats()
+	if stats.Pending != 0 || stats.Appended != 3 || stats.ReplayFailures != 2 ||
+		!stats.OldestPendingAt.IsZero() || stats.LastError != "server unavailable" {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestReopenKeepsPending(t *testing.T) {
+	dir := t.TempDir()
+	o, err := Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Append("kind", []byte(`1`))
+	o.Append("kind", []byte(`2`))
+
+	reopened, err := Open(dir, time.Millisecond, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := reopened.Stats(); stats.Pending != 2 || stats.OldestPendingAt.IsZero() {
+		t.Fatalf("reopened outbox should keep the pending entries, stats: %+v", stats)
+	}
+	if _, err := reopened.Append("kind", []byte(`3`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var seqs []uint64
+	replayUntil(t, reopened, func(e Entry) error {
+		seqs = append(seqs, e.Seq)
+		return nil
+	}, func(s Stats) bool { return s.Pending == 0 })
+	if len(seqs) != 3 || seqs[0] != 1 || seqs[1] != 2 || seqs[2] != 3 {
+		t.Errorf("unexpected replayed seqs: %v", seqs)
+	}
+}
+
+func TestPermanentErrorDropped(t *testing.T) {
+	dir := t.TempDir()
+	o, err := Open(dir, time.Millisecond, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Append("bad", []byte(`1`))
+	o.Append("good", []byte(`2`))
+
+	replayUntil(t, o, func(e Entry) error {
+		if e.Kind == "bad" {
+			return Permanent(errors.New("bad request"))
+		}
+		return nil
+	}, func(s Stats) bool { return s.Pending == 0 })
+
+	stats := o.Stats()
+	if stats.Dropped != 1 || stats.Replayed != 1 || stats.ReplayFailures != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	dead, _ := ioutil.ReadDir(filepath.Join(dir, deadDir))
+	if len(dead) != 1 {
+		t.Errorf("dropped entry should be kept in dead dir, get %d files", len(dead))
+	}
+}
+
+func TestUpdateEntry(t *testing.T) {
+	dir := t.TempDir()
+	o, err := Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.Append("a", []byte(`1`))
+	seq, _ := o.Append("b", []byte(`2`))
+	err = o.UpdateEntry(seq, func(e Entry) ([]byte, bool) {
+		return []byte(`3`), e.Kind == "b"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, _ := Open(dir, 0, 0)
+	var payloads []string
+	reopened.Each(func(e Entry) { payloads = append(payloads, string(e.Payload)) })
+	if len(payloads) != 2 || payloads[0] != `1` || payloads[1] != `3` {
+		t.Errorf("only the updated payload should be changed & persisted, get: %v", payloads)
+	}
+	if err := reopened.UpdateEntry(seq+1, func(e Entry) ([]byte, bool) {
+		t.Error("entry not pending should not be updated")
+		return nil, false
+	}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,50 @@
+package tlsconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// Config is the TLS of the connections to a server, e.g. bloc-server or
+// the rabbit broker. files are PEM encoded, all of them are optional
+type Config struct {
+	// CAFile is the CA bundle to verify the server's certificate,
+	// system roots are used if empty
+	CAFile string
+	// CertFile & KeyFile is the client certificate for mutual TLS
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name the server's certificate is verified by
+	ServerName string
+	// InsecureSkipVerify skips verifying the server's certificate, only for dev
+	InsecureSkipVerify bool
+}
+
+// TLSClientConfig loads the files into the config of a TLS client
+func (c *Config) TLSClientConfig() (*tls.Config, error) {
+	conf := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		caPEM, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read CA file failed")
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.Errorf("no valid certificate in CA file %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate failed")
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
@@ -193,6 +193,30 @@ func (ipt *Ipt) GetJsonStrMapValue(componentIndex int) (map[string]interface{},
 
 type Ipts []*Ipt
 
+// copy returns the ipts with their own components,
+// so that the values set by a run are not shared with other runs
+func (iS Ipts) copy() Ipts {
+	copied := make(Ipts, 0, len(iS))
+	for _, ipt := range iS {
+		if ipt == nil {
+			copied = append(copied, nil)
+			continue
+		}
+		iptCopy := *ipt
+		iptCopy.Components = make([]*IptComponent, 0, len(ipt.Components))
+		for _, component := range ipt.Components {
+			if component == nil {
+				iptCopy.Components = append(iptCopy.Components, nil)
+				continue
+			}
+			componentCopy := *component
+			iptCopy.Components = append(iptCopy.Components, &componentCopy)
+		}
+		copied = append(copied, &iptCopy)
+	}
+	return copied
+}
+
 func (iS *Ipts) iptIndexValid(iptIndex int) error {
 	if len(*iS) < iptIndex-1 {
 		return errors.New("iptIndex out of range")
@@ -1,29 +1,32 @@
 package bloc_client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"path"
+	"net/url"
 	"sync"
 	"time"
 
 	"github.com/fBloc/bloc-client-go/internal/http_util"
 )
 
-var serverUrl string
-
 const logSubPath = "report_log"
 
 type LogLevel = string
 
 const (
+	Debug   LogLevel = "debug"
 	Info    LogLevel = "info"
 	Warning LogLevel = "Warning"
 	Error   LogLevel = "error"
 )
 
-type msg struct {
+// functionRunRecordIDTag is set to every log, not overwritten by fields
+const functionRunRecordIDTag = "function_run_record_id"
+
+type LogMsg struct {
 	Level  LogLevel          `json:"level"`
 	TagMap map[string]string `json:"tag_map"`
 	Data   string            `json:"data"`
@@ -31,11 +34,15 @@ type msg struct {
 }
 
 type Logger struct {
-	name                string
-	traceID             string
-	spanID              string
+	name string
+	// trace is shared with the children of With, so that a trace set
+	// after a child is created is still logged by the child
+	trace               *logTrace
 	functionRunRecordID string
-	isMock              bool
+	// tags are the fields added by With, never changed after created
+	tags    map[string]string
+	shipper *logShipper
+	isMock  bool
 	sync.Mutex
 }
 
@@ -49,43 +56,176 @@ func (logger *Logger) IsZero() bool {
 func (logger *Logger) SetTraceIDAndSpanID(
 	traceID, spanID string,
 ) {
-	logger.traceID = traceID
-	logger.spanID = spanID
+	logger.getTrace().set(traceID, spanID)
+}
+
+type logTrace struct {
+	traceID string
+	spanID  string
+	sync.RWMutex
 }
 
+func (t *logTrace) set(traceID, spanID string) {
+	t.Lock()
+	defer t.Unlock()
+	t.traceID = traceID
+	t.spanID = spanID
+}
+
+func (t *logTrace) get() (traceID, spanID string) {
+	t.RLock()
+	defer t.RUnlock()
+	return t.traceID, t.spanID
+}
+
+// getTrace creates the trace of a logger not made by the constructors
+func (logger *Logger) getTrace() *logTrace {
+	logger.Lock()
+	defer logger.Unlock()
+	if logger.trace == nil {
+		logger.trace = &logTrace{}
+	}
+	return logger.trace
+}
+
+// serverLogShippers are the shippers of NewLogger by the server address,
+// shared by the loggers of the same server
+var (
+	serverLogShippers     = make(map[string]*logShipper)
+	serverLogShippersLock sync.Mutex
+)
+
+// NewLogger uploads logs to the bloc-server api at server(`ip:port/api/v1/client`
+// or the url of it). the loggers of the same server share one shipper.
+// if server is not a valid address, logs are printed locally instead
 func NewLogger(name, server, functionRunRecordID string) *Logger {
-	serverUrl = server
+	shipper, err := serverLogShipper(server)
+	if err != nil {
+		log.Printf("invalid bloc-server address of logger, logs are printed locally: %v", err)
+		l := newMockLogger()
+		l.name = name
+		l.functionRunRecordID = functionRunRecordID
+		return l
+	}
+	return newLogger(name, shipper, functionRunRecordID)
+}
+
+func serverLogShipper(server string) (*logShipper, error) {
+	serverLogShippersLock.Lock()
+	defer serverLogShippersLock.Unlock()
+	if shipper, ok := serverLogShippers[server]; ok {
+		return shipper, nil
+	}
+	endpoint, err := http_util.ParseURL(server, "http")
+	if err != nil {
+		return nil, err
+	}
+	serverClient := newHTTPServerClient([]*url.URL{endpoint}, nil, &BlocServerConfig{})
+	shipper := newLogShipper(serverClient, DefaultLogShipperConfig)
+	serverLogShippers[server] = shipper
+	return shipper, nil
+}
+
+func newLogger(name string, shipper *logShipper, functionRunRecordID string) *Logger {
 	l := &Logger{
 		name:                name,
-		functionRunRecordID: functionRunRecordID}
+		trace:               &logTrace{},
+		functionRunRecordID: functionRunRecordID,
+		shipper:             shipper}
 	return l
 }
 
 func newMockLogger() *Logger {
-	l := &Logger{isMock: true}
+	l := &Logger{trace: &logTrace{}, isMock: true}
 	return l
 }
 
+// With returns a child logger whose logs carry the field in their tags,
+// so they can be filtered by it in the frontend. the value is converted
+// to string, or json if it cannot be
+func (logger *Logger) With(key string, value interface{}) *Logger {
+	tags := make(map[string]string, len(logger.tags)+1)
+	for k, v := range logger.tags {
+		tags[k] = v
+	}
+	tags[key] = toString(value)
+	return &Logger{
+		name:                logger.name,
+		trace:               logger.getTrace(),
+		functionRunRecordID: logger.functionRunRecordID,
+		tags:                tags,
+		shipper:             logger.shipper,
+		isMock:              logger.isMock}
+}
+
+func (logger *Logger) Debugf(
+	format string, a ...interface{},
+) {
+	logger.uploadMsg(Debug, fmt.Sprintf(format, a...), nil)
+}
+
 func (logger *Logger) Infof(
 	format string, a ...interface{},
 ) {
-	go logger.uploadMsg(Info, fmt.Sprintf(format, a...))
+	logger.uploadMsg(Info, fmt.Sprintf(format, a...), nil)
 }
 
 func (logger *Logger) Warningf(
 	format string, a ...interface{},
 ) {
-	go logger.uploadMsg(Warning, fmt.Sprintf(format, a...))
+	logger.uploadMsg(Warning, fmt.Sprintf(format, a...), nil)
 }
 
 func (logger *Logger) Errorf(
 	format string, a ...interface{},
 ) {
-	go logger.uploadMsg(Error, fmt.Sprintf(format, a...))
+	logger.uploadMsg(Error, fmt.Sprintf(format, a...), nil)
+}
+
+// Debugw logs msg with the fields of keysAndValues in it's tags, like
+// logger.Debugw("batch done", "batch", 3, "rows", 100)
+func (logger *Logger) Debugw(msg string, keysAndValues ...interface{}) {
+	logger.uploadMsg(Debug, msg, fieldsOf(keysAndValues))
+}
+
+// Infow is Debugw of info level
+func (logger *Logger) Infow(msg string, keysAndValues ...interface{}) {
+	logger.uploadMsg(Info, msg, fieldsOf(keysAndValues))
+}
+
+// Warningw is Debugw of warning level
+func (logger *Logger) Warningw(msg string, keysAndValues ...interface{}) {
+	logger.uploadMsg(Warning, msg, fieldsOf(keysAndValues))
+}
+
+// Errorw is Debugw of error level
+func (logger *Logger) Errorw(msg string, keysAndValues ...interface{}) {
+	logger.uploadMsg(Error, msg, fieldsOf(keysAndValues))
+}
+
+// fieldsOf pairs keysAndValues, the last key without value gets a blank one
+func fieldsOf(keysAndValues []interface{}) map[string]string {
+	fields := make(map[string]string, (len(keysAndValues)+1)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		value := ""
+		if i+1 < len(keysAndValues) {
+			value = toString(keysAndValues[i+1])
+		}
+		fields[toString(keysAndValues[i])] = value
+	}
+	return fields
+}
+
+// Flush returns after the logs are uploaded, or ctx is done
+func (logger *Logger) Flush(ctx context.Context) error {
+	if logger.isMock {
+		return nil
+	}
+	return logger.shipper.flush(ctx)
 }
 
 type HttpReq struct {
-	LogData []*msg `json:"logs"`
+	LogData []*LogMsg `json:"logs"`
 }
 
 type HttpResp struct {
@@ -99,32 +239,45 @@ func (
 ) uploadMsg(
 	level LogLevel,
 	data string,
+	fields map[string]string,
 ) {
+	tagMap := make(map[string]string, len(logger.tags)+len(fields)+1)
+	for k, v := range logger.tags {
+		tagMap[k] = v
+	}
+	for k, v := range fields {
+		tagMap[k] = v
+	}
 	if logger.isMock {
-		log.Printf("%s %s", level, data)
+		if len(tagMap) > 0 {
+			log.Printf("%s %s %v", level, data, tagMap)
+		} else {
+			log.Printf("%s %s", level, data)
+		}
 		return
 	}
-	logMsg := &msg{
-		Time:  time.Now(),
-		Level: level,
-		Data:  data,
-		TagMap: map[string]string{
-			"function_run_record_id": logger.functionRunRecordID,
-		},
+	tagMap[functionRunRecordIDTag] = logger.functionRunRecordID
+	logMsg := &LogMsg{
+		Time:   time.Now(),
+		Level:  level,
+		Data:   data,
+		TagMap: tagMap,
 	}
 
-	httpReqData := HttpReq{LogData: []*msg{logMsg}}
-	httpReqByte, err := json.Marshal(httpReqData)
-	// log error would not do future handle as log is not crucial
+	// uploaded in batches by the shipper, in the order logged
+	traceID, spanID := logger.getTrace().get()
+	logger.shipper.ship(traceID, spanID, logMsg)
+}
+
+func (hSC *httpServerClient) UploadLogs(ctx context.Context, logs []*LogMsg) error {
+	httpReqByte, err := json.Marshal(HttpReq{LogData: logs})
 	if err != nil {
-		return
+		return err
 	}
 
-	header := map[string]string{
-		string(TraceID): logger.traceID,
-		string(SpanID):  logger.spanID}
 	var resp HttpResp
-	http_util.PostJson(
-		path.Join(serverUrl, logSubPath),
-		header, httpReqByte, &resp)
+	err = hSC.client.PostJson(
+		ctx, hSC.apiPath(logSubPath),
+		traceHeader(ctx), httpReqByte, &resp)
+	return checkResp(logSubPath, err, &resp)
 }
@@ -0,0 +1,189 @@
+package bloc_client
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// LogShipperConfig is how the logs of a client are buffered & uploaded in batches
+type LogShipperConfig struct {
+	// BufferSize is the lines waiting to be uploaded, lines beyond it are
+	// dropped. defaults to 4096
+	BufferSize int
+	// BatchSize is the max lines of an upload, defaults to 100
+	BatchSize int
+	// FlushInterval is the max time a line waits for it's batch, defaults to 1s
+	FlushInterval time.Duration
+}
+
+var DefaultLogShipperConfig = LogShipperConfig{
+	BufferSize:    4096,
+	BatchSize:     100,
+	FlushInterval: time.Second}
+
+// LogShipperStats is the counters of the log shipper
+type LogShipperStats struct {
+	// Buffered lines are waiting to be uploaded
+	Buffered int
+	Shipped  uint64
+	// Dropped lines are not buffered as the buffer is full
+	Dropped uint64
+	// Failed lines are dropped as their upload failed
+	Failed uint64
+}
+
+// shippingLog is a line with the trace it's logged in
+type shippingLog struct {
+	traceID string
+	spanID  string
+	msg     *LogMsg
+}
+
+// logShipper uploads the logs of all the loggers of a client in order,
+// by batches of size & time
+type logShipper struct {
+	serverClient ServerClient
+	conf         LogShipperConfig
+	logs         chan *shippingLog
+	flushes      chan chan struct{}
+	shipped      uint64
+	dropped      uint64
+	failed       uint64
+}
+
+func newLogShipper(serverClient ServerClient, conf LogShipperConfig) *logShipper {
+	if conf.BufferSize <= 0 {
+		conf.BufferSize = DefaultLogShipperConfig.BufferSize
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = DefaultLogShipperConfig.BatchSize
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = DefaultLogShipperConfig.FlushInterval
+	}
+	lS := &logShipper{
+		serverClient: serverClient,
+		conf:         conf,
+		logs:         make(chan *shippingLog, conf.BufferSize),
+		flushes:      make(chan chan struct{})}
+	go lS.run()
+	return lS
+}
+
+// ship never blocks, the line is dropped if the buffer is full
+func (lS *logShipper) ship(traceID, spanID string, msg *LogMsg) {
+	select {
+	case lS.logs <- &shippingLog{traceID: traceID, spanID: spanID, msg: msg}:
+	default:
+		atomic.AddUint64(&lS.dropped, 1)
+	}
+}
+
+// flush returns after the lines shipped before it are uploaded,
+// or ctx is done
+func (lS *logShipper) flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case lS.flushes <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (lS *logShipper) stats() LogShipperStats {
+	return LogShipperStats{
+		Buffered: len(lS.logs),
+		Shipped:  atomic.LoadUint64(&lS.shipped),
+		Dropped:  atomic.LoadUint64(&lS.dropped),
+		Failed:   atomic.LoadUint64(&lS.failed)}
+}
+
+func (lS *logShipper) run() {
+	ticker := time.NewTicker(lS.conf.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]*shippingLog, 0, lS.conf.BatchSize)
+	var reportedDropped uint64
+	for {
+		select {
+		case l := <-lS.logs:
+			batch = append(batch, l)
+			if len(batch) < lS.conf.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case done := <-lS.flushes:
+			// the lines shipped before the flush are all in the buffer
+			for buffered := len(lS.logs); buffered > 0; buffered-- {
+				batch = append(batch, <-lS.logs)
+				if len(batch) >= lS.conf.BatchSize {
+					lS.upload(batch)
+					batch = batch[:0]
+				}
+			}
+			lS.upload(batch)
+			batch = batch[:0]
+			close(done)
+			continue
+		}
+		lS.upload(batch)
+		batch = batch[:0]
+
+		if dropped := atomic.LoadUint64(&lS.dropped); dropped > reportedDropped {
+			log.Printf("log buffer is full, %d lines dropped", dropped-reportedDropped)
+			reportedDropped = dropped
+		}
+	}
+}
+
+// upload sends the batch in order, lines of the same trace in a row by one request
+func (lS *logShipper) upload(batch []*shippingLog) {
+	for start := 0; start < len(batch); {
+		end := start + 1
+		for end < len(batch) &&
+			batch[end].traceID == batch[start].traceID &&
+			batch[end].spanID == batch[start].spanID {
+			end++
+		}
+		msgs := make([]*LogMsg, 0, end-start)
+		for _, l := range batch[start:end] {
+			msgs = append(msgs, l.msg)
+		}
+		err := lS.serverClient.UploadLogs(
+			SetTraceIDAndSpanIDToContext(batch[start].traceID, batch[start].spanID), msgs)
+		if err != nil {
+			// log is not crucial, not retried further
+			atomic.AddUint64(&lS.failed, uint64(len(msgs)))
+		} else {
+			atomic.AddUint64(&lS.shipped, uint64(len(msgs)))
+		}
+		start = end
+	}
+}
+
+// logShipper returns the shipper of the run logs of the client
+func (bC *blocClient) logShipper() *logShipper {
+	serverClient := bC.ServerClient()
+	bC.Lock()
+	defer bC.Unlock()
+	if bC.logs == nil {
+		conf := DefaultLogShipperConfig
+		if bC.configBuilder.LogShipperConf != nil {
+			conf = *bC.configBuilder.LogShipperConf
+		}
+		bC.logs = newLogShipper(serverClient, conf)
+	}
+	return bC.logs
+}
+
+// LogShipperStats returns the counters of uploading run logs
+func (bC *blocClient) LogShipperStats() LogShipperStats {
+	return bC.logShipper().stats()
+}
@@ -0,0 +1,156 @@
+package bloc_client
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logRecorder records the uploads of logs, blocked while block is not closed
+type logRecorder struct {
+	ServerClient
+	block   chan struct{}
+	uploads [][]*LogMsg
+	traces  []string
+	sync.Mutex
+}
+
+func (lR *logRecorder) UploadLogs(ctx context.Context, logs []*LogMsg) error {
+	if lR.block != nil {
+		<-lR.block
+	}
+	lR.Lock()
+	defer lR.Unlock()
+	lR.uploads = append(lR.uploads, logs)
+	lR.traces = append(lR.traces, GetTraceIDFromContext(ctx))
+	return nil
+}
+
+func (lR *logRecorder) lines() []string {
+	lR.Lock()
+	defer lR.Unlock()
+	var lines []string
+	for _, upload := range lR.uploads {
+		for _, msg := range upload {
+			lines = append(lines, msg.Data)
+		}
+	}
+	return lines
+}
+
+func TestLogShipperBatchesInOrder(t *testing.T) {
+	recorder := &logRecorder{}
+	shipper := newLogShipper(recorder, LogShipperConfig{BatchSize: 4, FlushInterval: time.Hour})
+	logger := newLogger("test", shipper, "record_1")
+	logger.SetTraceIDAndSpanID("trace_1", "span_1")
+	for i := 0; i < 6; i++ {
+		logger.Infof("%d", i)
+	}
+	logger.SetTraceIDAndSpanID("trace_2", "span_2")
+	logger.Errorf("6")
+
+	if err := logger.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lines := recorder.lines()
+	if len(lines) != 7 {
+		t.Fatalf("all lines should be uploaded after flush, get: %v", lines)
+	}
+	for i, line := range lines {
+		if line != strconv.Itoa(i) {
+			t.Fatalf("lines should be uploaded in order, get: %v", lines)
+		}
+	}
+	// a full batch, then the rest split by trace
+	if len(recorder.uploads) != 3 || len(recorder.uploads[0]) != 4 ||
+		recorder.traces[1] != "trace_1" || recorder.traces[2] != "trace_2" {
+		t.Errorf("unexpected uploads: %v, traces: %v", recorder.uploads, recorder.traces)
+	}
+	if stats := shipper.stats(); stats.Shipped != 7 || stats.Dropped != 0 || stats.Buffered != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestLogShipperFlushByInterval(t *testing.T) {
+	recorder := &logRecorder{}
+	logger := newLogger(
+		"test", newLogShipper(recorder, LogShipperConfig{FlushInterval: 10 * time.Millisecond}), "record_1")
+	logger.Infof("line")
+
+	deadline := time.Now().Add(time.Second)
+	for len(recorder.lines()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("line should be uploaded by the flush interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLogShipperDropsWhenFull(t *testing.T) {
+	recorder := &logRecorder{block: make(chan struct{})}
+	shipper := newLogShipper(recorder, LogShipperConfig{
+		BufferSize: 2, BatchSize: 1, FlushInterval: time.Hour})
+	logger := newLogger("test", shipper, "record_1")
+
+	// the first line is being uploaded, 2 more are buffered
+	logger.Infof("0")
+	time.Sleep(20 * time.Millisecond)
+	for i := 1; i < 10; i++ {
+		logger.Infof("%d", i)
+	}
+	if stats := shipper.stats(); stats.Dropped != 7 || stats.Buffered != 2 {
+		t.Errorf("lines beyond the buffer should be dropped, stats: %+v", stats)
+	}
+
+	close(recorder.block)
+	if err := logger.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if lines := recorder.lines(); len(lines) != 3 || lines[0] != "0" || lines[2] != "2" {
+		t.Errorf("buffered lines should be uploaded in order, get: %v", lines)
+	}
+}
+
+func TestLogShipperFlushTimeout(t *testing.T) {
+	recorder := &logRecorder{block: make(chan struct{})}
+	defer close(recorder.block)
+	logger := newLogger(
+		"test", newLogShipper(recorder, LogShipperConfig{BatchSize: 1}), "record_1")
+	logger.Infof("line")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := logger.Flush(ctx); err != context.DeadlineExceeded {
+		t.Errorf("flush should return when ctx is done, get: %v", err)
+	}
+}
+
+func TestRunLogsFlushedBeforeAcked(t *testing.T) {
+	client, server, eventMQ := newMockClient(t, func(cb *ConfigBuilder) {
+		cb.SetLogShipperConfig(LogShipperConfig{FlushInterval: time.Hour})
+	})
+	runSumFunction(t, client, server, eventMQ)
+
+	if posted := server.getPosted(logSubPath); posted == 0 {
+		t.Error("logs of the run should be flushed before it's acked")
+	}
+	if stats := client.LogShipperStats(); stats.Shipped == 0 || stats.Buffered != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestNewLoggerSharesShipper(t *testing.T) {
+	first := NewLogger("first", "127.0.0.1:1/api/v1/client", "record_1")
+	second := NewLogger("second", "127.0.0.1:1/api/v1/client", "record_2")
+	if first.shipper == nil || first.shipper != second.shipper {
+		t.Error("loggers of the same server should share the shipper")
+	}
+
+	logger := NewLogger("invalid", "ftp://127.0.0.1:1", "record_3")
+	logger.Infof("printed locally")
+	if err := logger.Flush(context.Background()); err != nil {
+		t.Errorf("logger of an invalid address should fall back to printing, get: %v", err)
+	}
+}
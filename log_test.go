@@ -0,0 +1,55 @@
+package bloc_client
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLoggerStructuredFields(t *testing.T) {
+	recorder := &logRecorder{}
+	logger := newLogger("test", newLogShipper(recorder, LogShipperConfig{}), "record_1")
+	batchLogger := logger.With("batch", 3).With("source", map[string]int{"a": 1})
+
+	batchLogger.Debugf("rows: %d", 100)
+	batchLogger.Infow("batch done", "rows", 100, "ok", true, functionRunRecordIDTag, "other")
+	logger.Errorw("odd", "key")
+	if err := logger.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []*LogMsg
+	for _, upload := range recorder.uploads {
+		msgs = append(msgs, upload...)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("unexpected logs: %v", msgs)
+	}
+	if msgs[0].Level != Debug || msgs[0].Data != "rows: 100" ||
+		msgs[0].TagMap["batch"] != "3" || msgs[0].TagMap["source"] != `{"a":1}` ||
+		msgs[0].TagMap[functionRunRecordIDTag] != "record_1" {
+		t.Errorf("unexpected debug log: %+v", msgs[0])
+	}
+	if msgs[1].Level != Info || msgs[1].Data != "batch done" ||
+		msgs[1].TagMap["rows"] != "100" || msgs[1].TagMap["ok"] != "true" ||
+		msgs[1].TagMap["batch"] != "3" || msgs[1].TagMap[functionRunRecordIDTag] != "record_1" {
+		t.Errorf("unexpected structured log: %+v", msgs[1])
+	}
+	if _, ok := msgs[2].TagMap["key"]; !ok || len(msgs[2].TagMap) != 2 {
+		t.Errorf("parent logger should not have the fields of children: %+v", msgs[2])
+	}
+}
+
+func TestLoggerChildGetsTraceSetLater(t *testing.T) {
+	recorder := &logRecorder{}
+	logger := newLogger("test", newLogShipper(recorder, LogShipperConfig{}), "record_1")
+	child := logger.With("batch", 1)
+	logger.SetTraceIDAndSpanID("trace_1", "span_1")
+
+	child.Infof("in trace")
+	if err := logger.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.traces) != 1 || recorder.traces[0] != "trace_1" {
+		t.Errorf("child should log with the trace of its parent, get: %v", recorder.traces)
+	}
+}
@@ -0,0 +1,22 @@
+package bloc_client
+
+import (
+	memoryMQ "github.com/fBloc/bloc-client-go/internal/mq/memory"
+	memoryOS "github.com/fBloc/bloc-client-go/internal/object_storage/memory"
+)
+
+// MemoryMsgQueue is an in-process MsgQueue with rabbit's ack & redelivery semantics.
+// Inject it by ConfigBuilder.SetEventMQ to test FunctionRunConsumer without a live rabbitMQ.
+type MemoryMsgQueue = memoryMQ.MemoryMQ
+
+// MemoryObjectStorage is an in-process ObjectStorage.
+// Inject it by ConfigBuilder.SetObjectStorage to test without a live minio.
+type MemoryObjectStorage = memoryOS.ObjectStorageMemoryRepository
+
+func NewMemoryMsgQueue() *MemoryMsgQueue {
+	return memoryMQ.New()
+}
+
+func NewMemoryObjectStorage() *MemoryObjectStorage {
+	return memoryOS.New()
+}
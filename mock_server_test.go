@@ -0,0 +1,273 @@
+package bloc_client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fBloc/bloc-client-go/internal/compress"
+)
+
+// mockServer mocks the bloc-server's client api
+type mockServer struct {
+	*httptest.Server
+	t                 *testing.T
+	functionRunRecord map[string]*FunctionRunRecord
+	objectStorage     map[string][]byte
+	objectEncoding    map[string]CompressEncoding
+	persistedOpt      map[string]interface{}
+	finished          chan *FuncRunFinishedHttpReq
+	registered        *RegisterFuncReq
+	// authenticate rejects the request if it returns error
+	authenticate func(r *http.Request, body []byte) error
+	// failure is responded to every request if set
+	failure *mockFailure
+	// streamed receives the frames of the stream api
+	streamed chan *streamFrame
+	// streamRefusedStatus is responded by the stream api if set
+	streamRefusedStatus int
+	// encodingUnknown mocks a bloc-server not knowing the encoding of
+	// compressed data, which is neither responded nor decoded
+	encodingUnknown bool
+	// streamStalled makes the stream api never read the frames until closed
+	streamStalled bool
+	closed        chan struct{}
+	// posted counts the requests by path
+	posted map[string]int
+	sync.Mutex
+}
+
+func newMockServer(t *testing.T) *mockServer {
+	s := newUnstartedMockServer(t)
+	s.Start()
+	return s
+}
+
+// newUnstartedMockServer is started by the caller, e.g. by StartTLS
+func newUnstartedMockServer(t *testing.T) *mockServer {
+	s := &mockServer{
+		t:                 t,
+		functionRunRecord: make(map[string]*FunctionRunRecord),
+		objectStorage:     make(map[string][]byte),
+		objectEncoding:    make(map[string]CompressEncoding),
+		persistedOpt:      make(map[string]interface{}),
+		finished:          make(chan *FuncRunFinishedHttpReq, 10),
+		streamed:          make(chan *streamFrame, 100),
+		posted:            make(map[string]int),
+		closed:            make(chan struct{}),
+	}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serve))
+	t.Cleanup(func() {
+		// streams are never closed by the client
+		close(s.closed)
+		s.CloseClientConnections()
+		s.Close()
+	})
+	return s
+}
+
+func (s *mockServer) ipAndPort() (string, int) {
+	host, port, err := net.SplitHostPort(s.Listener.Addr().String())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	portInt, _ := strconv.Atoi(port)
+	return host, portInt
+}
+
+func (s *mockServer) addFunctionRunRecord(record *FunctionRunRecord) {
+	s.Lock()
+	defer s.Unlock()
+	s.functionRunRecord[record.ID] = record
+}
+
+func (s *mockServer) setObjectStorageValue(key string, value interface{}) {
+	s.setEncodedObjectStorageValue(key, value, compress.Identity)
+}
+
+func (s *mockServer) setEncodedObjectStorageValue(
+	key string, value interface{}, encoding CompressEncoding,
+) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	data, err = compress.Encode(encoding, data)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.objectStorage[key] = data
+	s.objectEncoding[key] = encoding
+}
+
+func (s *mockServer) getPersistedOpt(key string) interface{} {
+	s.Lock()
+	defer s.Unlock()
+	return s.persistedOpt[key]
+}
+
+func (s *mockServer) getRegistered() *RegisterFuncReq {
+	s.Lock()
+	defer s.Unlock()
+	return s.registered
+}
+
+type mockFailure struct {
+	httpStatus int
+	statusCode int
+	statusMsg  string
+}
+
+func (s *mockServer) setFailure(failure *mockFailure) {
+	s.Lock()
+	defer s.Unlock()
+	s.failure = failure
+}
+
+func (s *mockServer) getPosted(subPath string) int {
+	s.Lock()
+	defer s.Unlock()
+	return s.posted[subPath]
+}
+
+func (s *mockServer) serveStream(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	s.posted[serverStreamPath]++
+	refusedStatus := s.streamRefusedStatus
+	stalled := s.streamStalled
+	s.Unlock()
+	if stalled {
+		<-s.closed
+		return
+	}
+	if refusedStatus != 0 {
+		// respond before the endless body is drained
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(refusedStatus)
+		w.(http.Flusher).Flush()
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	for {
+		var frame streamFrame
+		if err := decoder.Decode(&frame); err != nil {
+			return
+		}
+		select {
+		case s.streamed <- &frame:
+		default:
+			// not received by the test
+		}
+	}
+}
+
+func (s *mockServer) writeData(w http.ResponseWriter, data interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status_code": http.StatusOK,
+		"status_msg":  "",
+		"data":        data})
+}
+
+func (s *mockServer) serve(w http.ResponseWriter, r *http.Request) {
+	subPath := strings.TrimPrefix(r.URL.Path, serverBasicPathPrefix)
+	if subPath == serverStreamPath {
+		s.serveStream(w, r)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	s.Lock()
+	defer s.Unlock()
+	s.posted[subPath]++
+
+	if s.authenticate != nil {
+		if err := s.authenticate(r, body); err != nil {
+			s.t.Errorf("unauthenticated request to %s: %v", r.URL.Path, err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if s.failure != nil {
+		w.WriteHeader(s.failure.httpStatus)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status_code": s.failure.statusCode,
+			"status_msg":  s.failure.statusMsg})
+		return
+	}
+
+	switch {
+	case subPath == registerFuncPath:
+		var req RegisterFuncReq
+		json.Unmarshal(body, &req)
+		s.registered = &req
+		resp := make(map[string][]*HttpRespFunction, len(req.GroupNameMapFunctions))
+		for groupName, functions := range req.GroupNameMapFunctions {
+			for _, f := range functions {
+				resp[groupName] = append(resp[groupName], &HttpRespFunction{
+					ID:        groupName + "-" + f.Name,
+					Name:      f.Name,
+					GroupName: groupName})
+			}
+		}
+		s.writeData(w, map[string]interface{}{"groupName_map_functions": resp})
+	case strings.HasPrefix(subPath, functionRecordPath+"/"):
+		id := strings.TrimPrefix(subPath, functionRecordPath+"/")
+		record, ok := s.functionRunRecord[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.writeData(w, record)
+	case strings.HasPrefix(subPath, fetchObjectStorageDataByKeyFromServerPath+"/"):
+		key := strings.TrimPrefix(subPath, fetchObjectStorageDataByKeyFromServerPath+"/")
+		resp := ServerObjectStorageHttpResp{
+			StatusCode: http.StatusOK,
+			Data:       s.objectStorage[key],
+			Encoding:   s.objectEncoding[key]}
+		if s.encodingUnknown {
+			resp.Encoding = compress.Identity
+		}
+		json.NewEncoder(w).Encode(resp)
+	case strings.HasPrefix(subPath, strings.Trim(FlowRunIsCanceledPath, "/")):
+		s.writeData(w, map[string]interface{}{"canceled": false})
+	case subPath == serverFuncRunOptPersistToObjectStoragePath:
+		var req FuncRunOptPersistToObjectStorageHttpReq
+		json.Unmarshal(body, &req)
+		key := req.FunctionRunRecordID + "-" + req.OptKey
+		if req.Encoding != compress.Identity && !s.encodingUnknown {
+			encoded, _ := base64.StdEncoding.DecodeString(req.Data.(string))
+			decoded, err := compress.Decode(req.Encoding, encoded)
+			if err != nil {
+				s.t.Errorf("decode persisted opt failed: %v", err)
+			}
+			json.Unmarshal(decoded, &req.Data)
+		}
+		s.persistedOpt[key] = req.Data
+		resp := FuncOptFieldServerPersisResp{ObjectStorageKey: key, Encoding: req.Encoding}
+		if s.encodingUnknown {
+			resp.Encoding = compress.Identity
+		}
+		s.writeData(w, resp)
+	case subPath == FuncRunFinishedHttpPath:
+		var req FuncRunFinishedHttpReq
+		json.Unmarshal(body, &req)
+		s.finished <- &req
+		s.writeData(w, nil)
+	case subPath == FuncRunStartHttpPath,
+		subPath == strings.Trim(FuncRunProgressReportPath, "/"),
+		subPath == logSubPath:
+		s.writeData(w, nil)
+	default:
+		s.t.Errorf("mock server get unexpected request: %s", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
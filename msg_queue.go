@@ -0,0 +1,37 @@
+package bloc_client
+
+import (
+	"github.com/fBloc/bloc-client-go/internal/mq"
+	"github.com/fBloc/bloc-client-go/internal/mq/rabbit"
+)
+
+// MsgQueue is the msg queue function run events are pulled from.
+// rabbitMQ is used by default, other brokers can be plugged in by
+// implementing it and ConfigBuilder.SetEventMQ
+type MsgQueue = mq.MsgQueue
+
+// Delivery is a broker neutral msg delivered by MsgQueue.Pull
+type Delivery = mq.Delivery
+
+// MsgAcknowledger acks a Delivery back to the broker it comes from
+type MsgAcknowledger = mq.Acknowledger
+
+// ErrUnroutable is returned by MsgQueue.Pub if no queue is bound to the topic
+var ErrUnroutable = mq.ErrUnroutable
+
+// RabbitState is the state of the rabbitMQ connection
+type RabbitState = rabbit.State
+
+const (
+	RabbitConnected    = rabbit.StateConnected
+	RabbitDisconnected = rabbit.StateDisconnected
+	RabbitReconnecting = rabbit.StateReconnecting
+	RabbitClosed       = rabbit.StateClosed
+)
+
+// RabbitStateChange is passed to the callbacks registered by
+// ConfigBuilder.OnRabbitStateChange
+type RabbitStateChange = rabbit.StateChange
+
+// RabbitReconnectRecord is one recovery of a dropped rabbitMQ connection
+type RabbitReconnectRecord = rabbit.ReconnectRecord
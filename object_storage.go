@@ -0,0 +1,11 @@
+package bloc_client
+
+import (
+	"github.com/fBloc/bloc-client-go/internal/object_storage"
+)
+
+// ObjectStorage is the storage large data is kept in
+type ObjectStorage = object_storage.ObjectStorage
+
+// ObjectMeta is the metadata kept along with an object
+type ObjectMeta = object_storage.Meta
@@ -0,0 +1,22 @@
+package bloc_client
+
+import (
+	"encoding/json"
+
+	"github.com/fBloc/bloc-client-go/internal/object_storage"
+)
+
+// PersistFunctionRunOptFieldContentAddressed persists the opt value to object storage
+// under the key derived from the hash of it's content. upload is skipped if the
+// same content is already persisted, so that same outputs share one blob.
+func (bC *blocClient) PersistFunctionRunOptFieldContentAddressed(
+	OptFieldValue interface{},
+) (string, error) {
+	data, err := json.Marshal(OptFieldValue)
+	if err != nil {
+		return "", err
+	}
+	key, _, err := object_storage.SetContentAddressed(
+		bC.GetOrCreateObjectStorage(), data)
+	return key, err
+}
@@ -0,0 +1,309 @@
+package bloc_client
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/outbox"
+
+	"github.com/pkg/errors"
+)
+
+// OutboxConfig keeps the run reports on disk while the server is unavailable
+type OutboxConfig struct {
+	// Dir keeps the pending reports, it should survive restarts
+	Dir string
+	// InitialBackoff & MaxBackoff bound the wait between failed replays
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func (oC *OutboxConfig) IsNil() bool {
+	return oC == nil || oC.Dir == ""
+}
+
+// OutboxStats is the metrics of the outbox, see OutboxStats of the client
+type OutboxStats = outbox.Stats
+
+const (
+	outboxKindRunStart    = "function_run_start"
+	outboxKindRunProgress = "function_run_progress"
+	outboxKindRunFinished = "function_run_finished"
+	outboxKindOptPersist  = "function_run_opt_persist"
+)
+
+// outboxEntry is the payload of an outbox entry
+type outboxEntry struct {
+	TraceID  string                                   `json:"trace_id"`
+	SpanID   string                                   `json:"span_id"`
+	Start    *FuncRunStartHttpReq                     `json:"start,omitempty"`
+	Progress *FuncRunProgressHttpReq                  `json:"progress,omitempty"`
+	Finished *FuncRunFinishedHttpReq                  `json:"finished,omitempty"`
+	Persist  *FuncRunOptPersistToObjectStorageHttpReq `json:"persist,omitempty"`
+}
+
+// outboxServerClient appends the run reports to the outbox if the server
+// is unavailable, they are replayed in order once it's back. the reports are
+// appended as well while any is pending, so that they keep the order.
+//
+// an opt persisted by the outbox returns a blank object storage key,
+// which is filled into the finished report of the run when replayed.
+type outboxServerClient struct {
+	ServerClient
+	outbox *outbox.Outbox
+	// persistedKeys is the object storage keys of the replayed opt persists
+	// whose finished report is not sent or appended yet,
+	// by function run record id & opt key
+	persistedKeys map[string]map[string]string
+	// pendingFinished is the seq of the pending finished reports
+	// by function run record id
+	pendingFinished map[string]uint64
+	sync.Mutex
+}
+
+func newOutboxServerClient(
+	serverClient ServerClient, ob *outbox.Outbox,
+) *outboxServerClient {
+	oSC := &outboxServerClient{
+		ServerClient:    serverClient,
+		outbox:          ob,
+		persistedKeys:   make(map[string]map[string]string),
+		pendingFinished: make(map[string]uint64)}
+	// the finished reports left by the last run
+	err := ob.Each(func(e outbox.Entry) {
+		var entry outboxEntry
+		if e.Kind == outboxKindRunFinished &&
+			json.Unmarshal(e.Payload, &entry) == nil && entry.Finished != nil {
+			oSC.pendingFinished[entry.Finished.FunctionRunRecordID] = e.Seq
+		}
+	})
+	if err != nil {
+		log.Printf("index pending finished reports of outbox failed: %v", err)
+	}
+	go ob.Replay(context.Background(), oSC.replay)
+	return oSC
+}
+
+// send calls the server directly if nothing is pending,
+// otherwise or if the server is unavailable appends the entry
+func (oSC *outboxServerClient) send(
+	ctx context.Context, kind string, entry outboxEntry,
+	call func(ctx context.Context) error,
+) error {
+	if oSC.outbox.Pending() == 0 {
+		err := call(ctx)
+		if !IsServerUnavailable(err) {
+			return err
+		}
+	}
+	_, err := oSC.append(ctx, kind, entry)
+	return err
+}
+
+func (oSC *outboxServerClient) append(
+	ctx context.Context, kind string, entry outboxEntry,
+) (uint64, error) {
+	entry.TraceID = GetTraceIDFromContext(ctx)
+	entry.SpanID = GetSpanIDFromContext(ctx)
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	return oSC.outbox.Append(kind, payload)
+}
+
+func (oSC *outboxServerClient) ReportFuncRunStart(
+	ctx context.Context, req FuncRunStartHttpReq,
+) error {
+	return oSC.send(
+		ctx, outboxKindRunStart, outboxEntry{Start: &req},
+		func(ctx context.Context) error {
+			return oSC.ServerClient.ReportFuncRunStart(ctx, req)
+		})
+}
+
+func (oSC *outboxServerClient) ReportFuncRunProgress(
+	ctx context.Context, req FuncRunProgressHttpReq,
+) error {
+	return oSC.send(
+		ctx, outboxKindRunProgress, outboxEntry{Progress: &req},
+		func(ctx context.Context) error {
+			return oSC.ServerClient.ReportFuncRunProgress(ctx, req)
+		})
+}
+
+// ReportFuncRunFinished drops the kept keys of the run once the report is
+// sent or appended, the keys of the opt persists replayed later are filled
+// into the appended report by it's seq
+func (oSC *outboxServerClient) ReportFuncRunFinished(
+	ctx context.Context, req FuncRunFinishedHttpReq,
+) error {
+	// nothing pending means the opt persists of the run are all replayed
+	if oSC.outbox.Pending() == 0 {
+		oSC.fillPersistedKeys(&req)
+		err := oSC.ServerClient.ReportFuncRunFinished(ctx, req)
+		if !IsServerUnavailable(err) {
+			oSC.Lock()
+			delete(oSC.persistedKeys, req.FunctionRunRecordID)
+			oSC.Unlock()
+			return err
+		}
+	}
+
+	// locked so that an opt persist replayed meanwhile is either filled
+	// here or filled into the appended entry
+	oSC.Lock()
+	defer oSC.Unlock()
+	oSC.fillPersistedKeysLocked(&req)
+	delete(oSC.persistedKeys, req.FunctionRunRecordID)
+	seq, err := oSC.append(ctx, outboxKindRunFinished, outboxEntry{Finished: &req})
+	if err != nil {
+		return err
+	}
+	oSC.pendingFinished[req.FunctionRunRecordID] = seq
+	return nil
+}
+
+func (oSC *outboxServerClient) PersistFunctionRunOptField(
+	ctx context.Context, req FuncRunOptPersistToObjectStorageHttpReq,
+) (*FuncOptFieldServerPersisResp, error) {
+	var resp *FuncOptFieldServerPersisResp
+	err := oSC.send(
+		ctx, outboxKindOptPersist, outboxEntry{Persist: &req},
+		func(ctx context.Context) (err error) {
+			resp, err = oSC.ServerClient.PersistFunctionRunOptField(ctx, req)
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		// appended to the outbox, the key is unknown until replayed
+		return &FuncOptFieldServerPersisResp{}, nil
+	}
+	return resp, nil
+}
+
+// fillPersistedKeys sets the blank object storage keys of req
+// by the replayed opt persists of the run
+func (oSC *outboxServerClient) fillPersistedKeys(req *FuncRunFinishedHttpReq) bool {
+	oSC.Lock()
+	defer oSC.Unlock()
+	return oSC.fillPersistedKeysLocked(req)
+}
+
+func (oSC *outboxServerClient) fillPersistedKeysLocked(req *FuncRunFinishedHttpReq) bool {
+	filled := false
+	for optKey, key := range oSC.persistedKeys[req.FunctionRunRecordID] {
+		if req.OptKeyMapObjectStorageKey == nil {
+			req.OptKeyMapObjectStorageKey = make(map[string]string)
+		}
+		if req.OptKeyMapObjectStorageKey[optKey] == "" {
+			req.OptKeyMapObjectStorageKey[optKey] = key
+			filled = true
+		}
+	}
+	return filled
+}
+
+// persisted keeps the key of the replayed opt persist for the finished
+// report, in memory for the one not appended yet & on disk for the pending one
+func (oSC *outboxServerClient) persisted(
+	req *FuncRunOptPersistToObjectStorageHttpReq, resp *FuncOptFieldServerPersisResp,
+) error {
+	oSC.Lock()
+	defer oSC.Unlock()
+	seq, ok := oSC.pendingFinished[req.FunctionRunRecordID]
+	if !ok {
+		keys, ok := oSC.persistedKeys[req.FunctionRunRecordID]
+		if !ok {
+			keys = make(map[string]string)
+			oSC.persistedKeys[req.FunctionRunRecordID] = keys
+		}
+		keys[req.OptKey] = resp.ObjectStorageKey
+		return nil
+	}
+
+	return oSC.outbox.UpdateEntry(seq, func(e outbox.Entry) ([]byte, bool) {
+		var entry outboxEntry
+		if err := json.Unmarshal(e.Payload, &entry); err != nil || entry.Finished == nil {
+			return nil, false
+		}
+		if entry.Finished.OptKeyMapObjectStorageKey == nil {
+			entry.Finished.OptKeyMapObjectStorageKey = make(map[string]string)
+		}
+		if entry.Finished.OptKeyMapObjectStorageKey[req.OptKey] != "" {
+			return nil, false
+		}
+		entry.Finished.OptKeyMapObjectStorageKey[req.OptKey] = resp.ObjectStorageKey
+		payload, err := json.Marshal(entry)
+		return payload, err == nil
+	})
+}
+
+// replay sends the entry, only the entries rejected by the server are
+// permanently failed, others are retried
+func (oSC *outboxServerClient) replay(e outbox.Entry) error {
+	var entry outboxEntry
+	if err := json.Unmarshal(e.Payload, &entry); err != nil {
+		return outbox.Permanent(errors.Wrap(err, "unmarshal outbox entry failed"))
+	}
+	ctx := SetTraceIDAndSpanIDToContext(entry.TraceID, entry.SpanID)
+
+	var err error
+	switch {
+	case e.Kind == outboxKindRunStart && entry.Start != nil:
+		err = oSC.ServerClient.ReportFuncRunStart(ctx, *entry.Start)
+	case e.Kind == outboxKindRunProgress && entry.Progress != nil:
+		err = oSC.ServerClient.ReportFuncRunProgress(ctx, *entry.Progress)
+	case e.Kind == outboxKindRunFinished && entry.Finished != nil:
+		err = oSC.ServerClient.ReportFuncRunFinished(ctx, *entry.Finished)
+		if err == nil || rejectedByServer(err) {
+			oSC.Lock()
+			delete(oSC.pendingFinished, entry.Finished.FunctionRunRecordID)
+			oSC.Unlock()
+		}
+	case e.Kind == outboxKindOptPersist && entry.Persist != nil:
+		var resp *FuncOptFieldServerPersisResp
+		resp, err = oSC.ServerClient.PersistFunctionRunOptField(ctx, *entry.Persist)
+		if err == nil {
+			if err := oSC.persisted(entry.Persist, resp); err != nil {
+				log.Printf("keep object storage key of replayed opt persist failed: %v", err)
+			}
+		}
+	default:
+		return outbox.Permanent(errors.New("unknown outbox entry kind: " + e.Kind))
+	}
+	if rejectedByServer(err) {
+		return outbox.Permanent(err)
+	}
+	return err
+}
+
+// rejectedByServer reports whether err is the 4xx ServerError of an invalid
+// request, which fails the same when retried. 401 & 403 are excluded as
+// they are caused by the auth config of the client, which may be fixed
+func rejectedByServer(err error) bool {
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Unavailable() {
+		return false
+	}
+	status := serverErr.HTTPStatus
+	if status == http.StatusOK {
+		status = serverErr.StatusCode
+	}
+	return status >= 400 && status < 500 &&
+		status != http.StatusUnauthorized && status != http.StatusForbidden
+}
+
+// OutboxStats returns the metrics of the outbox, zero if it's not enabled
+func (bC *blocClient) OutboxStats() OutboxStats {
+	if oSC, ok := bC.ServerClient().(*outboxServerClient); ok {
+		return oSC.outbox.Stats()
+	}
+	return OutboxStats{}
+}
@@ -0,0 +1,147 @@
+package bloc_client
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestOutboxReplaysReportsAfterServerBack(t *testing.T) {
+	dir := t.TempDir()
+	client, server, _ := newMockClient(t, fastRetry, func(cb *ConfigBuilder) {
+		cb.SetOutboxConfig(OutboxConfig{
+			Dir:            dir,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     10 * time.Millisecond})
+	})
+	server.setFailure(&mockFailure{httpStatus: http.StatusServiceUnavailable})
+
+	ctx := SetTraceIDAndSpanIDToContext("trace_1", "span_1")
+	if err := client.ReportFuncRunStart(ctx, "record_1"); err != nil {
+		t.Fatalf("report should be kept by outbox, get: %v", err)
+	}
+	persistResp, err := client.PersistFunctionRunOptFieldToServerCtx(ctx, "record_1", "sum", 3)
+	if err != nil || persistResp.ObjectStorageKey != "" {
+		t.Fatalf("opt persist should be kept by outbox, get: %+v, %v", persistResp, err)
+	}
+	opt := FunctionRunOpt{
+		Suc:                    true,
+		Brief:                  map[string]string{"sum": "3"},
+		KeyMapObjectStorageKey: map[string]string{"sum": persistResp.ObjectStorageKey}}
+	if err := client.ReportFuncRunFinished(ctx, "record_1", opt); err != nil {
+		t.Fatalf("report should be kept by outbox, get: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	stats := client.OutboxStats()
+	if stats.Pending != 3 || stats.Appended != 3 || stats.ReplayFailures == 0 ||
+		stats.OldestPendingAt.IsZero() {
+		t.Fatalf("reports should be pending while server is unavailable, stats: %+v", stats)
+	}
+	select {
+	case finished := <-server.finished:
+		t.Fatalf("finished should not be reported when server is unavailable: %+v", finished)
+	default:
+	}
+
+	server.setFailure(nil)
+	finished := waitFinished(t, server)
+	if finished.FunctionRunRecordID != "record_1" || !finished.Suc ||
+		finished.OptKeyMapObjectStorageKey["sum"] != "record_1-sum" {
+		t.Fatalf("replayed finished should have the persisted key: %+v", finished)
+	}
+	if persisted := server.getPersistedOpt("record_1-sum"); persisted != float64(3) {
+		t.Errorf("opt should be persisted when replayed, get: %v", persisted)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for client.OutboxStats().Replayed < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("outbox not replayed in time, stats: %+v", client.OutboxStats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if stats := client.OutboxStats(); stats.Pending != 0 || stats.Dropped != 0 {
+		t.Errorf("unexpected stats after replayed: %+v", stats)
+	}
+
+	// reports are sent directly once nothing is pending
+	if err := client.ReportFuncRunFinished(ctx, "record_2", FunctionRunOpt{Suc: true}); err != nil {
+		t.Fatal(err)
+	}
+	if finished := waitFinished(t, server); finished.FunctionRunRecordID != "record_2" {
+		t.Errorf("unexpected finished: %+v", finished)
+	}
+	if stats := client.OutboxStats(); stats.Appended != 3 {
+		t.Errorf("report should not be appended when server is available, stats: %+v", stats)
+	}
+}
+
+func TestOutboxKeepsNoKeysOfReportedRuns(t *testing.T) {
+	client, server, eventMQ := newMockClient(t, fastRetry, func(cb *ConfigBuilder) {
+		cb.SetOutboxConfig(OutboxConfig{
+			Dir:            t.TempDir(),
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     10 * time.Millisecond})
+	})
+	runSumFunction(t, client, server, eventMQ)
+	oSC := client.ServerClient().(*outboxServerClient)
+	keptKeys := func() int {
+		oSC.Lock()
+		defer oSC.Unlock()
+		return len(oSC.persistedKeys) + len(oSC.pendingFinished)
+	}
+	if kept := keptKeys(); kept != 0 {
+		t.Fatalf("nothing should be kept after a normal run, kept %d", kept)
+	}
+
+	// the opt persist is replayed, then the finished report is sent directly
+	server.setFailure(&mockFailure{httpStatus: http.StatusServiceUnavailable})
+	ctx := SetTraceIDAndSpanIDToContext("trace_2", "span_2")
+	if _, err := client.PersistFunctionRunOptFieldToServerCtx(ctx, "record_2", "sum", 3); err != nil {
+		t.Fatal(err)
+	}
+	server.setFailure(nil)
+	deadline := time.Now().Add(time.Second)
+	for client.OutboxStats().Pending != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("outbox not replayed in time, stats: %+v", client.OutboxStats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := client.ReportFuncRunFinished(ctx, "record_2", FunctionRunOpt{Suc: true}); err != nil {
+		t.Fatal(err)
+	}
+	if finished := waitFinished(t, server); finished.OptKeyMapObjectStorageKey["sum"] != "record_2-sum" {
+		t.Fatalf("finished should have the replayed key: %+v", finished)
+	}
+	if kept := keptKeys(); kept != 0 {
+		t.Errorf("nothing should be kept after the finished report is sent, kept %d", kept)
+	}
+}
+
+func TestOutboxNotEnabled(t *testing.T) {
+	client, _, _ := newMockClient(t)
+	if stats := client.OutboxStats(); stats != (OutboxStats{}) {
+		t.Errorf("stats should be zero if outbox not enabled: %+v", stats)
+	}
+}
+
+func TestOutboxRejectedByServer(t *testing.T) {
+	cases := map[*ServerError]bool{
+		{HTTPStatus: http.StatusBadRequest}:                                true,
+		{HTTPStatus: http.StatusOK, StatusCode: http.StatusBadRequest}:     true,
+		{HTTPStatus: http.StatusUnauthorized}:                              false,
+		{HTTPStatus: http.StatusOK, StatusCode: http.StatusForbidden}:      false,
+		{HTTPStatus: http.StatusTooManyRequests}:                           false,
+		{HTTPStatus: http.StatusBadGateway}:                                false,
+		{Err: errors.New("x509: certificate signed by unknown authority")}: false,
+	}
+	for serverErr, rejected := range cases {
+		if rejectedByServer(serverErr) != rejected {
+			t.Errorf("%v should be rejected: %v", serverErr, rejected)
+		}
+	}
+}
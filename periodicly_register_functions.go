@@ -1,6 +1,7 @@
 package bloc_client
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/fBloc/bloc-client-go/internal/http_util"
@@ -16,6 +17,9 @@ type HttpReqFunction struct {
 	Ipts               []*Ipt   `json:"ipts"`
 	Opts               []*Opt   `json:"opts"`
 	ProgressMilestones []string `json:"progress_milestones"`
+	// RoutingKey is the topic runs of the function should be published to,
+	// empty means the client's topic
+	RoutingKey string `json:"routing_key,omitempty"`
 }
 
 type HttpRespFunction struct {
@@ -25,54 +29,82 @@ type HttpRespFunction struct {
 	ErrorMsg  string `json:"error_msg"`
 }
 
+type GroupNameMapRespFunctions map[string][]*HttpRespFunction
+
 type RegisterFuncResp struct {
 	StatusCode int    `json:"status_code"`
 	StatusMsg  string `json:"status_msg"`
 	Data       struct {
-		GroupNameMapFunctions map[string][]*HttpRespFunction `json:"groupName_map_functions"`
+		GroupNameMapFunctions GroupNameMapRespFunctions `json:"groupName_map_functions"`
 	} `json:"data"`
 }
 
+func (resp *RegisterFuncResp) serverStatus() (int, string) {
+	return resp.StatusCode, resp.StatusMsg
+}
+
 type RegisterFuncReq struct {
 	Who                   string                        `json:"who"`
 	GroupNameMapFunctions map[string][]*HttpReqFunction `json:"groupName_map_functions"`
 }
 type GroupNameMapFunctions map[string][]*HttpReqFunction
 
+func (hSC *httpServerClient) RegisterFunctions(
+	ctx context.Context, req RegisterFuncReq,
+) (GroupNameMapRespFunctions, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp RegisterFuncResp
+	err = hSC.client.PostJsonIdempotent(
+		ctx, hSC.apiPath(registerFuncPath),
+		http_util.BlankHeader, body, &resp)
+	if err := checkResp(registerFuncPath, err, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data.GroupNameMapFunctions, nil
+}
+
+// RegisterFunctionsToServer registers the subscribed functions only
 func (bC *blocClient) RegisterFunctionsToServer() error {
+	return bC.RegisterFunctionsToServerWithContext(context.Background())
+}
+
+func (bC *blocClient) RegisterFunctionsToServerWithContext(ctx context.Context) error {
+	if err := bC.checkSubscriptions(); err != nil {
+		return err
+	}
 	req := RegisterFuncReq{
 		Who:                   bC.Name,
 		GroupNameMapFunctions: make(map[string][]*HttpReqFunction)}
 
 	for _, funcGroup := range bC.FunctionGroups {
 		groupName := funcGroup.Name
-		req.GroupNameMapFunctions[groupName] = make(
-			[]*HttpReqFunction, len(funcGroup.Functions))
-		for i, function := range funcGroup.Functions {
-			req.GroupNameMapFunctions[groupName][i] = &HttpReqFunction{
-				Name:               function.Name,
-				GroupName:          function.GroupName,
-				Description:        function.Description,
-				Ipts:               function.Ipts,
-				Opts:               function.Opts,
-				ProgressMilestones: function.ProgressMilestones,
+		for _, function := range funcGroup.Functions {
+			if !bC.isSubscribed(function) {
+				continue
 			}
+			req.GroupNameMapFunctions[groupName] = append(
+				req.GroupNameMapFunctions[groupName],
+				&HttpReqFunction{
+					Name:               function.Name,
+					GroupName:          function.GroupName,
+					Description:        function.Description,
+					Ipts:               function.Ipts,
+					Opts:               function.Opts,
+					ProgressMilestones: function.ProgressMilestones,
+					RoutingKey:         bC.routingKeyOf(function),
+				})
 		}
 	}
 
-	body, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
-
-	var resp RegisterFuncResp
-	err = http_util.PostJson(
-		bC.GenReqServerPath(registerFuncPath),
-		http_util.BlankHeader, body, &resp)
+	groupNameMapRespFunctions, err := bC.ServerClient().RegisterFunctions(ctx, req)
 	if err == nil {
 		for _, funcGroup := range bC.FunctionGroups {
 			groupName := funcGroup.Name
-			respFunctions := resp.Data.GroupNameMapFunctions[groupName]
+			respFunctions := groupNameMapRespFunctions[groupName]
 			nameMapRespFunc := make(map[string]*HttpRespFunction, len(respFunctions))
 			for _, f := range respFunctions {
 				if f.ErrorMsg != "" {
@@ -82,7 +114,9 @@ func (bC *blocClient) RegisterFunctionsToServer() error {
 			}
 
 			for _, function := range funcGroup.Functions {
-				function.ID = nameMapRespFunc[function.Name].ID
+				if respFunc, ok := nameMapRespFunc[function.Name]; ok {
+					function.ID = respFunc.ID
+				}
 			}
 		}
 	}
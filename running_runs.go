@@ -0,0 +1,84 @@
+package bloc_client
+
+import (
+	"log"
+	"sync"
+
+	"github.com/fBloc/bloc-client-go/internal/event"
+)
+
+// runningRuns keeps the cancel channels of the function runs running in
+// this replica, so a broadcast cancel event can stop the one it targets
+type runningRuns struct {
+	cancels map[string]chan string
+	sync.Mutex
+}
+
+// add returns the channel the cancel reason is sent to
+func (rR *runningRuns) add(functionRunRecordID string) chan string {
+	rR.Lock()
+	defer rR.Unlock()
+	if rR.cancels == nil {
+		rR.cancels = make(map[string]chan string)
+	}
+	cancelChan := make(chan string, 1)
+	rR.cancels[functionRunRecordID] = cancelChan
+	return cancelChan
+}
+
+func (rR *runningRuns) remove(functionRunRecordID string) {
+	rR.Lock()
+	defer rR.Unlock()
+	delete(rR.cancels, functionRunRecordID)
+}
+
+// cancel returns false if the run is not running in this replica
+func (rR *runningRuns) cancel(functionRunRecordID, reason string) bool {
+	rR.Lock()
+	defer rR.Unlock()
+	cancelChan, ok := rR.cancels[functionRunRecordID]
+	if !ok {
+		return false
+	}
+	select {
+	case cancelChan <- reason:
+	default: // already canceled
+	}
+	return true
+}
+
+// listenBroadcastEvents handles the events every replica receives.
+// without broadcast support of the event mq, canceled runs are still
+// found by polling the server
+func (bC *blocClient) listenBroadcastEvents() {
+	broadcastEventChan := make(chan event.DomainEvent)
+	for _, e := range []event.DomainEvent{
+		&event.FunctionRunCancel{ClientName: bC.Name},
+		&event.ClientConfigChanged{ClientName: bC.Name},
+	} {
+		if err := event.ListenBroadcastEvent(e, broadcastEventChan); err != nil {
+			log.Printf("listen %s event failed: %v", e.Type(), err)
+		}
+	}
+
+	go func() {
+		for e := range broadcastEventChan {
+			switch e := e.(type) {
+			case *event.FunctionRunCancel:
+				bC.runningRuns.cancel(e.FunctionRunRecordID, e.Reason)
+			case *event.ClientConfigChanged:
+				for _, callback := range bC.configChangedCallbacks() {
+					callback(e.Config)
+				}
+			}
+			event.AckEvent(e)
+		}
+	}()
+}
+
+func (bC *blocClient) configChangedCallbacks() []func(map[string]string) {
+	if bC.configBuilder == nil {
+		return nil
+	}
+	return bC.configBuilder.ConfigChangedCallbacks
+}
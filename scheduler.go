@@ -0,0 +1,129 @@
+package bloc_client
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/fBloc/bloc-client-go/internal/event"
+)
+
+type scheduledRun struct {
+	event    event.DomainEvent
+	priority uint8
+	seq      uint64
+}
+
+// runHeap pops the highest priority run, the earliest one of the same priority
+type runHeap []*scheduledRun
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) {
+	*h = append(*h, x.(*scheduledRun))
+}
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	run := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return run
+}
+
+// runScheduler runs the submitted function runs by a fixed amount of workers.
+// when all workers are busy, waiting runs are picked by priority.
+// submit blocks while capacity runs are waiting.
+type runScheduler struct {
+	runs     runHeap
+	capacity int
+	seq      uint64
+	cond     *sync.Cond
+	notFull  *sync.Cond
+	stopped  bool
+	sync.Mutex
+}
+
+// newRunScheduler runs by concurrency workers, capacity <= 0 means concurrency
+func newRunScheduler(concurrency, capacity int, run func(event.DomainEvent)) *runScheduler {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if capacity <= 0 {
+		capacity = concurrency
+	}
+	s := &runScheduler{capacity: capacity}
+	s.cond = sync.NewCond(&s.Mutex)
+	s.notFull = sync.NewCond(&s.Mutex)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			for {
+				r, ok := s.next()
+				if !ok {
+					return
+				}
+				run(r.event)
+			}
+		}()
+	}
+	return s
+}
+
+// submit blocks until the run is waiting or the scheduler is stopped,
+// the run is dropped if stopped
+func (s *runScheduler) submit(e event.DomainEvent, priority uint8) {
+	s.Lock()
+	defer s.Unlock()
+	for len(s.runs) >= s.capacity && !s.stopped {
+		s.notFull.Wait()
+	}
+	if s.stopped {
+		return
+	}
+	s.seq++
+	heap.Push(&s.runs, &scheduledRun{event: e, priority: priority, seq: s.seq})
+	s.cond.Signal()
+}
+
+// next blocks until a run is waiting, ok is false after stopped
+func (s *runScheduler) next() (*scheduledRun, bool) {
+	s.Lock()
+	defer s.Unlock()
+	for len(s.runs) == 0 && !s.stopped {
+		s.cond.Wait()
+	}
+	if s.stopped {
+		return nil, false
+	}
+	s.notFull.Signal()
+	return heap.Pop(&s.runs).(*scheduledRun), true
+}
+
+// waiting returns the amount of runs waiting for a worker
+func (s *runScheduler) waiting() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.runs)
+}
+
+// stop makes workers exit after their current runs, waiting runs are dropped
+func (s *runScheduler) stop() {
+	s.Lock()
+	defer s.Unlock()
+	s.stopped = true
+	s.cond.Broadcast()
+	s.notFull.Broadcast()
+}
+
+// runPriority is the priority of ClientRunFunction, 0 for other events
+func runPriority(e event.DomainEvent) uint8 {
+	if runEvent, ok := e.(*event.ClientRunFunction); ok {
+		return runEvent.RunPriority()
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+package bloc_client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/event"
+)
+
+func TestRunSchedulerPriority(t *testing.T) {
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	scheduler := newRunScheduler(1, 3, func(e event.DomainEvent) {
+		started <- e.Identity()
+		<-release
+	})
+	defer scheduler.stop()
+
+	// occupies the only worker
+	scheduler.submit(&event.ClientRunFunction{FunctionRunRecordID: "running"}, 0)
+	if id := <-started; id != "running" {
+		t.Fatalf("unexpected run: %s", id)
+	}
+	scheduler.submit(&event.ClientRunFunction{FunctionRunRecordID: "batch_1"}, 0)
+	scheduler.submit(&event.ClientRunFunction{FunctionRunRecordID: "batch_2"}, 0)
+	scheduler.submit(&event.ClientRunFunction{FunctionRunRecordID: "interactive"}, 9)
+	if scheduler.waiting() != 3 {
+		t.Fatalf("should have 3 waiting runs, get %d", scheduler.waiting())
+	}
+
+	for _, expected := range []string{"interactive", "batch_1", "batch_2"} {
+		release <- struct{}{}
+		select {
+		case id := <-started:
+			if id != expected {
+				t.Fatalf("%s should run before %s", expected, id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("wait %s to run timeout", expected)
+		}
+	}
+	release <- struct{}{}
+}
+
+func TestRunSchedulerConcurrency(t *testing.T) {
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	scheduler := newRunScheduler(2, 0, func(e event.DomainEvent) {
+		started <- e.Identity()
+		<-release
+	})
+	defer scheduler.stop()
+
+	for _, id := range []string{"a", "b", "c"} {
+		scheduler.submit(&event.ClientRunFunction{FunctionRunRecordID: id}, 0)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("2 runs should run at the same time")
+		}
+	}
+	select {
+	case id := <-started:
+		t.Fatalf("run %s should wait for a free worker", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	<-started
+}
+
+func TestRunSchedulerSubmitBlocksWhenFull(t *testing.T) {
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	scheduler := newRunScheduler(1, 1, func(e event.DomainEvent) {
+		started <- e.Identity()
+		<-release
+	})
+	defer scheduler.stop()
+
+	scheduler.submit(&event.ClientRunFunction{FunctionRunRecordID: "running"}, 0)
+	<-started
+	scheduler.submit(&event.ClientRunFunction{FunctionRunRecordID: "waiting"}, 0)
+
+	submitted := make(chan struct{})
+	go func() {
+		scheduler.submit(&event.ClientRunFunction{FunctionRunRecordID: "blocked"}, 0)
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("submit should block while the scheduler is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release <- struct{}{}
+	select {
+	case <-submitted:
+	case <-time.After(time.Second):
+		t.Fatal("submit should return once a waiting run is picked")
+	}
+	close(release)
+}
@@ -0,0 +1,61 @@
+package bloc_client
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/http_util"
+	"github.com/pkg/errors"
+)
+
+// runWithAuth runs a function by the client authenticated by auth
+func runWithAuth(t *testing.T, server *mockServer, auth ServerAuthenticator) {
+	t.Helper()
+	eventMQ := NewMemoryMsgQueue()
+	t.Cleanup(eventMQ.Close)
+	client := NewClient(mockClientName)
+	ip, port := server.ipAndPort()
+	client.GetConfigBuilder().SetServer(ip, port, WithServerAuth(auth)).
+		SetEventMQ(eventMQ).BuildUp()
+	client.RegisterFunctionGroup("math").AddFunction("sum", "sum numbers", &sumFunction{})
+	if err := client.RegisterFunctionsToServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	server.setObjectStorageValue("numbers_key", []int{1, 2})
+	server.addFunctionRunRecord(&FunctionRunRecord{
+		ID:         "record_1",
+		FunctionID: "math-sum",
+		IptBriefAndObjectStoragekey: [][]briefAndKey{
+			{{ObjectStorageKey: "numbers_key"}}},
+	})
+	publishClientRunFunction(t, eventMQ, "record_1")
+	go client.FunctionRunConsumer()
+
+	if finished := waitFinished(t, server); !finished.Suc {
+		t.Fatalf("function run should suc: %+v", finished)
+	}
+	waitAllAcked(t, eventMQ)
+}
+
+func TestBearerTokenAuth(t *testing.T) {
+	server := newMockServer(t)
+	server.authenticate = func(r *http.Request, body []byte) error {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			return errors.New("lack bearer token")
+		}
+		return nil
+	}
+	runWithAuth(t, server, BearerTokenAuth("token"))
+}
+
+func TestHMACAuth(t *testing.T) {
+	secret := []byte("secret")
+	server := newMockServer(t)
+	server.authenticate = func(r *http.Request, body []byte) error {
+		signer := http_util.HMACSigner{ClientName: mockClientName, Secret: secret}
+		return signer.Verify(r, body, time.Minute)
+	}
+	runWithAuth(t, server, HMACAuth(mockClientName, secret))
+}
@@ -0,0 +1,120 @@
+package bloc_client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/fBloc/bloc-client-go/internal/http_util"
+)
+
+// ServerClient is every call the client makes to bloc-server.
+// the default one talks to the server set by ConfigBuilder.SetServer,
+// inject another by ConfigBuilder.SetServerClient to mock, proxy or wrap it.
+type ServerClient interface {
+	RegisterFunctions(ctx context.Context, req RegisterFuncReq) (GroupNameMapRespFunctions, error)
+	GetFunctionRunRecord(ctx context.Context, functionRunRecordID string) (*FunctionRunRecord, error)
+	ReportFuncRunStart(ctx context.Context, req FuncRunStartHttpReq) error
+	ReportFuncRunProgress(ctx context.Context, req FuncRunProgressHttpReq) error
+	ReportFuncRunFinished(ctx context.Context, req FuncRunFinishedHttpReq) error
+	PersistFunctionRunOptField(ctx context.Context, req FuncRunOptPersistToObjectStorageHttpReq) (*FuncOptFieldServerPersisResp, error)
+	FlowRunIsCanceled(ctx context.Context, flowRunRecordID string) (bool, error)
+	// FetchObjectStorageData returns the data kept by key & it's compress encoding
+	FetchObjectStorageData(ctx context.Context, key string) ([]byte, CompressEncoding, error)
+	UploadLogs(ctx context.Context, logs []*LogMsg) error
+}
+
+func init() {
+	var _ ServerClient = &httpServerClient{}
+}
+
+// httpServerClient is the ServerClient of bloc-server's http api
+type httpServerClient struct {
+	client *http_util.Client
+	// compressUnsupported is set to 1 once bloc-server does not echo the
+	// encoding of a compressed opt
+	compressUnsupported int32
+}
+
+// NewHTTPServerClient returns the ServerClient talking to bloc-server at
+// serverAddr(`ip:port` or the base url) by httpClient, a default one is used
+// if it's nil. with WithServerTLS the server is called by https://,
+// with WithServerStreaming progress & logs are streamed
+func NewHTTPServerClient(
+	serverAddr string, httpClient *http.Client,
+	options ...ServerOption,
+) (ServerClient, error) {
+	serverConf := &BlocServerConfig{URLs: []string{serverAddr}}
+	for _, option := range options {
+		option(serverConf)
+	}
+	endpoints, err := serverConf.endpoints()
+	if err != nil {
+		return nil, err
+	}
+	if serverConf.Streaming != nil && !http_util.CanAuthenticateStream(serverConf.Auth) {
+		return nil, errStreamingNotAuthenticated
+	}
+	if serverConf.TLS != nil {
+		httpClient, err = http_util.WithTLS(httpClient, serverConf.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+	hSC := newHTTPServerClient(endpoints, httpClient, serverConf)
+	if serverConf.Streaming != nil {
+		return newStreamServerClient(hSC, *serverConf.Streaming), nil
+	}
+	return hSC, nil
+}
+
+// newHTTPServerClient calls the client api at endpoints
+func newHTTPServerClient(
+	endpoints []*url.URL, httpClient *http.Client, serverConf *BlocServerConfig,
+) *httpServerClient {
+	return &httpServerClient{
+		client: http_util.New(httpClient, serverConf.httpConfig(endpoints))}
+}
+
+// apiPath is relative to the client api of the server endpoints
+func (hSC *httpServerClient) apiPath(subPaths ...string) string {
+	return path.Join(subPaths...)
+}
+
+// traceHeader passes the trace of ctx to bloc-server
+func traceHeader(ctx context.Context) map[string]string {
+	return map[string]string{
+		string(TraceID): GetTraceIDFromContext(ctx),
+		string(SpanID):  GetSpanIDFromContext(ctx)}
+}
+
+// ServerClient returns the injected ServerClient, or the http one
+// talking to the configured server, streaming if it's set.
+// wrapped by the outbox if it's set
+func (bC *blocClient) ServerClient() ServerClient {
+	bC.Lock()
+	defer bC.Unlock()
+	if bC.serverClient != nil {
+		return bC.serverClient
+	}
+	if bC.configBuilder.ServerClient != nil {
+		bC.serverClient = bC.configBuilder.ServerClient
+	} else {
+		endpoints, err := bC.configBuilder.ServerConf.endpoints()
+		if err != nil {
+			panic(fmt.Sprintf("invalid bloc-server address: %v", err))
+		}
+		hSC := newHTTPServerClient(
+			endpoints, bC.configBuilder.HTTPClient, bC.configBuilder.ServerConf)
+		bC.serverClient = hSC
+		if bC.configBuilder.ServerConf.Streaming != nil {
+			bC.serverClient = newStreamServerClient(hSC, *bC.configBuilder.ServerConf.Streaming)
+		}
+	}
+	if bC.configBuilder.outbox != nil {
+		bC.serverClient = newOutboxServerClient(bC.serverClient, bC.configBuilder.outbox)
+	}
+	return bC.serverClient
+}
@@ -0,0 +1,141 @@
+package bloc_client
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/compress"
+)
+
+// fakeServerClient serves the function run record in memory
+type fakeServerClient struct {
+	record   *FunctionRunRecord
+	finished chan FuncRunFinishedHttpReq
+	logs     int32
+}
+
+func (f *fakeServerClient) RegisterFunctions(
+	ctx context.Context, req RegisterFuncReq,
+) (GroupNameMapRespFunctions, error) {
+	resp := make(GroupNameMapRespFunctions, len(req.GroupNameMapFunctions))
+	for groupName, functions := range req.GroupNameMapFunctions {
+		for _, function := range functions {
+			resp[groupName] = append(resp[groupName], &HttpRespFunction{
+				ID: groupName + "-" + function.Name, Name: function.Name, GroupName: groupName})
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeServerClient) GetFunctionRunRecord(
+	ctx context.Context, functionRunRecordID string,
+) (*FunctionRunRecord, error) {
+	return f.record, nil
+}
+
+func (f *fakeServerClient) ReportFuncRunStart(ctx context.Context, req FuncRunStartHttpReq) error {
+	return nil
+}
+
+func (f *fakeServerClient) ReportFuncRunProgress(ctx context.Context, req FuncRunProgressHttpReq) error {
+	return nil
+}
+
+func (f *fakeServerClient) ReportFuncRunFinished(ctx context.Context, req FuncRunFinishedHttpReq) error {
+	f.finished <- req
+	return nil
+}
+
+func (f *fakeServerClient) PersistFunctionRunOptField(
+	ctx context.Context, req FuncRunOptPersistToObjectStorageHttpReq,
+) (*FuncOptFieldServerPersisResp, error) {
+	return &FuncOptFieldServerPersisResp{ObjectStorageKey: req.OptKey}, nil
+}
+
+func (f *fakeServerClient) FlowRunIsCanceled(ctx context.Context, flowRunRecordID string) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeServerClient) FetchObjectStorageData(
+	ctx context.Context, key string,
+) ([]byte, CompressEncoding, error) {
+	return []byte("[4, 5]"), compress.Identity, nil
+}
+
+func (f *fakeServerClient) UploadLogs(ctx context.Context, logs []*LogMsg) error {
+	atomic.AddInt32(&f.logs, int32(len(logs)))
+	return nil
+}
+
+func TestInjectedServerClient(t *testing.T) {
+	serverClient := &fakeServerClient{
+		record: &FunctionRunRecord{
+			ID:         "record_1",
+			FunctionID: "math-sum",
+			IptBriefAndObjectStoragekey: [][]briefAndKey{
+				{{ObjectStorageKey: "numbers_key"}}}},
+		finished: make(chan FuncRunFinishedHttpReq, 1)}
+	eventMQ := NewMemoryMsgQueue()
+	t.Cleanup(eventMQ.Close)
+
+	client := NewClient(mockClientName)
+	client.GetConfigBuilder().SetServerClient(serverClient).SetEventMQ(eventMQ).BuildUp()
+	client.RegisterFunctionGroup("math").AddFunction("sum", "sum numbers", &sumFunction{})
+	if err := client.RegisterFunctionsToServer(); err != nil {
+		t.Fatal(err)
+	}
+	publishClientRunFunction(t, eventMQ, "record_1")
+
+	go client.FunctionRunConsumer()
+
+	select {
+	case finished := <-serverClient.finished:
+		if !finished.Suc || finished.OptKeyMapBriefData["sum"] != "9" {
+			t.Fatalf("function run should suc by the injected server client: %+v", finished)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait function run finished timeout")
+	}
+	// logs are uploaded asynchronously
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&serverClient.logs) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("logs should be uploaded by the injected server client")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type countingTransport struct {
+	requests int32
+}
+
+func (cT *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&cT.requests, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHTTPClient(t *testing.T) {
+	server := newMockServer(t)
+	transport := &countingTransport{}
+	client := NewClient(mockClientName)
+	ip, port := server.ipAndPort()
+	client.GetConfigBuilder().SetServer(ip, port).SetEventMQ(NewMemoryMsgQueue()).
+		SetHTTPClient(&http.Client{Transport: transport}).BuildUp()
+	client.RegisterFunctionGroup("math").AddFunction("sum", "sum numbers", &sumFunction{})
+	if err := client.RegisterFunctionsToServer(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&transport.requests) != 1 {
+		t.Errorf("server api should be called by the provided http client")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.GetFunctionRunRecordByIDCtx(ctx, "record_1"); err == nil {
+		t.Error("call with canceled context should fail")
+	}
+}
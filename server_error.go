@@ -0,0 +1,113 @@
+package bloc_client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/fBloc/bloc-client-go/internal/http_util"
+	"github.com/pkg/errors"
+)
+
+// ServerError is returned by every failed call of the http ServerClient
+type ServerError struct {
+	// Endpoint is the api path called, e.g. get_function_run_record_by_id
+	Endpoint string
+	// HTTPStatus is 0 if the server is not reached or no response is got
+	HTTPStatus int
+	// StatusCode & StatusMsg are from the response body if it has them
+	StatusCode int
+	StatusMsg  string
+	// Err is the cause if no valid response is got
+	Err error
+}
+
+func (sE *ServerError) Error() string {
+	if sE.Err != nil {
+		return fmt.Sprintf("call bloc-server %s failed: %v", sE.Endpoint, sE.Err)
+	}
+	return fmt.Sprintf(
+		"call bloc-server %s failed. http status: %d, status_code: %d, status_msg: %s",
+		sE.Endpoint, sE.HTTPStatus, sE.StatusCode, sE.StatusMsg)
+}
+
+func (sE *ServerError) Unwrap() error {
+	return sE.Err
+}
+
+func (sE *ServerError) NotFound() bool {
+	return sE.HTTPStatus == http.StatusNotFound || sE.StatusCode == http.StatusNotFound
+}
+
+// Unavailable reports whether the server is not reachable, does not respond
+// or fails by itself, so the same call may succeed later. a call failed by
+// the caller's ctx, building or authenticating the request is not
+func (sE *ServerError) Unavailable() bool {
+	if sE.HTTPStatus >= http.StatusInternalServerError ||
+		sE.HTTPStatus == http.StatusTooManyRequests ||
+		sE.StatusCode >= http.StatusInternalServerError {
+		return true
+	}
+	// not reached, or no response got
+	return sE.HTTPStatus == 0 && http_util.Unreachable(sE.Err)
+}
+
+func isDecodeError(err error) bool {
+	var (
+		syntaxErr    *json.SyntaxError
+		unmarshalErr *json.UnmarshalTypeError
+	)
+	return errors.As(err, &syntaxErr) || errors.As(err, &unmarshalErr)
+}
+
+// IsNotFound reports whether err is the ServerError of a not found resource
+func IsNotFound(err error) bool {
+	var serverErr *ServerError
+	return errors.As(err, &serverErr) && serverErr.NotFound()
+}
+
+// IsServerUnavailable reports whether err is the ServerError of an unavailable server
+func IsServerUnavailable(err error) bool {
+	var serverErr *ServerError
+	return errors.As(err, &serverErr) && serverErr.Unavailable()
+}
+
+// serverStatusResp is the response body carrying the status of bloc-server
+type serverStatusResp interface {
+	serverStatus() (statusCode int, statusMsg string)
+}
+
+func (resp *HttpResp) serverStatus() (int, string) {
+	return resp.Code, resp.Msg
+}
+
+// checkResp converts the result of calling endpoint to ServerError.
+// a 2xx response is still failed if the status_code of the body is not 2xx
+func checkResp(endpoint string, err error, resp serverStatusResp) error {
+	if err == nil {
+		statusCode, statusMsg := resp.serverStatus()
+		if statusCode == 0 || (statusCode >= 200 && statusCode < 300) {
+			return nil
+		}
+		return &ServerError{
+			Endpoint:   endpoint,
+			HTTPStatus: http.StatusOK,
+			StatusCode: statusCode,
+			StatusMsg:  statusMsg}
+	}
+
+	if isDecodeError(err) {
+		// only the body of 2xx responses is decoded
+		return &ServerError{Endpoint: endpoint, HTTPStatus: http.StatusOK, Err: err}
+	}
+	var statusErr *http_util.StatusError
+	if !errors.As(err, &statusErr) {
+		return &ServerError{Endpoint: endpoint, Err: err}
+	}
+	serverErr := &ServerError{Endpoint: endpoint, HTTPStatus: statusErr.StatusCode}
+	var body HttpResp
+	if json.Unmarshal(statusErr.Body, &body) == nil {
+		serverErr.StatusCode, serverErr.StatusMsg = body.serverStatus()
+	}
+	return serverErr
+}
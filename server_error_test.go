@@ -0,0 +1,129 @@
+package bloc_client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fastRetry keeps the tests of unavailable server quick
+func fastRetry(cb *ConfigBuilder) {
+	policy := ServerRetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+	cb.ServerConf.Retry = &policy
+	cb.ServerConf.NonIdempotentRetry = &policy
+	cb.ServerConf.CircuitBreaker = &ServerCircuitBreakerConfig{
+		FailureThreshold: 100, OpenTimeout: time.Millisecond}
+}
+
+func TestServerErrorNotFound(t *testing.T) {
+	client, _, _ := newMockClient(t, fastRetry)
+
+	_, err := client.GetFunctionRunRecordByID("not_exist_record")
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) {
+		t.Fatalf("should return ServerError, get: %v", err)
+	}
+	if !IsNotFound(err) || IsServerUnavailable(err) ||
+		serverErr.Endpoint != functionRecordPath || serverErr.HTTPStatus != http.StatusNotFound {
+		t.Errorf("unexpected server error: %+v", serverErr)
+	}
+}
+
+func TestServerErrorStatusInBody(t *testing.T) {
+	client, server, _ := newMockClient(t, fastRetry)
+	server.setFailure(&mockFailure{
+		httpStatus: http.StatusOK,
+		statusCode: http.StatusBadRequest,
+		statusMsg:  "invalid function run record id"})
+
+	err := client.ReportFuncRunStart(context.Background(), "record_1")
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) {
+		t.Fatalf("error status in body should fail, get: %v", err)
+	}
+	if serverErr.StatusCode != http.StatusBadRequest ||
+		serverErr.StatusMsg != "invalid function run record id" ||
+		serverErr.Endpoint != FuncRunStartHttpPath || IsServerUnavailable(err) {
+		t.Errorf("unexpected server error: %+v", serverErr)
+	}
+}
+
+func TestServerErrorUnavailable(t *testing.T) {
+	client, server, _ := newMockClient(t, fastRetry)
+	server.setFailure(&mockFailure{
+		httpStatus: http.StatusServiceUnavailable,
+		statusCode: http.StatusServiceUnavailable,
+		statusMsg:  "maintaining"})
+	_, err := client.FlowRunIsCanceled("flow_run_1")
+	if !IsServerUnavailable(err) || IsNotFound(err) {
+		t.Errorf("503 should be unavailable, get: %v", err)
+	}
+
+	server.Close()
+	_, err = client.FlowRunIsCanceled("flow_run_1")
+	if !IsServerUnavailable(err) {
+		t.Errorf("unreachable server should be unavailable, get: %v", err)
+	}
+}
+
+func TestFunctionRunRequeuedWhenServerUnavailable(t *testing.T) {
+	defer func(delay time.Duration) { serverUnavailableRequeueDelay = delay }(serverUnavailableRequeueDelay)
+	serverUnavailableRequeueDelay = 10 * time.Millisecond
+
+	client, server, eventMQ := newMockClient(t, fastRetry)
+	server.setObjectStorageValue("numbers_key", []int{1, 2})
+	server.addFunctionRunRecord(&FunctionRunRecord{
+		ID:         "record_1",
+		FunctionID: "math-sum",
+		IptBriefAndObjectStoragekey: [][]briefAndKey{
+			{{ObjectStorageKey: "numbers_key"}}},
+	})
+	server.setFailure(&mockFailure{httpStatus: http.StatusBadGateway})
+	publishClientRunFunction(t, eventMQ, "record_1")
+
+	go client.FunctionRunConsumer()
+
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case finished := <-server.finished:
+		t.Fatalf("run should not be finished when server is unavailable: %+v", finished)
+	default:
+	}
+	server.setFailure(nil)
+
+	if finished := waitFinished(t, server); finished.FunctionRunRecordID != "record_1" || !finished.Suc {
+		t.Fatalf("requeued run should suc after server is back: %+v", finished)
+	}
+	waitAllAcked(t, eventMQ)
+}
+
+// failingSigner fails to sign every request
+type failingSigner struct{}
+
+func (failingSigner) Authenticate(req *http.Request, body []byte) error {
+	return errors.New("signing key not loaded")
+}
+
+func TestServerErrorNotUnavailable(t *testing.T) {
+	client, _, _ := newMockClient(t, fastRetry)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.FlowRunIsCanceledCtx(ctx, "flow_run_1")
+	if err == nil || IsServerUnavailable(err) {
+		t.Errorf("call canceled by the caller should not be unavailable, get: %v", err)
+	}
+
+	ip, port := newMockServer(t).ipAndPort()
+	client = NewClient(mockClientName)
+	configBuilder := client.GetConfigBuilder().
+		SetServer(ip, port, WithServerAuth(failingSigner{})).SetEventMQ(NewMemoryMsgQueue())
+	fastRetry(configBuilder)
+	configBuilder.BuildUp()
+	_, err = client.FlowRunIsCanceled("flow_run_1")
+	if err == nil || IsServerUnavailable(err) {
+		t.Errorf("call failed by signing should not be unavailable, get: %v", err)
+	}
+}
@@ -1,9 +1,16 @@
 package bloc_client
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"log"
+	"sync/atomic"
 
+	"github.com/fBloc/bloc-client-go/internal/compress"
 	"github.com/fBloc/bloc-client-go/internal/http_util"
+
+	"github.com/pkg/errors"
 )
 
 const serverFuncRunOptPersistToObjectStoragePath = "persist_certain_function_run_opt_field"
@@ -12,37 +19,126 @@ type FuncRunOptPersistToObjectStorageHttpReq struct {
 	FunctionRunRecordID string      `json:"function_run_record_id"`
 	OptKey              string      `json:"opt_key"`
 	Data                interface{} `json:"data"`
+	// Encoding is set when Data is the compressed json of the opt value,
+	// which needs a bloc-server knowing it, see FuncOptFieldServerPersisResp
+	Encoding CompressEncoding `json:"encoding,omitempty"`
 }
 
 type FuncOptFieldServerPersisResp struct {
 	ObjectStorageKey string `json:"object_storage_key"`
 	Brief            string `json:"brief"`
+	// Encoding is the encoding of the request echoed by a bloc-server
+	// knowing it. the opt is persisted again uncompressed if it's not echoed
+	Encoding CompressEncoding `json:"encoding"`
 }
 
 type FuncRunOptPersistToObjectStorageHttpResp struct {
 	StatusCode int                          `json:"status_code"`
+	StatusMsg  string                       `json:"status_msg"`
 	Data       FuncOptFieldServerPersisResp `json:"data"`
 }
 
-func (bC *blocClient) PersistFunctionRunOptFieldToServer(
-	funcRunRecordID string, OptFieldKey string,
-	OptFieldValue interface{},
+func (resp *FuncRunOptPersistToObjectStorageHttpResp) serverStatus() (int, string) {
+	return resp.StatusCode, resp.StatusMsg
+}
+
+func (hSC *httpServerClient) PersistFunctionRunOptField(
+	ctx context.Context, req FuncRunOptPersistToObjectStorageHttpReq,
+) (*FuncOptFieldServerPersisResp, error) {
+	if req.Encoding != compress.Identity && atomic.LoadInt32(&hSC.compressUnsupported) == 1 {
+		if err := decompressOpt(&req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := hSC.persistFunctionRunOptField(ctx, req)
+	if err != nil || req.Encoding == compress.Identity || resp.Encoding == req.Encoding {
+		return resp, err
+	}
+
+	// the server does not know the encoding, and kept the compressed bytes
+	// as the value. overwritten by the uncompressed one
+	if atomic.CompareAndSwapInt32(&hSC.compressUnsupported, 0, 1) {
+		log.Printf("bloc-server does not support compressed opts, they are persisted uncompressed")
+	}
+	if err := decompressOpt(&req); err != nil {
+		return nil, err
+	}
+	return hSC.persistFunctionRunOptField(ctx, req)
+}
+
+func (hSC *httpServerClient) persistFunctionRunOptField(
+	ctx context.Context, req FuncRunOptPersistToObjectStorageHttpReq,
 ) (*FuncOptFieldServerPersisResp, error) {
-	req := FuncRunOptPersistToObjectStorageHttpReq{
-		FunctionRunRecordID: funcRunRecordID,
-		OptKey:              OptFieldKey,
-		Data:                OptFieldValue}
 	reqBody, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
 
 	var resp FuncRunOptPersistToObjectStorageHttpResp
-	err = http_util.PostJson(
-		bC.GenReqServerPath(serverFuncRunOptPersistToObjectStoragePath),
+	err = hSC.client.PostJsonIdempotent(
+		ctx, hSC.apiPath(serverFuncRunOptPersistToObjectStoragePath),
 		http_util.BlankHeader, reqBody, &resp)
-	if err != nil {
+	if err := checkResp(serverFuncRunOptPersistToObjectStoragePath, err, &resp); err != nil {
 		return nil, err
 	}
 	return &resp.Data, nil
 }
+
+// decompressOpt sets the data of req back to the json of the opt value.
+// the compressed data is base64 of json if req is decoded from json
+func decompressOpt(req *FuncRunOptPersistToObjectStorageHttpReq) error {
+	var encoded []byte
+	switch data := req.Data.(type) {
+	case []byte:
+		encoded = data
+	case string:
+		var err error
+		if encoded, err = base64.StdEncoding.DecodeString(data); err != nil {
+			return errors.Wrap(err, "decode compressed opt failed")
+		}
+	default:
+		return errors.Errorf("compressed opt should be bytes, get %T", req.Data)
+	}
+	decoded, err := compress.Decode(req.Encoding, encoded)
+	if err != nil {
+		return err
+	}
+	req.Data = json.RawMessage(decoded)
+	req.Encoding = compress.Identity
+	return nil
+}
+
+func (bC *blocClient) PersistFunctionRunOptFieldToServer(
+	funcRunRecordID string, OptFieldKey string,
+	OptFieldValue interface{},
+) (*FuncOptFieldServerPersisResp, error) {
+	return bC.PersistFunctionRunOptFieldToServerCtx(
+		context.Background(), funcRunRecordID, OptFieldKey, OptFieldValue)
+}
+
+// PersistFunctionRunOptFieldToServerCtx is PersistFunctionRunOptFieldToServer bounded by ctx
+func (bC *blocClient) PersistFunctionRunOptFieldToServerCtx(
+	ctx context.Context,
+	funcRunRecordID string, OptFieldKey string,
+	OptFieldValue interface{},
+) (*FuncOptFieldServerPersisResp, error) {
+	req := FuncRunOptPersistToObjectStorageHttpReq{
+		FunctionRunRecordID: funcRunRecordID,
+		OptKey:              OptFieldKey,
+		Data:                OptFieldValue}
+	if !bC.configBuilder.CompressConf.IsNil() {
+		valueByte, err := json.Marshal(OptFieldValue)
+		if err != nil {
+			return nil, err
+		}
+		encoded, encoding, err := bC.compressIfLarge(valueByte)
+		if err != nil {
+			return nil, err
+		}
+		if encoding != compress.Identity {
+			req.Data = encoded
+			req.Encoding = encoding
+		}
+	}
+	return bC.ServerClient().PersistFunctionRunOptField(ctx, req)
+}
@@ -0,0 +1,350 @@
+package bloc_client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/fBloc/bloc-client-go/internal/http_util"
+
+	"github.com/pkg/errors"
+)
+
+const serverStreamPath = "stream"
+
+var errStreamingNotAuthenticated = errors.New(
+	"streaming to bloc-server can not be authenticated by the auth set, like HMACAuth which signs the body")
+
+// ServerStreamConfig sends progress, logs & heartbeats of all runs through
+// one streaming request of newline delimited json frames, instead of a post
+// for each of them. frames fall back to posts if the server does not
+// support streaming, the stream is broken, or the stream is busy.
+// streamed frames are sent at most once like logs, those being written when
+// the stream breaks are lost. the finished report of a run is posted after
+// the frames queued before it are sent.
+type ServerStreamConfig struct {
+	// HeartbeatInterval is how often a heartbeat frame is sent, defaults to 15s
+	HeartbeatInterval time.Duration
+	// WriteTimeout is the max time writing a frame to the stream, the stream
+	// is taken as broken beyond it. defaults to 10s
+	WriteTimeout time.Duration
+	// FlushTimeout is the max wait for the queued frames sent before the
+	// finished report of a run, defaults to 10s
+	FlushTimeout time.Duration
+	// BufferSize is the frames waiting to be streamed, the ones beyond it
+	// are posted. defaults to 1024
+	BufferSize int
+	// ReconnectBackoff is the wait before reconnecting the broken stream,
+	// doubled each time up to MaxReconnectBackoff. defaults to 1s & 1min
+	ReconnectBackoff    time.Duration
+	MaxReconnectBackoff time.Duration
+}
+
+var DefaultServerStreamConfig = ServerStreamConfig{
+	HeartbeatInterval:   15 * time.Second,
+	WriteTimeout:        10 * time.Second,
+	FlushTimeout:        10 * time.Second,
+	BufferSize:          1024,
+	ReconnectBackoff:    time.Second,
+	MaxReconnectBackoff: time.Minute}
+
+// WithServerStreaming streams progress & logs to bloc-server, see ServerStreamConfig.
+// the auth should be able to authenticate the stream, HMACAuth is not
+func WithServerStreaming(conf ServerStreamConfig) ServerOption {
+	return func(bSC *BlocServerConfig) {
+		bSC.Streaming = &conf
+	}
+}
+
+const (
+	streamFrameProgress  = "progress"
+	streamFrameLog       = "log"
+	streamFrameHeartbeat = "heartbeat"
+)
+
+// streamFrame is a line of the stream
+type streamFrame struct {
+	Type                string                           `json:"type"`
+	FunctionRunRecordID string                           `json:"function_run_record_id,omitempty"`
+	TraceID             string                           `json:"trace_id,omitempty"`
+	SpanID              string                           `json:"span_id,omitempty"`
+	Progress            *HighReadableFunctionRunProgress `json:"progress,omitempty"`
+	Logs                []*LogMsg                        `json:"logs,omitempty"`
+	Time                time.Time                        `json:"time"`
+	// flushed is closed once the frames queued before it are sent,
+	// it's not sent itself
+	flushed chan struct{}
+}
+
+// streamServerClient streams progress & logs, other calls are posted
+type streamServerClient struct {
+	*httpServerClient
+	conf   ServerStreamConfig
+	frames chan *streamFrame
+	// up is 1 while the stream is connecting or connected
+	up int32
+}
+
+func newStreamServerClient(
+	hSC *httpServerClient, conf ServerStreamConfig,
+) *streamServerClient {
+	if conf.HeartbeatInterval <= 0 {
+		conf.HeartbeatInterval = DefaultServerStreamConfig.HeartbeatInterval
+	}
+	if conf.WriteTimeout <= 0 {
+		conf.WriteTimeout = DefaultServerStreamConfig.WriteTimeout
+	}
+	if conf.FlushTimeout <= 0 {
+		conf.FlushTimeout = DefaultServerStreamConfig.FlushTimeout
+	}
+	if conf.BufferSize <= 0 {
+		conf.BufferSize = DefaultServerStreamConfig.BufferSize
+	}
+	if conf.ReconnectBackoff <= 0 {
+		conf.ReconnectBackoff = DefaultServerStreamConfig.ReconnectBackoff
+	}
+	if conf.MaxReconnectBackoff <= 0 {
+		conf.MaxReconnectBackoff = DefaultServerStreamConfig.MaxReconnectBackoff
+	}
+	sSC := &streamServerClient{
+		httpServerClient: hSC,
+		conf:             conf,
+		frames:           make(chan *streamFrame, conf.BufferSize)}
+	go sSC.keepStreaming()
+	return sSC
+}
+
+func (sSC *streamServerClient) ReportFuncRunProgress(
+	ctx context.Context, req FuncRunProgressHttpReq,
+) error {
+	return sSC.send(&streamFrame{
+		Type:                streamFrameProgress,
+		FunctionRunRecordID: req.FunctionRunRecordID,
+		TraceID:             GetTraceIDFromContext(ctx),
+		SpanID:              GetSpanIDFromContext(ctx),
+		Progress:            &req.FuncRunProgress,
+		Time:                time.Now()})
+}
+
+func (sSC *streamServerClient) UploadLogs(ctx context.Context, logs []*LogMsg) error {
+	return sSC.send(&streamFrame{
+		Type:    streamFrameLog,
+		TraceID: GetTraceIDFromContext(ctx),
+		SpanID:  GetSpanIDFromContext(ctx),
+		Logs:    logs,
+		Time:    time.Now()})
+}
+
+// ReportFuncRunFinished is posted after the progress queued before it,
+// so that the server never gets progress of a finished run
+func (sSC *streamServerClient) ReportFuncRunFinished(
+	ctx context.Context, req FuncRunFinishedHttpReq,
+) error {
+	flushCtx, cancel := context.WithTimeout(ctx, sSC.conf.FlushTimeout)
+	defer cancel()
+	if err := sSC.flush(flushCtx); err != nil {
+		log.Printf("flush frames before the finished report failed: %v", err)
+	}
+	return sSC.httpServerClient.ReportFuncRunFinished(ctx, req)
+}
+
+// flush returns after the frames queued before it are sent, or ctx is done
+func (sSC *streamServerClient) flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case sSC.frames <- &streamFrame{flushed: flushed}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// send streams the frame if the stream is up & not busy, otherwise posts it
+func (sSC *streamServerClient) send(frame *streamFrame) error {
+	if atomic.LoadInt32(&sSC.up) == 1 {
+		select {
+		case sSC.frames <- frame:
+			return nil
+		default:
+		}
+	}
+	return sSC.post(frame)
+}
+
+func (sSC *streamServerClient) post(frame *streamFrame) error {
+	if frame.flushed != nil {
+		close(frame.flushed)
+		return nil
+	}
+	ctx := SetTraceIDAndSpanIDToContext(frame.TraceID, frame.SpanID)
+	switch frame.Type {
+	case streamFrameProgress:
+		return sSC.httpServerClient.ReportFuncRunProgress(ctx, FuncRunProgressHttpReq{
+			FunctionRunRecordID: frame.FunctionRunRecordID,
+			FuncRunProgress:     *frame.Progress})
+	case streamFrameLog:
+		return sSC.httpServerClient.UploadLogs(ctx, frame.Logs)
+	}
+	return nil
+}
+
+// streamRefused is true if the server responds it has no stream api,
+// or the stream is not authenticated, which reconnecting does not help
+func streamRefused(err error) bool {
+	if errors.Is(err, http_util.ErrStreamNotAuthenticated) {
+		return true
+	}
+	var statusErr *http_util.StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	switch statusErr.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented,
+		http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+	return false
+}
+
+// keepStreaming reconnects the broken stream with backoff,
+// until the server refuses it. the frames queued meanwhile are posted
+func (sSC *streamServerClient) keepStreaming() {
+	backoff := sSC.conf.ReconnectBackoff
+	for {
+		streamed, err := sSC.stream()
+		if streamRefused(err) {
+			log.Printf("bloc-server refuses streaming, fall back to posts: %v", err)
+			for frame := range sSC.frames {
+				sSC.postLogged(frame)
+			}
+			return
+		}
+		if streamed > 0 {
+			backoff = sSC.conf.ReconnectBackoff
+			log.Printf("stream to bloc-server broken, reconnecting: %v", err)
+		}
+		sSC.drainFor(backoff)
+		backoff *= 2
+		if backoff > sSC.conf.MaxReconnectBackoff {
+			backoff = sSC.conf.MaxReconnectBackoff
+		}
+	}
+}
+
+// stream sends frames through one streaming request until it's broken,
+// returns the amount of frames streamed. frames left are posted then
+func (sSC *streamServerClient) stream() (streamed int, err error) {
+	reader, writer := io.Pipe()
+	// canceled to end the request if the server stalls
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	respErrChan := make(chan error, 1)
+	atomic.StoreInt32(&sSC.up, 1)
+	go func() {
+		resp, err := sSC.client.Stream(
+			ctx, sSC.apiPath(serverStreamPath),
+			map[string]string{"Content-Type": "application/x-ndjson"}, reader)
+		if err == nil {
+			// the server sends nothing back, a dead server is detected by
+			// the write timeout of the frames & heartbeats
+			_, err = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err == nil {
+			err = errors.New("stream closed by server")
+		}
+		// fails the blocking write
+		reader.CloseWithError(err)
+		respErrChan <- err
+	}()
+
+	streamed, err = sSC.writeFrames(writer, respErrChan)
+	atomic.StoreInt32(&sSC.up, 0)
+	writer.CloseWithError(err)
+	if err == nil {
+		cancel()
+		err = <-respErrChan
+	}
+	sSC.drain()
+	return streamed, err
+}
+
+var errStreamWriteTimeout = errors.New("write frame to stream timeout")
+
+// writeFrames returns nil error if the frame failed to be written,
+// the error is from the request then
+func (sSC *streamServerClient) writeFrames(
+	writer *io.PipeWriter, respErrChan chan error,
+) (streamed int, err error) {
+	encoder := json.NewEncoder(writer)
+	ticker := time.NewTicker(sSC.conf.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		var frame *streamFrame
+		select {
+		case frame = <-sSC.frames:
+		case <-ticker.C:
+			frame = &streamFrame{Type: streamFrameHeartbeat, Time: time.Now()}
+		case err := <-respErrChan:
+			return streamed, err
+		}
+		if frame.flushed != nil {
+			// the frames before it are written
+			close(frame.flushed)
+			continue
+		}
+		// fails the blocking write if the server stalls
+		timer := time.AfterFunc(sSC.conf.WriteTimeout, func() {
+			writer.CloseWithError(errStreamWriteTimeout)
+		})
+		err := encoder.Encode(frame)
+		timer.Stop()
+		if err != nil {
+			sSC.postLogged(frame)
+			return streamed, nil
+		}
+		streamed++
+	}
+}
+
+func (sSC *streamServerClient) postLogged(frame *streamFrame) {
+	if err := sSC.post(frame); err != nil {
+		log.Printf("post %s frame failed: %v", frame.Type, err)
+	}
+}
+
+// drain posts the frames waiting to be streamed
+func (sSC *streamServerClient) drain() {
+	for {
+		select {
+		case frame := <-sSC.frames:
+			sSC.postLogged(frame)
+		default:
+			return
+		}
+	}
+}
+
+// drainFor posts the frames queued in d
+func (sSC *streamServerClient) drainFor(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case frame := <-sSC.frames:
+			sSC.postLogged(frame)
+		case <-timer.C:
+			return
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package bloc_client
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func withStreaming(cb *ConfigBuilder) {
+	cb.ServerConf.Streaming = &ServerStreamConfig{
+		HeartbeatInterval: 20 * time.Millisecond,
+		ReconnectBackoff:  10 * time.Millisecond}
+}
+
+func runSumFunction(t *testing.T, client *blocClient, server *mockServer, eventMQ *MemoryMsgQueue) {
+	server.setObjectStorageValue("numbers_key", []int{1, 2, 3})
+	server.addFunctionRunRecord(&FunctionRunRecord{
+		ID:         "record_1",
+		FunctionID: "math-sum",
+		TraceID:    "trace_1",
+		IptBriefAndObjectStoragekey: [][]briefAndKey{
+			{{ObjectStorageKey: "numbers_key"}}},
+	})
+	publishClientRunFunction(t, eventMQ, "record_1")
+	go client.FunctionRunConsumer()
+
+	if finished := waitFinished(t, server); !finished.Suc {
+		t.Fatalf("function run should suc: %+v", finished)
+	}
+	waitAllAcked(t, eventMQ)
+}
+
+func TestServerStreaming(t *testing.T) {
+	client, server, eventMQ := newMockClient(t, withStreaming)
+	runSumFunction(t, client, server, eventMQ)
+
+	received := make(map[string]bool)
+	deadline := time.After(3 * time.Second)
+	for !received[streamFrameProgress] || !received[streamFrameLog] || !received[streamFrameHeartbeat] {
+		select {
+		case frame := <-server.streamed:
+			received[frame.Type] = true
+			if frame.Type == streamFrameProgress &&
+				(frame.FunctionRunRecordID != "record_1" || frame.TraceID != "trace_1" ||
+					frame.Progress.Progress != 50) {
+				t.Errorf("unexpected progress frame: %+v", frame)
+			}
+		case <-deadline:
+			t.Fatalf("wait frames timeout, received: %v", received)
+		}
+	}
+	if posted := server.getPosted("report_progress") + server.getPosted(logSubPath); posted != 0 {
+		t.Errorf("progress & logs should be streamed, posted %d times", posted)
+	}
+}
+
+func TestServerStreamingFallback(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusUnauthorized} {
+		server := newMockServer(t)
+		server.streamRefusedStatus = status
+		client, _, eventMQ := newMockClientOf(t, server, withStreaming)
+		runSumFunction(t, client, server, eventMQ)
+
+		deadline := time.Now().Add(3 * time.Second)
+		for server.getPosted("report_progress") == 0 || server.getPosted(logSubPath) == 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("progress & logs should fall back to posts, posted %d & %d times",
+					server.getPosted("report_progress"), server.getPosted(logSubPath))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		// longer than the reconnect backoff
+		time.Sleep(50 * time.Millisecond)
+		if attempts := server.getPosted(serverStreamPath); attempts != 1 {
+			t.Errorf("refused stream by %d should not reconnect, attempted %d times", status, attempts)
+		}
+	}
+}
+
+func TestServerStreamingWithHMACAuth(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("BuildUp should panic as HMACAuth can not authenticate the stream")
+		}
+	}()
+	NewClient(mockClientName).GetConfigBuilder().
+		SetServer("127.0.0.1", 8080,
+			WithServerAuth(HMACAuth(mockClientName, []byte("secret"))),
+			WithServerStreaming(ServerStreamConfig{})).
+		SetEventMQ(NewMemoryMsgQueue()).BuildUp()
+}
+
+func TestServerStreamingFlushesBeforeFinished(t *testing.T) {
+	server := newMockServer(t)
+	serverClient, err := NewHTTPServerClient(server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not streaming, the queued frames are only sent by drain
+	sSC := &streamServerClient{
+		httpServerClient: serverClient.(*httpServerClient),
+		conf:             ServerStreamConfig{FlushTimeout: 5 * time.Second},
+		frames:           make(chan *streamFrame, 10),
+		up:               1}
+	ctx := SetTraceIDAndSpanIDToContext("trace_1", "span_1")
+	for i := 1; i <= 3; i++ {
+		sSC.ReportFuncRunProgress(ctx, FuncRunProgressHttpReq{
+			FunctionRunRecordID: "record_1",
+			FuncRunProgress:     HighReadableFunctionRunProgress{Progress: float32(i * 10)}})
+	}
+	go sSC.ReportFuncRunFinished(ctx, FuncRunFinishedHttpReq{FunctionRunRecordID: "record_1", Suc: true})
+
+	select {
+	case finished := <-server.finished:
+		t.Fatalf("finished should wait for the queued progress: %+v", finished)
+	case <-time.After(100 * time.Millisecond):
+	}
+	sSC.drain()
+	waitFinished(t, server)
+	if posted := server.getPosted("report_progress"); posted != 3 {
+		t.Errorf("queued progress should be sent before finished, sent %d", posted)
+	}
+}
+
+func TestServerStreamingWriteTimeout(t *testing.T) {
+	server := newMockServer(t)
+	server.streamStalled = true
+	serverClient, err := NewHTTPServerClient(server.URL, nil, WithServerStreaming(ServerStreamConfig{
+		WriteTimeout:     50 * time.Millisecond,
+		ReconnectBackoff: 10 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sSC := serverClient.(*streamServerClient)
+	waitStreamUp(t, sSC)
+
+	// more than the connection buffers, so the write blocks
+	line := strings.Repeat("x", 64<<10)
+	for i := 0; i < 256; i++ {
+		sSC.UploadLogs(context.Background(), []*LogMsg{{Data: line}})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for server.getPosted(serverStreamPath) < 2 || server.getPosted(logSubPath) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("stalled stream should be broken & reconnected, frames posted, attempted %d & posted %d",
+				server.getPosted(serverStreamPath), server.getPosted(logSubPath))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerStreamingNotTimedOutByHTTPClient(t *testing.T) {
+	server := newMockServer(t)
+	serverClient, err := NewHTTPServerClient(
+		server.URL, &http.Client{Timeout: 50 * time.Millisecond},
+		WithServerStreaming(ServerStreamConfig{
+			HeartbeatInterval: 10 * time.Millisecond,
+			ReconnectBackoff:  10 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStreamUp(t, serverClient.(*streamServerClient))
+
+	time.Sleep(300 * time.Millisecond)
+	if attempts := server.getPosted(serverStreamPath); attempts != 1 {
+		t.Errorf("stream should not be broken by the timeout of http client, attempted %d times", attempts)
+	}
+}
+
+func waitStreamUp(t *testing.T, sSC *streamServerClient) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&sSC.up) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("wait stream up timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
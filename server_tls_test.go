@@ -0,0 +1,123 @@
+package bloc_client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeClientCert writes a self-signed client cert & it's key to dir
+func writeClientCert(t *testing.T, dir string) (cert *x509.Certificate, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: mockClientName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(certDER)
+
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", certDER)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return cert, certFile, keyFile
+}
+
+// newMockTLSServer requires client certificates signed by clientCA if it's not nil
+func newMockTLSServer(t *testing.T, clientCA *x509.Certificate) (s *mockServer, caFile string) {
+	s = newUnstartedMockServer(t)
+	if clientCA != nil {
+		s.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+		s.TLS.ClientCAs.AddCert(clientCA)
+	}
+	s.StartTLS()
+
+	caFile = filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", s.Certificate().Raw)
+	return s, caFile
+}
+
+func registerTo(s *mockServer, tlsConf ServerTLSConfig) error {
+	client := NewClient(mockClientName)
+	ip, port := s.ipAndPort()
+	client.GetConfigBuilder().SetServer(ip, port, WithServerTLS(tlsConf)).
+		SetEventMQ(NewMemoryMsgQueue()).BuildUp()
+	client.RegisterFunctionGroup("math").AddFunction("sum", "sum numbers", &sumFunction{})
+	return client.RegisterFunctionsToServer()
+}
+
+func TestServerTLS(t *testing.T) {
+	s, caFile := newMockTLSServer(t, nil)
+
+	// the certificate of httptest is for example.com & 127.0.0.1
+	if err := registerTo(s, ServerTLSConfig{CAFile: caFile, ServerName: "example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if s.getRegistered() == nil {
+		t.Fatal("functions should be registered through https")
+	}
+	if err := registerTo(s, ServerTLSConfig{ServerName: "example.com"}); err == nil {
+		t.Error("server certificate not signed by the CA should be rejected")
+	}
+	if err := registerTo(s, ServerTLSConfig{CAFile: caFile, ServerName: "bloc.io"}); err == nil {
+		t.Error("server certificate not for the server name should be rejected")
+	}
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	clientCert, certFile, keyFile := writeClientCert(t, t.TempDir())
+	s, caFile := newMockTLSServer(t, clientCert)
+
+	if err := registerTo(s, ServerTLSConfig{CAFile: caFile}); err == nil {
+		t.Error("server requiring client certificate should reject the client without one")
+	}
+	err := registerTo(s, ServerTLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.getRegistered() == nil {
+		t.Fatal("functions should be registered through mutual TLS")
+	}
+}
+
+func TestServerTLSInvalidFile(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("BuildUp should panic on missing CA file")
+		}
+	}()
+	NewClient(mockClientName).GetConfigBuilder().
+		SetServer("127.0.0.1", 8080, WithServerTLS(ServerTLSConfig{CAFile: "not_exist.pem"})).
+		SetEventMQ(NewMemoryMsgQueue()).BuildUp()
+}
@@ -0,0 +1,96 @@
+package bloc_client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newMockServerBehindPrefix serves the mock server under prefix like an ingress
+func newMockServerBehindPrefix(t *testing.T, prefix string) *mockServer {
+	s := newUnstartedMockServer(t)
+	s.Config.Handler = http.StripPrefix(prefix, http.HandlerFunc(s.serve))
+	s.Start()
+	return s
+}
+
+func TestServerURLWithPrefixAndFailover(t *testing.T) {
+	server := newMockServerBehindPrefix(t, "/bloc")
+	// nothing listens at the first replica
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := listener.Addr().String()
+	listener.Close()
+
+	eventMQ := NewMemoryMsgQueue()
+	t.Cleanup(eventMQ.Close)
+	client := NewClient(mockClientName)
+	client.GetConfigBuilder().SetServerURL(
+		[]string{"http://" + downAddr, server.URL + "/bloc/"},
+		WithServerRetry(
+			ServerRetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			ServerRetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}),
+	).SetEventMQ(eventMQ).BuildUp()
+	client.RegisterFunctionGroup("math").AddFunction("sum", "sum numbers", &sumFunction{})
+	if err := client.RegisterFunctionsToServer(); err != nil {
+		t.Fatalf("register should fail over to the replica alive: %v", err)
+	}
+
+	server.setObjectStorageValue("numbers_key", []int{1, 2, 3})
+	server.addFunctionRunRecord(&FunctionRunRecord{
+		ID:         "record_1",
+		FunctionID: "math-sum",
+		IptBriefAndObjectStoragekey: [][]briefAndKey{
+			{{ObjectStorageKey: "numbers_key"}}},
+	})
+	publishClientRunFunction(t, eventMQ, "record_1")
+	go client.FunctionRunConsumer()
+
+	if finished := waitFinished(t, server); !finished.Suc || finished.OptKeyMapBriefData["sum"] != "6" {
+		t.Fatalf("function run should suc: %+v", finished)
+	}
+	waitAllAcked(t, eventMQ)
+
+	if url := client.GenReqServerPath(FuncRunStartHttpPath); url != "http://"+downAddr+"/api/v1/client/function_run_start" {
+		t.Errorf("unexpected server path: %s", url)
+	}
+}
+
+func TestServerURLInvalid(t *testing.T) {
+	for _, serverURL := range []string{"ftp://server", "http://server?a=1"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("BuildUp should panic for invalid server url %s", serverURL)
+				}
+			}()
+			NewClient(mockClientName).GetConfigBuilder().
+				SetServerURL([]string{serverURL}).
+				SetEventMQ(NewMemoryMsgQueue()).BuildUp()
+		}()
+	}
+}
+
+func TestNewHTTPServerClientByURL(t *testing.T) {
+	server := newMockServerBehindPrefix(t, "/bloc")
+	server.addFunctionRunRecord(&FunctionRunRecord{ID: "record_1", FunctionID: "math-sum"})
+
+	serverClient, err := NewHTTPServerClient(server.URL+"/bloc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := serverClient.GetFunctionRunRecord(context.Background(), "record_1")
+	if err != nil || record.FunctionID != "math-sum" {
+		t.Fatalf("get record by url with prefix failed: %+v, %v", record, err)
+	}
+
+	ip, port := newMockServer(t).ipAndPort()
+	if _, err := NewHTTPServerClient(net.JoinHostPort(ip, strconv.Itoa(port)), nil); err != nil {
+		t.Errorf("ip:port should still be accepted: %v", err)
+	}
+}
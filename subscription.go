@@ -0,0 +1,103 @@
+package bloc_client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fBloc/bloc-client-go/internal/event"
+	"github.com/pkg/errors"
+)
+
+// FunctionSubscription selects a function group, or a single function of it
+// if FunctionName is set, for this replica to run.
+type FunctionSubscription struct {
+	GroupName    string
+	FunctionName string
+}
+
+// Topic is the routing key runs of the subscription are published to
+func (fS FunctionSubscription) Topic(clientName string) string {
+	return event.ClientRunFunctionTopic(clientName, fS.GroupName, fS.FunctionName)
+}
+
+// queueName is the queue the replicas of the same subscription share
+func (fS FunctionSubscription) queueName(clientName string) string {
+	return strings.TrimPrefix(fS.Topic(clientName), "function_client_run_consumer.")
+}
+
+// checkRoutingName panics if name cannot be a segment of the routing key
+func checkRoutingName(kind, name string) {
+	if name == "" || strings.ContainsAny(name, ".*#") {
+		panic(fmt.Sprintf("%s name should be non-empty and without '.', '*' or '#', get: %q", kind, name))
+	}
+}
+
+func (fS FunctionSubscription) covers(f *Function) bool {
+	return fS.GroupName == f.GroupName &&
+		(fS.FunctionName == "" || fS.FunctionName == f.Name)
+}
+
+func (bC *blocClient) subscriptions() []FunctionSubscription {
+	if bC.configBuilder == nil {
+		return nil
+	}
+	return bC.configBuilder.Subscriptions
+}
+
+// subscriptionOf returns the subscription the function's runs are consumed by.
+// the function subscription is preferred to the group one.
+// ok is false if the client subscribed to others only
+func (bC *blocClient) subscriptionOf(f *Function) (subscription FunctionSubscription, ok bool) {
+	for _, s := range bC.subscriptions() {
+		if !s.covers(f) {
+			continue
+		}
+		if !ok || s.FunctionName != "" {
+			subscription, ok = s, true
+		}
+	}
+	return subscription, ok
+}
+
+// routingKeyOf returns the routing key reported to bloc-server for
+// publishing runs of the function. empty means the client's topic
+func (bC *blocClient) routingKeyOf(f *Function) string {
+	subscription, ok := bC.subscriptionOf(f)
+	if !ok {
+		return ""
+	}
+	return subscription.Topic(bC.Name)
+}
+
+// isSubscribed reports whether the replica runs the function
+func (bC *blocClient) isSubscribed(f *Function) bool {
+	if len(bC.subscriptions()) == 0 {
+		return true
+	}
+	_, ok := bC.subscriptionOf(f)
+	return ok
+}
+
+// checkSubscriptions makes sure every subscription is registered
+func (bC *blocClient) checkSubscriptions() error {
+	for _, s := range bC.subscriptions() {
+		found := false
+		for _, group := range bC.FunctionGroups {
+			if group.Name != s.GroupName {
+				continue
+			}
+			for _, f := range group.Functions {
+				if s.covers(f) {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return errors.Errorf(
+				"subscribed function not registered: group-%s, function-%s",
+				s.GroupName, s.FunctionName)
+		}
+	}
+	return nil
+}
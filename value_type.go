@@ -9,4 +9,6 @@ const (
 	StringValueType ValueType = "string"
 	BoolValueType   ValueType = "bool"
 	JsonValueType   ValueType = "json"
+	// FileValueType opt's value is an Artifact written by ArtifactWriter
+	FileValueType ValueType = "file"
 )